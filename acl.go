@@ -0,0 +1,346 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"slices"
+	"strings"
+	s "sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	aclActionView   = "view"
+	aclActionSync   = "sync"
+	aclActionCancel = "cancel"
+	aclActionRemove = "remove"
+
+	accessContextKey = "auth.access"
+
+	// aclReloadDelay lets an editor finish writing the ACL file before it is
+	// read again.
+	aclReloadDelay = 500 * time.Millisecond
+)
+
+var aclActions = []string{aclActionView, aclActionSync, aclActionCancel, aclActionRemove}
+
+// ACLRule grants the listed users, by email or subject, and the members of the
+// listed groups Actions on everything under Paths. Any action implies view.
+type ACLRule struct {
+	Users   []string `json:"users,omitempty"`
+	Groups  []string `json:"groups,omitempty"`
+	Paths   []string `json:"paths"`
+	Actions []string `json:"actions"`
+}
+
+type ACLFile struct {
+	Rules []ACLRule `json:"rules"`
+}
+
+func (rule ACLRule) matches(user User) bool {
+	for _, name := range rule.Users {
+		if strings.EqualFold(name, user.Email) || name == user.Subject {
+			return true
+		}
+	}
+	for _, group := range rule.Groups {
+		if slices.Contains(user.Groups, group) {
+			return true
+		}
+	}
+	return false
+}
+
+// accessControl holds the rules of the ACL file and reloads them whenever the
+// file changes. A file that fails to load leaves the rules in effect alone.
+// Users no rule matches see nothing, except admins who see everything.
+type accessControl struct {
+	logger  *slog.Logger
+	path    string
+	remotes *remoteSet
+	rules   []ACLRule
+	s.Mutex
+}
+
+// newAccessControl loads the ACL file. Without one it returns nil and paths
+// are not restricted.
+func newAccessControl(logger *slog.Logger, config Config, remotes *remoteSet) (*accessControl, error) {
+	if config.ACLFile == "" {
+		return nil, nil
+	}
+	path, err := filepath.Abs(config.ACLFile)
+	if err != nil {
+		return nil, fmt.Errorf("abs path: %w", err)
+	}
+
+	ac := &accessControl{
+		logger:  logger,
+		path:    path,
+		remotes: remotes,
+	}
+	ac.rules, err = ac.load()
+	if err != nil {
+		return nil, err
+	}
+	return ac, nil
+}
+
+func (ac *accessControl) load() ([]ACLRule, error) {
+	var file ACLFile
+	if err := readJSONFile(ac.path, &file); err != nil {
+		return nil, fmt.Errorf("load acl: %w", err)
+	}
+
+	var errs []error
+	for i, rule := range file.Rules {
+		if len(rule.Users) == 0 && len(rule.Groups) == 0 {
+			errs = append(errs, fmt.Errorf("rule %d applies to no user or group", i+1))
+		}
+		if len(rule.Paths) == 0 || len(rule.Actions) == 0 {
+			errs = append(errs, fmt.Errorf("rule %d needs paths and actions", i+1))
+		}
+		for _, action := range rule.Actions {
+			if !slices.Contains(aclActions, action) {
+				errs = append(errs, fmt.Errorf("rule %d: unknown action %q", i+1, action))
+			}
+		}
+		for j, path := range rule.Paths {
+			remote, remotePath, err := ac.remotes.Resolve(path)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("rule %d: %w", i+1, err))
+				continue
+			}
+			file.Rules[i].Paths[j] = remote.Address(remotePath)
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	return file.Rules, nil
+}
+
+// Run reloads the ACL file on changes until ctx is done. The directory is
+// watched rather than the file, editors tend to replace files on save.
+func (ac *accessControl) Run(ctx context.Context) {
+	if ac == nil {
+		return
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		ac.logger.Error("acl watcher failed", slog.String("error", err.Error()))
+		return
+	}
+	defer watcher.Close()
+	if err := watcher.Add(filepath.Dir(ac.path)); err != nil {
+		ac.logger.Error("watch acl file failed", slog.String("path", ac.path), slog.String("error", err.Error()))
+		return
+	}
+
+	var reload <-chan time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-reload:
+			reload = nil
+			ac.reload()
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
+			if event.Name == ac.path && (event.Has(fsnotify.Create) || event.Has(fsnotify.Write)) && reload == nil {
+				reload = time.After(aclReloadDelay)
+			}
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return
+			}
+			ac.logger.Warn("acl watcher failed, reloading", slog.String("error", err.Error()))
+			ac.reload()
+		}
+	}
+}
+
+func (ac *accessControl) reload() {
+	rules, err := ac.load()
+	if err != nil {
+		ac.logger.Error("acl reload failed, keeping previous rules", slog.String("error", err.Error()))
+		return
+	}
+
+	ac.Lock()
+	ac.rules = rules
+	ac.Unlock()
+	ac.logger.Info("acl reloaded", slog.Int("rules", len(rules)))
+}
+
+// For returns what user may do where, nil when they are not restricted.
+func (ac *accessControl) For(user User) *pathAccess {
+	if ac == nil || user.Role == roleAdmin {
+		return nil
+	}
+
+	ac.Lock()
+	defer ac.Unlock()
+
+	access := &pathAccess{grants: map[string]map[string]bool{}}
+	for _, rule := range ac.rules {
+		if !rule.matches(user) {
+			continue
+		}
+		for _, path := range rule.Paths {
+			if access.grants[path] == nil {
+				access.grants[path] = map[string]bool{}
+			}
+			for _, action := range rule.Actions {
+				access.grants[path][action] = true
+			}
+		}
+	}
+	return access
+}
+
+// pathAccess maps the directories one user was granted to the actions they may
+// take on them and everything below. A nil pathAccess allows everything.
+type pathAccess struct {
+	grants map[string]map[string]bool
+}
+
+// requestAccess returns the access of the user behind a request, nil when
+// authentication or ACLs are disabled.
+func requestAccess(c echo.Context) *pathAccess {
+	access, _ := c.Get(accessContextKey).(*pathAccess)
+	return access
+}
+
+// pathWithin reports whether the address path is dir or below it.
+func pathWithin(path, dir string) bool {
+	return path == dir || strings.HasPrefix(path, strings.TrimSuffix(dir, "/")+"/")
+}
+
+func (pa *pathAccess) Allows(action, path string) bool {
+	if pa == nil {
+		return true
+	}
+	for dir, actions := range pa.grants {
+		if pathWithin(path, dir) && (action == aclActionView || actions[action]) {
+			return true
+		}
+	}
+	return false
+}
+
+// Visible reports whether path shows up at all: it is granted, or it is on
+// the way to a granted directory.
+func (pa *pathAccess) Visible(path string) bool {
+	if pa.Allows(aclActionView, path) {
+		return true
+	}
+	for dir := range pa.grants {
+		if pathWithin(dir, path) {
+			return true
+		}
+	}
+	return false
+}
+
+// Actions lists what may be done on path, empty for directories that are only
+// visible on the way to a granted one.
+func (pa *pathAccess) Actions(path string) []string {
+	actions := make([]string, 0, len(aclActions))
+	for _, action := range aclActions {
+		if pa.Allows(action, path) {
+			actions = append(actions, action)
+		}
+	}
+	return actions
+}
+
+// authorize checks action on path. Paths the user cannot see are reported by
+// the caller like paths that do not exist, so they only get false, while a
+// visible path the action is not granted on gives errForbidden.
+func (pa *pathAccess) authorize(action, path string) (bool, error) {
+	if pa.Allows(action, path) {
+		return true, nil
+	}
+	if pa.Visible(path) {
+		return false, fmt.Errorf("%w: %s is not allowed on %s", errForbidden, action, path)
+	}
+	return false, nil
+}
+
+// prune copies item and the directories below it that are visible. Those only
+// on the way to a granted directory lose their own files, so statuses and
+// sizes worked out on the copy only count what the user may see.
+func (pa *pathAccess) prune(remote *Remote, item *Dir, parent *Dir) *Dir {
+	copied := &Dir{
+		Path:     item.Path,
+		Name:     item.Name,
+		Children: map[string]*Dir{},
+		Parent:   parent,
+	}
+	if pa.Allows(aclActionView, remote.Address(item.Path)) {
+		copied.Stats = item.Stats
+	}
+	for _, child := range item.Children {
+		if pa.Visible(remote.Address(child.Path)) {
+			copied.Children[child.Name] = pa.prune(remote, child, copied)
+		}
+	}
+	return copied
+}
+
+// pruneMap prunes every tree of pathMap and indexes the copies by path.
+func (pa *pathAccess) pruneMap(remote *Remote, pathMap map[string]*Dir) map[string]*Dir {
+	pruned := map[string]*Dir{}
+	var index func(item *Dir)
+	index = func(item *Dir) {
+		pruned[item.Path] = item
+		for _, child := range item.Children {
+			index(child)
+		}
+	}
+	for _, item := range pathMap {
+		if item.Parent == nil && pa.Visible(remote.Address(item.Path)) {
+			index(pa.prune(remote, item, nil))
+		}
+	}
+	return pruned
+}
+
+// event drops events about syncs the user cannot see.
+func (pa *pathAccess) event(event Event) (Event, bool) {
+	if pa == nil {
+		return event, true
+	}
+	if event.Sync != nil && !pa.Allows(aclActionView, event.Sync.Path) {
+		return Event{}, false
+	}
+	if event.Result != nil && !pa.Allows(aclActionView, event.Result.Path) {
+		return Event{}, false
+	}
+	if event.Syncs != nil {
+		event.Syncs = pa.syncs(event.Syncs)
+	}
+	return event, true
+}
+
+func (pa *pathAccess) syncs(results []SyncResult) []SyncResult {
+	if pa == nil {
+		return results
+	}
+	visible := make([]SyncResult, 0, len(results))
+	for _, result := range results {
+		if pa.Allows(aclActionView, result.Path) {
+			visible = append(visible, result)
+		}
+	}
+	return visible
+}
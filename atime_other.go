@@ -0,0 +1,14 @@
+//go:build !linux
+
+package main
+
+import (
+	"io/fs"
+	"time"
+)
+
+// accessTime falls back to the modification time where access times are not
+// read.
+func accessTime(info fs.FileInfo) time.Time {
+	return info.ModTime()
+}
@@ -11,7 +11,9 @@ import (
 	"log/slog"
 	"net/http"
 	"net/url"
+	"slices"
 	"strings"
+	s "sync"
 	"time"
 
 	"github.com/coreos/go-oidc/v3/oidc"
@@ -21,30 +23,38 @@ import (
 )
 
 const (
-	sessionCookieName   = "syncer_session"
-	stateCookieName     = "syncer_oidc_state"
-	nonceCookieName     = "syncer_oidc_nonce"
-	sessionContextKey   = "auth.user"
-	defaultSessionTTL   = 8 * time.Hour
-	defaultCookieMaxAge = int((8 * time.Hour) / time.Second)
+	sessionCookieName  = "syncer_session"
+	stateCookieName    = "syncer_oidc_state"
+	nonceCookieName    = "syncer_oidc_nonce"
+	verifierCookieName = "syncer_oidc_verifier"
+	sessionContextKey  = "auth.user"
+	defaultSessionTTL  = 8 * time.Hour
+	refreshTokenName   = "refresh_token"
+
+	// sessionRefreshMargin is how long before the ID token expires it is
+	// refreshed.
+	sessionRefreshMargin = time.Minute
 )
 
 type User struct {
 	Subject string `json:"subject"`
 	Email   string `json:"email,omitempty"`
 	Name    string `json:"name,omitempty"`
+	// EmailVerified is the email_verified claim of the provider.
+	EmailVerified bool   `json:"email_verified,omitempty"`
+	Role          string `json:"role,omitempty"`
+	// Groups come from the role claim and are matched by ACL rules.
+	Groups []string `json:"groups,omitempty"`
+	// Token is the id of the API token a request was made with.
+	Token string `json:"token,omitempty"`
 }
 
-type sessionData struct {
-	User      User
-	ExpiresAt time.Time
-}
-
+// oidcClaims are the ID token claims of the login itself, those about the
+// user are read through the configured claim names by idTokenUser.
 type oidcClaims struct {
-	Subject string `json:"sub"`
-	Email   string `json:"email"`
-	Name    string `json:"name"`
-	Nonce   string `json:"nonce"`
+	Nonce string `json:"nonce"`
+	// SessionID is the sid claim, the provider's name for the login.
+	SessionID string `json:"sid"`
 }
 
 type OIDCAuth struct {
@@ -54,9 +64,69 @@ type OIDCAuth struct {
 	verifier      *oidc.IDTokenVerifier
 	oauth2        oauth2.Config
 	cookies       *securecookie.SecureCookie
+	refreshCodec  *securecookie.SecureCookie
 	secure        bool
 	sessionTTL    time.Duration
+	offline       bool
 	allowedEmails map[string]struct{}
+	// verifiedOnly turns away emails the provider has not verified.
+	verifiedOnly bool
+	emailClaim   string
+	nameClaim    string
+	roles        roleMapping
+	acl          *accessControl
+	tokens       *tokenStorage
+	sessions     *sessionStorage
+	// endSession is where the provider ends its own session on logout, nil
+	// when it does not support RP-initiated logout.
+	endSession    *url.URL
+	postLogoutURL string
+	// refreshing lets one request at a time refresh the tokens of a session,
+	// providers that rotate refresh tokens reject the second use of one.
+	// Requests of other sessions do not wait for it.
+	refreshing keyedMutex
+	// logoutTokens are the back-channel logout tokens seen so far.
+	logoutTokens seenLogoutTokens
+}
+
+// keyedMutex is a set of mutexes by key, each one only kept while it is held
+// or waited for.
+type keyedMutex struct {
+	locks map[string]*keyedLock
+	guard s.Mutex
+}
+
+type keyedLock struct {
+	holders int
+	s.Mutex
+}
+
+func (km *keyedMutex) Lock(key string) {
+	km.guard.Lock()
+	if km.locks == nil {
+		km.locks = map[string]*keyedLock{}
+	}
+	lock, ok := km.locks[key]
+	if !ok {
+		lock = &keyedLock{}
+		km.locks[key] = lock
+	}
+	lock.holders++
+	km.guard.Unlock()
+
+	lock.Lock()
+}
+
+func (km *keyedMutex) Unlock(key string) {
+	km.guard.Lock()
+	lock := km.locks[key]
+	lock.holders--
+	if lock.holders == 0 {
+		delete(km.locks, key)
+	}
+	km.guard.Unlock()
+
+	lock.Unlock()
 }
 
 func oidcEnabled(config Config) bool {
@@ -66,7 +136,7 @@ func oidcEnabled(config Config) bool {
 		strings.TrimSpace(config.SessionSecret) != ""
 }
 
-func NewOIDCAuth(ctx context.Context, logger *slog.Logger, config Config) (*OIDCAuth, error) {
+func NewOIDCAuth(ctx context.Context, logger *slog.Logger, config Config, acl *accessControl, tokens *tokenStorage, sessions *sessionStorage) (*OIDCAuth, error) {
 	provider, err := oidc.NewProvider(ctx, strings.TrimSpace(config.OIDCIssuerURL))
 	if err != nil {
 		return nil, fmt.Errorf("oidc provider discovery: %w", err)
@@ -81,12 +151,47 @@ func NewOIDCAuth(ctx context.Context, logger *slog.Logger, config Config) (*OIDC
 	blockKey := sha256.Sum256([]byte("block:" + config.SessionSecret))
 	cookies := securecookie.New(hashKey[:], blockKey[:])
 	cookies.SetSerializer(securecookie.JSONEncoder{})
-	cookies.MaxAge(defaultCookieMaxAge)
+	cookies.MaxAge(int(config.SessionMaxLifetime / time.Second))
+
+	// Refresh tokens are kept encrypted in the sessions file.
+	refreshHashKey := sha256.Sum256([]byte("refresh:" + config.SessionSecret))
+	refreshBlockKey := sha256.Sum256([]byte("refresh-block:" + config.SessionSecret))
+	refreshCodec := securecookie.New(refreshHashKey[:], refreshBlockKey[:])
+	refreshCodec.MaxAge(0)
+
+	endpoint := provider.Endpoint()
+	if config.OIDCClientSecret == "" {
+		// Public clients have no secret to authenticate with, PKCE protects
+		// the code exchange instead.
+		endpoint.AuthStyle = oauth2.AuthStyleInParams
+	}
 
 	scopes := []string{oidc.ScopeOpenID, "profile", "email"}
 	if rawScopes := strings.TrimSpace(config.OIDCScopes); rawScopes != "" {
 		scopes = splitScopes(rawScopes)
 	}
+	if config.OIDCOfflineAccess && !slices.Contains(scopes, oidc.ScopeOfflineAccess) {
+		scopes = append(scopes, oidc.ScopeOfflineAccess)
+	}
+
+	var discovery struct {
+		EndSessionEndpoint string `json:"end_session_endpoint"`
+	}
+	if err := provider.Claims(&discovery); err != nil {
+		return nil, fmt.Errorf("oidc provider discovery: %w", err)
+	}
+
+	var endSession *url.URL
+	if discovery.EndSessionEndpoint != "" {
+		endSession, err = url.Parse(discovery.EndSessionEndpoint)
+		if err != nil {
+			return nil, fmt.Errorf("parse oidc end session endpoint: %w", err)
+		}
+	}
+	postLogoutURL := strings.TrimSpace(config.OIDCPostLogoutURL)
+	if postLogoutURL == "" {
+		postLogoutURL = (&url.URL{Scheme: parsedRedirect.Scheme, Host: parsedRedirect.Host, Path: "/"}).String()
+	}
 
 	auth := &OIDCAuth{
 		logger:   logger,
@@ -96,14 +201,25 @@ func NewOIDCAuth(ctx context.Context, logger *slog.Logger, config Config) (*OIDC
 		oauth2: oauth2.Config{
 			ClientID:     config.OIDCClientID,
 			ClientSecret: config.OIDCClientSecret,
-			Endpoint:     provider.Endpoint(),
+			Endpoint:     endpoint,
 			RedirectURL:  config.OIDCRedirectURL,
 			Scopes:       scopes,
 		},
 		cookies:       cookies,
+		refreshCodec:  refreshCodec,
 		secure:        strings.EqualFold(parsedRedirect.Scheme, "https"),
-		sessionTTL:    defaultSessionTTL,
+		sessionTTL:    config.SessionMaxLifetime,
+		offline:       config.OIDCOfflineAccess,
 		allowedEmails: makeAllowedEmails(config.OIDCAllowedEmails),
+		verifiedOnly:  config.OIDCVerifiedEmailOnly,
+		emailClaim:    strings.TrimSpace(config.OIDCEmailClaim),
+		nameClaim:     strings.TrimSpace(config.OIDCNameClaim),
+		roles:         newRoleMapping(config),
+		acl:           acl,
+		tokens:        tokens,
+		sessions:      sessions,
+		endSession:    endSession,
+		postLogoutURL: postLogoutURL,
 	}
 
 	return auth, nil
@@ -156,6 +272,13 @@ func (a *OIDCAuth) RegisterRoutes(e *echo.Echo) {
 	e.GET("/auth/login", a.handleLogin)
 	e.GET("/auth/callback", a.handleCallback)
 	e.GET("/auth/logout", a.handleLogout)
+	e.POST("/auth/backchannel-logout", a.handleBackchannelLogout)
+	e.GET("/api/tokens", ListTokens(a.tokens))
+	e.POST("/api/tokens", CreateToken(a.logger, a.tokens))
+	e.DELETE("/api/tokens/:id", RevokeToken(a.logger, a.tokens))
+	e.GET("/api/sessions", ListSessions(a.sessions), requireRole(roleAdmin))
+	e.DELETE("/api/sessions", RevokeUserSessions(a.logger, a.sessions), requireRole(roleAdmin))
+	e.DELETE("/api/sessions/:id", RevokeSession(a.logger, a.sessions), requireRole(roleAdmin))
 }
 
 func (a *OIDCAuth) Middleware() echo.MiddlewareFunc {
@@ -166,9 +289,50 @@ func (a *OIDCAuth) Middleware() echo.MiddlewareFunc {
 				return next(c)
 			}
 
+			// Scripts send an API token instead of the session cookie. A bad one
+			// is rejected even when a session would do.
+			if header := c.Request().Header.Get(echo.HeaderAuthorization); header != "" {
+				secret, ok := strings.CutPrefix(header, "Bearer ")
+				if !ok {
+					return c.JSON(http.StatusUnauthorized, Result[string]{Error: "unsupported authorization scheme"})
+				}
+				token, err := a.tokens.Authenticate(strings.TrimSpace(secret))
+				if err != nil {
+					a.logger.Warn("unauthorized request", slog.String("path", path), slog.String("error", err.Error()))
+					return c.JSON(http.StatusUnauthorized, Result[string]{Error: err.Error()})
+				}
+				if !a.isAllowedEmail(token.Owner) {
+					a.logger.Warn("unauthorized request", slog.String("path", path), slog.String("email", token.Owner.Email), slog.String("error", "email no longer allowed"))
+					return c.JSON(http.StatusUnauthorized, Result[string]{Error: "authentication required"})
+				}
+				user := tokenUser(token, a.roles)
+				c.Set(sessionContextKey, user)
+				// Path access follows the owner, the scope only limits the role.
+				owner := token.Owner
+				owner.Role = a.roles.Current(owner)
+				c.Set(accessContextKey, a.acl.For(owner))
+				return next(c)
+			}
+
 			session, err := a.readSession(c)
 			if err == nil {
-				c.Set(sessionContextKey, session.User)
+				session, err = a.refreshSession(c.Request().Context(), session)
+			}
+			if err == nil && !a.isAllowedEmail(session.User) {
+				// Taken off the allowlist after logging in.
+				if _, err := a.sessions.Revoke(session.ID); err != nil && !errors.Is(err, errSessionNotFound) {
+					a.logger.Error("revoke session", slog.String("error", err.Error()))
+				}
+				err = errors.New("email no longer allowed")
+			}
+			if err == nil {
+				// The role is resolved again so that role changes apply to
+				// sessions that outlive them.
+				user := session.User
+				user.Role = a.roles.Current(user)
+				c.Set(sessionContextKey, user)
+				c.Set(sessionIDContextKey, session.ID)
+				c.Set(accessContextKey, a.acl.For(user))
 				return next(c)
 			}
 
@@ -187,6 +351,7 @@ func (a *OIDCAuth) handleLogin(c echo.Context) error {
 	if err != nil {
 		return fmt.Errorf("generate nonce token: %w", err)
 	}
+	verifier := oauth2.GenerateVerifier()
 
 	returnTo := sanitizeReturnTo(c.QueryParam("return_to"))
 	if returnTo == "" {
@@ -195,9 +360,15 @@ func (a *OIDCAuth) handleLogin(c echo.Context) error {
 
 	a.setCookie(c, stateCookieName, state, 10*time.Minute, true)
 	a.setCookie(c, nonceCookieName, nonce, 10*time.Minute, true)
+	a.setCookie(c, verifierCookieName, verifier, 10*time.Minute, true)
 	a.setCookie(c, "syncer_return_to", returnTo, 10*time.Minute, true)
 
-	return c.Redirect(http.StatusFound, a.oauth2.AuthCodeURL(state, oidc.Nonce(nonce)))
+	options := []oauth2.AuthCodeOption{oidc.Nonce(nonce), oauth2.S256ChallengeOption(verifier)}
+	if a.offline {
+		// Some providers only hand out refresh tokens when asked this way.
+		options = append(options, oauth2.AccessTypeOffline)
+	}
+	return c.Redirect(http.StatusFound, a.oauth2.AuthCodeURL(state, options...))
 }
 
 func (a *OIDCAuth) handleCallback(c echo.Context) error {
@@ -210,7 +381,12 @@ func (a *OIDCAuth) handleCallback(c echo.Context) error {
 		return a.renderCallbackError(c, http.StatusBadRequest, "Missing authentication code.")
 	}
 
-	token, err := a.oauth2.Exchange(c.Request().Context(), code)
+	verifier := a.readCookieValue(c, verifierCookieName)
+	if verifier == "" {
+		return a.renderCallbackError(c, http.StatusBadRequest, "Invalid authentication state.")
+	}
+
+	token, err := a.oauth2.Exchange(c.Request().Context(), code, oauth2.VerifierOption(verifier))
 	if err != nil {
 		a.logger.Error("exchange auth code", slog.String("error", err.Error()))
 		return a.renderCallbackError(c, http.StatusUnauthorized, "Authentication exchange failed.")
@@ -236,23 +412,43 @@ func (a *OIDCAuth) handleCallback(c echo.Context) error {
 	if got, want := claims.Nonce, a.readCookieValue(c, nonceCookieName); got == "" || want == "" || got != want {
 		return a.renderCallbackError(c, http.StatusBadRequest, "Invalid authentication nonce.")
 	}
-	if !a.isAllowedEmail(claims.Email) {
-		a.logger.Warn("oidc login rejected", slog.String("email", claims.Email))
+
+	user, err := a.idTokenUser(idToken)
+	if err != nil {
+		a.logger.Error("decode id token claims", slog.String("error", err.Error()))
+		return a.renderCallbackError(c, http.StatusUnauthorized, "Failed to read user claims.")
+	}
+	if !a.isAllowedEmail(user) {
+		a.logger.Warn("oidc login rejected", slog.String("email", user.Email), slog.Bool("email_verified", user.EmailVerified))
 		return a.renderCallbackError(c, http.StatusForbidden, "Your email is not allowed to access this application.")
 	}
 
-	expiresAt := time.Now().Add(a.sessionTTL)
-	if !idToken.Expiry.IsZero() {
-		expiresAt = idToken.Expiry
+	session := Session{
+		User:            user,
+		IP:              c.RealIP(),
+		UserAgent:       c.Request().UserAgent(),
+		ExpiresAt:       time.Now().Add(a.sessionTTL),
+		IDToken:         rawIDToken,
+		ProviderSession: claims.SessionID,
+		TokenExpiry:     idToken.Expiry,
+	}
+	if token.RefreshToken != "" {
+		session.RefreshToken, err = a.refreshCodec.Encode(refreshTokenName, token.RefreshToken)
+		if err != nil {
+			a.logger.Error("encrypt refresh token", slog.String("error", err.Error()))
+			return a.renderCallbackError(c, http.StatusInternalServerError, "Failed to create session.")
+		}
+	} else if !idToken.Expiry.IsZero() && idToken.Expiry.Before(session.ExpiresAt) {
+		// Without a refresh token the session ends with the ID token.
+		session.ExpiresAt = idToken.Expiry
 	}
-
-	session := sessionData{
-		User: User{
-			Subject: claims.Subject,
-			Email:   claims.Email,
-			Name:    claims.Name,
-		},
-		ExpiresAt: expiresAt,
+	session, err = a.sessions.Create(session)
+	if err != nil {
+		a.logger.Error("create session", slog.String("error", err.Error()))
+		return a.renderCallbackError(c, http.StatusInternalServerError, "Failed to create session.")
+	}
+	if err := a.tokens.UpdateOwner(user); err != nil {
+		a.logger.Error("update token owner", slog.String("error", err.Error()))
 	}
 
 	if err := a.writeSession(c, session); err != nil {
@@ -262,6 +458,7 @@ func (a *OIDCAuth) handleCallback(c echo.Context) error {
 
 	a.clearCookie(c, stateCookieName, true)
 	a.clearCookie(c, nonceCookieName, true)
+	a.clearCookie(c, verifierCookieName, true)
 	returnTo := sanitizeReturnTo(a.readCookieValue(c, "syncer_return_to"))
 	a.clearCookie(c, "syncer_return_to", true)
 	if returnTo == "" {
@@ -271,6 +468,109 @@ func (a *OIDCAuth) handleCallback(c echo.Context) error {
 	return c.Redirect(http.StatusFound, returnTo)
 }
 
+// idTokenUser reads the user and their role from the claims of idToken.
+func (a *OIDCAuth) idTokenUser(idToken *oidc.IDToken) (User, error) {
+	var rawClaims map[string]any
+	if err := idToken.Claims(&rawClaims); err != nil {
+		return User{}, err
+	}
+	email, _ := lookupClaim(rawClaims, a.emailClaim).(string)
+	name, _ := lookupClaim(rawClaims, a.nameClaim).(string)
+	return User{
+		Subject:       idToken.Subject,
+		Email:         email,
+		EmailVerified: claimBool(rawClaims["email_verified"]),
+		Name:          name,
+		Role:          a.roles.Resolve(email, rawClaims),
+		Groups:        claimValues(lookupClaim(rawClaims, a.roles.claim)),
+	}, nil
+}
+
+// refreshSession uses the refresh token of session to renew its ID token
+// shortly before that expires. Claims of the new ID token replace the user,
+// so role changes at the provider apply without logging in again. When the
+// provider is unreachable the session lives on until its ID token expires.
+func (a *OIDCAuth) refreshSession(ctx context.Context, session Session) (Session, error) {
+	if session.RefreshToken == "" || time.Now().Before(session.TokenExpiry.Add(-sessionRefreshMargin)) {
+		return session, nil
+	}
+
+	a.refreshing.Lock(session.ID)
+	defer a.refreshing.Unlock(session.ID)
+
+	// Another request may have refreshed it in the meantime.
+	session, err := a.sessions.Get(session.ID)
+	if err != nil || time.Now().Before(session.TokenExpiry.Add(-sessionRefreshMargin)) {
+		return session, err
+	}
+
+	refreshed, err := a.refreshTokens(ctx, session)
+	if err == nil {
+		err = a.sessions.Update(refreshed)
+	}
+	if err == nil {
+		a.logger.Debug("session refreshed", slog.String("user", refreshed.User.Email))
+		if err := a.tokens.UpdateOwner(refreshed.User); err != nil {
+			a.logger.Error("update token owner", slog.String("error", err.Error()))
+		}
+		return refreshed, nil
+	}
+
+	a.logger.Warn("session refresh failed", slog.String("user", session.User.Email), slog.String("error", err.Error()))
+	var retrieveErr *oauth2.RetrieveError
+	if errors.As(err, &retrieveErr) {
+		// The provider turned the refresh token down, the user has to log in.
+		if _, err := a.sessions.Revoke(session.ID); err != nil && !errors.Is(err, errSessionNotFound) {
+			a.logger.Error("revoke session", slog.String("error", err.Error()))
+		}
+		return Session{}, errors.New("session refresh rejected")
+	}
+	if time.Now().Before(session.TokenExpiry) {
+		return session, nil
+	}
+	return Session{}, errors.New("session expired")
+}
+
+func (a *OIDCAuth) refreshTokens(ctx context.Context, session Session) (Session, error) {
+	var refreshToken string
+	if err := a.refreshCodec.Decode(refreshTokenName, session.RefreshToken, &refreshToken); err != nil {
+		return Session{}, fmt.Errorf("decrypt refresh token: %w", err)
+	}
+
+	token, err := a.oauth2.TokenSource(ctx, &oauth2.Token{RefreshToken: refreshToken}).Token()
+	if err != nil {
+		return Session{}, fmt.Errorf("refresh token: %w", err)
+	}
+
+	session.TokenExpiry = token.Expiry
+	if rawIDToken, ok := token.Extra("id_token").(string); ok && rawIDToken != "" {
+		idToken, err := a.verifier.Verify(ctx, rawIDToken)
+		if err != nil {
+			return Session{}, fmt.Errorf("verify id token: %w", err)
+		}
+		user, err := a.idTokenUser(idToken)
+		if err != nil {
+			return Session{}, fmt.Errorf("decode id token claims: %w", err)
+		}
+		if user.Subject != session.User.Subject {
+			return Session{}, fmt.Errorf("refreshed id token is for subject %q", user.Subject)
+		}
+		session.User = user
+		session.IDToken = rawIDToken
+		session.TokenExpiry = idToken.Expiry
+	}
+
+	// Providers that rotate refresh tokens send a new one, the others keep
+	// the old one valid.
+	if token.RefreshToken != "" && token.RefreshToken != refreshToken {
+		session.RefreshToken, err = a.refreshCodec.Encode(refreshTokenName, token.RefreshToken)
+		if err != nil {
+			return Session{}, fmt.Errorf("encrypt refresh token: %w", err)
+		}
+	}
+	return session, nil
+}
+
 func (a *OIDCAuth) renderCallbackError(c echo.Context, status int, message string) error {
 	c.Response().Header().Set(echo.HeaderContentType, echo.MIMETextHTMLCharsetUTF8)
 
@@ -325,12 +625,26 @@ func (a *OIDCAuth) renderCallbackError(c echo.Context, status int, message strin
 	return c.HTML(status, page)
 }
 
+// handleLogout ends the local session and, when the provider supports it,
+// the provider's session too, so the next visit asks for credentials again.
 func (a *OIDCAuth) handleLogout(c echo.Context) error {
+	var session Session
+	if id, err := a.readSessionID(c); err == nil {
+		session, err = a.sessions.Revoke(id)
+		if err != nil && !errors.Is(err, errSessionNotFound) {
+			a.logger.Error("revoke session", slog.String("error", err.Error()))
+		}
+	}
 	a.clearCookie(c, sessionCookieName, true)
 	a.clearCookie(c, stateCookieName, true)
 	a.clearCookie(c, nonceCookieName, true)
+	a.clearCookie(c, verifierCookieName, true)
 	a.clearCookie(c, "syncer_return_to", true)
-	return c.Redirect(http.StatusFound, "/")
+
+	if a.endSession == nil {
+		return c.Redirect(http.StatusFound, "/")
+	}
+	return c.Redirect(http.StatusFound, a.logoutURL(session.IDToken))
 }
 
 func (a *OIDCAuth) CurrentUser(c echo.Context) (User, bool) {
@@ -339,27 +653,54 @@ func (a *OIDCAuth) CurrentUser(c echo.Context) (User, bool) {
 	return user, ok
 }
 
-func (a *OIDCAuth) readSession(c echo.Context) (sessionData, error) {
+// requestUser returns the user behind a request, nil when authentication is
+// disabled.
+func requestUser(c echo.Context) *User {
+	user, ok := c.Get(sessionContextKey).(User)
+	if !ok {
+		return nil
+	}
+	return &user
+}
+
+// requestActor names the authenticated user behind a request for audit
+// purposes. It returns an empty string when authentication is disabled.
+func requestActor(c echo.Context) string {
+	user, ok := c.Get(sessionContextKey).(User)
+	if !ok {
+		return ""
+	}
+	if user.Email != "" {
+		return user.Email
+	}
+	return user.Subject
+}
+
+// readSessionID returns the session id the cookie carries. Cookies from before
+// sessions were kept on the server held the whole session and fail to decode.
+func (a *OIDCAuth) readSessionID(c echo.Context) (string, error) {
 	cookie, err := c.Cookie(sessionCookieName)
 	if err != nil {
-		return sessionData{}, fmt.Errorf("read session cookie: %w", err)
+		return "", fmt.Errorf("read session cookie: %w", err)
 	}
 
-	var session sessionData
-	if err := a.cookies.Decode(sessionCookieName, cookie.Value, &session); err != nil {
-		return sessionData{}, fmt.Errorf("decode session cookie: %w", err)
+	var id string
+	if err := a.cookies.Decode(sessionCookieName, cookie.Value, &id); err != nil {
+		return "", fmt.Errorf("decode session cookie: %w", err)
 	}
-	if session.ExpiresAt.IsZero() || time.Now().After(session.ExpiresAt) {
-		return sessionData{}, errors.New("session expired")
-	}
-	if strings.TrimSpace(session.User.Subject) == "" {
-		return sessionData{}, errors.New("session subject missing")
+	return id, nil
+}
+
+func (a *OIDCAuth) readSession(c echo.Context) (Session, error) {
+	id, err := a.readSessionID(c)
+	if err != nil {
+		return Session{}, err
 	}
-	return session, nil
+	return a.sessions.Touch(id, c.RealIP())
 }
 
-func (a *OIDCAuth) writeSession(c echo.Context, session sessionData) error {
-	encoded, err := a.cookies.Encode(sessionCookieName, session)
+func (a *OIDCAuth) writeSession(c echo.Context, session Session) error {
+	encoded, err := a.cookies.Encode(sessionCookieName, session.ID)
 	if err != nil {
 		return err
 	}
@@ -429,11 +770,16 @@ func randomToken(size int) (string, error) {
 	return base64.RawURLEncoding.EncodeToString(buf), nil
 }
 
-func (a *OIDCAuth) isAllowedEmail(email string) bool {
+// isAllowedEmail checks the email of user against the allowlist, and whether
+// the provider verified it when that is required.
+func (a *OIDCAuth) isAllowedEmail(user User) bool {
+	if a.verifiedOnly && !user.EmailVerified {
+		return false
+	}
 	if len(a.allowedEmails) == 0 {
 		return true
 	}
-	_, ok := a.allowedEmails[strings.ToLower(strings.TrimSpace(email))]
+	_, ok := a.allowedEmails[strings.ToLower(strings.TrimSpace(user.Email))]
 	return ok
 }
 
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/go-units"
+	"github.com/labstack/echo/v4"
+)
+
+// bandwidthWindow throttles transfers during a time of day. Start and End are
+// offsets from midnight; a window with End before Start spans midnight.
+type bandwidthWindow struct {
+	Start time.Duration
+	End   time.Duration
+	Limit uint
+}
+
+type BandwidthRequest struct {
+	Path    string `json:"path"`
+	BwLimit string `json:"bwlimit"`
+}
+
+// parseBandwidthLimit reads a limit the way rsync's --bwlimit does: a bare
+// number is KiB/s, anything else is a size with a unit such as "512k" or
+// "2m". It returns the limit in KiB/s, where 0 means unlimited.
+func parseBandwidthLimit(value string) (uint, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, nil
+	}
+	if v, err := strconv.ParseUint(value, 10, 32); err == nil {
+		return uint(v), nil
+	}
+	v, err := units.RAMInBytes(value)
+	if err != nil || v < 0 {
+		return 0, fmt.Errorf("invalid bandwidth limit %q", value)
+	}
+	if v > 0 && v < 1024 {
+		return 0, fmt.Errorf("bandwidth limit %q is below 1KiB/s", value)
+	}
+	return uint(v / 1024), nil
+}
+
+func parseTimeOfDay(value string) (time.Duration, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(value))
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of day %q", value)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// parseBandwidthWindows reads a list like "08:00-18:00=2m;22:00-06:00=10m".
+func parseBandwidthWindows(value string) ([]bandwidthWindow, error) {
+	var windows []bandwidthWindow
+	for _, item := range strings.Split(value, ";") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		span, limit, ok := strings.Cut(item, "=")
+		if !ok {
+			return nil, fmt.Errorf("bandwidth window %q must look like 08:00-18:00=2m", item)
+		}
+		from, to, ok := strings.Cut(span, "-")
+		if !ok {
+			return nil, fmt.Errorf("bandwidth window %q must look like 08:00-18:00=2m", item)
+		}
+
+		var window bandwidthWindow
+		var err error
+		if window.Start, err = parseTimeOfDay(from); err != nil {
+			return nil, err
+		}
+		if window.End, err = parseTimeOfDay(to); err != nil {
+			return nil, err
+		}
+		if window.Start == window.End {
+			return nil, fmt.Errorf("bandwidth window %q is empty", item)
+		}
+		if window.Limit, err = parseBandwidthLimit(limit); err != nil {
+			return nil, err
+		}
+		if window.Limit == 0 {
+			return nil, fmt.Errorf("bandwidth window %q must set a limit", item)
+		}
+		windows = append(windows, window)
+	}
+	return windows, nil
+}
+
+func (w bandwidthWindow) contains(now time.Time) bool {
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	offset := now.Sub(midnight)
+	if w.Start < w.End {
+		return offset >= w.Start && offset < w.End
+	}
+	return offset >= w.Start || offset < w.End
+}
+
+// bandwidthLimit returns the rate rsync should run at for item in KiB/s. The
+// sync's own limit replaces the global one, and an active time window caps
+// whichever applies. Must be called with the sync storage locked.
+func bandwidthLimit(config Config, item *Sync, now time.Time) uint {
+	limit := item.BwLimit
+	if limit == 0 {
+		// Validated on startup.
+		limit, _ = parseBandwidthLimit(config.RsyncBwLimit)
+	}
+
+	windows, _ := parseBandwidthWindows(config.RsyncBwLimitWindows)
+	for _, window := range windows {
+		if window.contains(now) && (limit == 0 || window.Limit < limit) {
+			limit = window.Limit
+		}
+	}
+	return limit
+}
+
+// applyBandwidth restarts the rsync of a running sync whose limit changed.
+// rsync runs with --partial, so the new attempt resumes where the old one
+// stopped. Must be called with the storage locked.
+func (st *syncStorage) applyBandwidth(logger *slog.Logger, config Config, item *Sync, now time.Time) {
+	if item.State != syncStateRunning || item.Restart == nil {
+		return
+	}
+	limit := bandwidthLimit(config, item, now)
+	if limit == item.AppliedBwLimit {
+		return
+	}
+
+	logger.Info("sync bandwidth changed",
+		slog.String("path", item.Path),
+		slog.Uint64("from", uint64(item.AppliedBwLimit)),
+		slog.Uint64("to", uint64(limit)),
+	)
+	item.Restart()
+}
+
+// runBandwidthScheduler moves running syncs in and out of the configured
+// bandwidth windows.
+func runBandwidthScheduler(logger *slog.Logger, ctx context.Context, config Config, runningSyncs *syncStorage) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			runningSyncs.Lock()
+			for _, item := range runningSyncs.Data {
+				runningSyncs.applyBandwidth(logger, config, item, now)
+			}
+			runningSyncs.Unlock()
+		}
+	}
+}
+
+func SetSyncBandwidth(logger *slog.Logger, config Config, runningSyncs *syncStorage) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		request := &BandwidthRequest{}
+
+		err := c.Bind(request)
+		if err != nil {
+			return fmt.Errorf("load request: %w", err)
+		}
+
+		limit, err := parseBandwidthLimit(request.BwLimit)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, Result[string]{Error: err.Error()})
+		}
+
+		path, err := runningSyncs.Remotes.Canonical(request.Path)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, Result[string]{Error: err.Error()})
+		}
+
+		ok, err := requestAccess(c).authorize(aclActionSync, path)
+		if err != nil {
+			return err
+		}
+
+		runningSyncs.Lock()
+		defer runningSyncs.Unlock()
+
+		item, found := runningSyncs.Data[path]
+		if !ok || !found {
+			return c.JSON(http.StatusBadRequest, Result[string]{Error: errSyncNotFound.Error()})
+		}
+
+		item.BwLimit = limit
+		runningSyncs.applyBandwidth(logger, config, item, time.Now())
+		runningSyncs.publish(Event{Type: eventProgress, Sync: runningSyncs.resultPtr(item, config)})
+
+		return c.JSON(http.StatusOK, Result[SyncResult]{Results: []SyncResult{runningSyncs.result(item, config)}})
+	}
+}
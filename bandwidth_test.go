@@ -0,0 +1,74 @@
+package main
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestParseBandwidthWindows(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    []bandwidthWindow
+		wantErr bool
+	}{
+		{
+			name: "empty",
+		},
+		{
+			name:  "single window",
+			value: "08:00-18:00=2m",
+			want:  []bandwidthWindow{{Start: 8 * time.Hour, End: 18 * time.Hour, Limit: 2048}},
+		},
+		{
+			name:  "several windows and spaces",
+			value: " 08:00-18:30=512 ; 22:00-06:00=10m ;",
+			want: []bandwidthWindow{
+				{Start: 8 * time.Hour, End: 18*time.Hour + 30*time.Minute, Limit: 512},
+				{Start: 22 * time.Hour, End: 6 * time.Hour, Limit: 10240},
+			},
+		},
+		{
+			name:    "missing limit",
+			value:   "08:00-18:00",
+			wantErr: true,
+		},
+		{
+			name:    "missing end",
+			value:   "08:00=2m",
+			wantErr: true,
+		},
+		{
+			name:    "bad time",
+			value:   "8am-18:00=2m",
+			wantErr: true,
+		},
+		{
+			name:    "empty window",
+			value:   "08:00-08:00=2m",
+			wantErr: true,
+		},
+		{
+			name:    "unlimited",
+			value:   "08:00-18:00=0",
+			wantErr: true,
+		},
+		{
+			name:    "bad limit",
+			value:   "08:00-18:00=fast",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseBandwidthWindows(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseBandwidthWindows(%q) error = %v, want error %v", tt.value, err, tt.wantErr)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("parseBandwidthWindows(%q) = %+v, want %+v", tt.value, got, tt.want)
+			}
+		})
+	}
+}
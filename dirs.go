@@ -0,0 +1,472 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	s "sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	eventDirs = "dirs"
+
+	defaultTreeDepth = 1
+	maxTreeDepth     = 8
+)
+
+var errUnknownDir = errors.New("unknown directory")
+
+// remoteTree is the last listing of one remote. Status is worked out again
+// against the live local tree whenever it is read. A remote that could never be
+// listed has a stale tree without dirs.
+type remoteTree struct {
+	dirs        map[string]*Dir
+	generatedAt time.Time
+	stale       bool
+	// partial trees lack what is below directories that could not be read.
+	partial bool
+}
+
+// dirCache keeps the directory trees of all remotes in memory. They are rebuilt
+// in the background on an interval, after a sync of the remote ends and after
+// a remove, so requests never wait for an ssh round trip.
+type dirCache struct {
+	logger   *slog.Logger
+	config   Config
+	remotes  *remoteSet
+	events   *eventBus
+	local    *localTree
+	trees    map[string]*remoteTree
+	requests chan *Remote
+	// refresh serialises rebuilds so the background loop and explicit
+	// refreshes do not list the same remote twice at once.
+	refresh s.Mutex
+	s.Mutex
+}
+
+func newDirCache(logger *slog.Logger, config Config, remotes *remoteSet, events *eventBus, local *localTree) *dirCache {
+	return &dirCache{
+		logger:   logger,
+		config:   config,
+		remotes:  remotes,
+		events:   events,
+		local:    local,
+		trees:    map[string]*remoteTree{},
+		requests: make(chan *Remote, len(remotes.List())),
+	}
+}
+
+// Run rebuilds every tree right away and then whenever one is due or
+// invalidated, until ctx is done.
+func (dc *dirCache) Run(ctx context.Context) {
+	syncEvents := dc.events.Subscribe(false)
+	defer dc.events.Unsubscribe(syncEvents)
+
+	ticker := time.NewTicker(dc.config.DirsRefreshInterval)
+	defer ticker.Stop()
+
+	dc.RefreshAll(ctx)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			dc.RefreshAll(ctx)
+		case remote := <-dc.requests:
+			dc.Refresh(ctx, remote)
+		case event := <-syncEvents:
+			if (event.Type == eventFinish || event.Type == eventFailure) && event.Sync != nil {
+				if remote, _, err := dc.remotes.Resolve(event.Sync.Path); err == nil {
+					dc.Invalidate(remote)
+				}
+			}
+		}
+	}
+}
+
+// Invalidate marks the tree of remote as stale and queues a rebuild.
+func (dc *dirCache) Invalidate(remote *Remote) {
+	dc.Lock()
+	if tree, ok := dc.trees[remote.Name]; ok {
+		tree.stale = true
+	}
+	dc.Unlock()
+
+	select {
+	case dc.requests <- remote:
+	default:
+		// A rebuild of some remote is already pending and the ticker will
+		// catch up with the rest.
+	}
+}
+
+func (dc *dirCache) RefreshAll(ctx context.Context) error {
+	var errs []error
+	for _, remote := range dc.remotes.List() {
+		if err := dc.Refresh(ctx, remote); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Refresh rebuilds the tree of remote. On failure the previous tree is kept and
+// flagged stale.
+func (dc *dirCache) Refresh(ctx context.Context, remote *Remote) error {
+	dc.refresh.Lock()
+	defer dc.refresh.Unlock()
+
+	dirs, partial, err := dc.build(ctx, remote)
+
+	dc.Lock()
+	if err != nil {
+		if tree, ok := dc.trees[remote.Name]; ok {
+			tree.stale = true
+		} else {
+			dc.trees[remote.Name] = &remoteTree{stale: true}
+		}
+	} else {
+		dc.trees[remote.Name] = &remoteTree{
+			dirs:        dirs,
+			generatedAt: time.Now(),
+			partial:     partial,
+		}
+	}
+	dc.Unlock()
+
+	if err != nil {
+		dc.logger.Error("refresh dirs failed", slog.String("remote", remote.Name), slog.String("error", err.Error()))
+		return fmt.Errorf("refresh %s: %w", remote.Name, err)
+	}
+	dc.events.Publish(Event{Type: eventDirs})
+	return nil
+}
+
+func (dc *dirCache) build(ctx context.Context, remote *Remote) (map[string]*Dir, bool, error) {
+	pathMap, partial, err := buildRemoteTree(dc.logger, ctx, remote, dc.local.Snapshot(remote))
+	if err != nil {
+		return nil, false, fmt.Errorf("list remote: %w", err)
+	}
+	return pathMap, partial, nil
+}
+
+// tree returns the cached tree of remote, building it first if nobody tried
+// yet. built tells whether it was just built.
+func (dc *dirCache) tree(ctx context.Context, remote *Remote) (tree *remoteTree, built bool, err error) {
+	dc.Lock()
+	tree, ok := dc.trees[remote.Name]
+	dc.Unlock()
+	if ok {
+		return tree, false, nil
+	}
+
+	if err := dc.Refresh(ctx, remote); err != nil {
+		return nil, false, err
+	}
+
+	dc.Lock()
+	defer dc.Unlock()
+	return dc.trees[remote.Name], true, nil
+}
+
+// HasRemotePath reports whether remotePath is a directory on remote. A path
+// missing from the cache may have appeared since, so the remote is listed
+// again before giving up.
+func (dc *dirCache) HasRemotePath(ctx context.Context, remote *Remote, remotePath string) (bool, error) {
+	tree, built, err := dc.tree(ctx, remote)
+	if err != nil {
+		return false, err
+	}
+	if _, ok := tree.dirs[remotePath]; ok || built {
+		return ok, nil
+	}
+
+	if err := dc.Refresh(ctx, remote); err != nil {
+		return false, err
+	}
+	dc.Lock()
+	defer dc.Unlock()
+	_, ok := dc.trees[remote.Name].dirs[remotePath]
+	return ok, nil
+}
+
+// Estimate guesses how many bytes a sync of remotePath still has to download,
+// from the listed size of the remote dir and of its local copy.
+func (dc *dirCache) Estimate(ctx context.Context, remote *Remote, remotePath string) (uint64, error) {
+	var estimate uint64
+	err := dc.view(ctx, remote, func(tree *remoteTree, _ map[string]*Dir) {
+		if item, ok := tree.dirs[remotePath]; ok && item.RemoteBytes > item.LocalBytes {
+			estimate = item.RemoteBytes - item.LocalBytes
+		}
+	})
+	return estimate, err
+}
+
+// view hands fn the tree of remote with Status worked out against the live
+// local tree. The cache stays locked while fn runs.
+func (dc *dirCache) view(ctx context.Context, remote *Remote, fn func(tree *remoteTree, localPathMap map[string]*Dir)) error {
+	tree, _, err := dc.tree(ctx, remote)
+	if err != nil {
+		return err
+	}
+
+	localPathMap := dc.local.Snapshot(remote)
+	dc.Lock()
+	defer dc.Unlock()
+	if tree.dirs == nil {
+		return fmt.Errorf("remote %s could not be listed", remote.Name)
+	}
+	markStatus(tree.dirs, localPathMap)
+	fn(tree, localPathMap)
+	return nil
+}
+
+// treeAge collects how old the trees behind a result are. It must be fed with
+// the cache locked.
+type treeAge struct {
+	oldest  time.Time
+	stale   bool
+	partial bool
+}
+
+func (a *treeAge) add(tree *remoteTree, refreshInterval time.Duration) {
+	if a.oldest.IsZero() || tree.generatedAt.Before(a.oldest) {
+		a.oldest = tree.generatedAt
+	}
+	if tree.stale || time.Since(tree.generatedAt) > 2*refreshInterval {
+		a.stale = true
+	}
+	a.partial = a.partial || tree.partial
+}
+
+// Result lists the cached directories of every remote that access allows
+// viewing. It is stale when any tree is, or when a tree could not be built at
+// all.
+func (dc *dirCache) Result(ctx context.Context, access *pathAccess) (Result[DirResult], error) {
+	result := Result[DirResult]{
+		Error:   "",
+		Results: make([]DirResult, 0),
+	}
+
+	var errs []error
+	var age treeAge
+	for _, remote := range dc.remotes.List() {
+		err := dc.view(ctx, remote, func(tree *remoteTree, _ map[string]*Dir) {
+			keys := make([]string, 0, len(tree.dirs))
+			for k := range tree.dirs {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+
+			for _, k := range keys {
+				item := tree.dirs[k]
+				address := remote.Address(item.Path)
+				if !access.Allows(aclActionView, address) {
+					continue
+				}
+				result.Results = append(result.Results, DirResult{
+					Remote:      remote.Name,
+					Path:        address,
+					Synced:      item.Status == dirStatusSynced,
+					Status:      item.Status,
+					LocalBytes:  item.LocalBytes,
+					RemoteBytes: item.RemoteBytes,
+					Actions:     access.Actions(address),
+				})
+			}
+			age.add(tree, dc.config.DirsRefreshInterval)
+		})
+		if err != nil {
+			errs = append(errs, err)
+			result.Stale = true
+		}
+	}
+	if len(errs) == len(dc.remotes.List()) {
+		return Result[DirResult]{}, errors.Join(errs...)
+	}
+
+	result.GeneratedAt = &age.oldest
+	result.Stale = result.Stale || age.stale
+	result.Partial = age.partial
+	return result, nil
+}
+
+// Tree returns the children of address as nested nodes, depth levels deep. An
+// empty address gives the root of every remote instead, whose status sums up
+// all of the remote. Directories access hides are left out and do not count
+// towards the status and sizes of their parents.
+func (dc *dirCache) Tree(ctx context.Context, address string, depth int, access *pathAccess) (Result[TreeNode], error) {
+	result := Result[TreeNode]{
+		Error:   "",
+		Results: make([]TreeNode, 0),
+	}
+
+	var age treeAge
+	if address == "" {
+		var errs []error
+		for _, remote := range dc.remotes.List() {
+			if !access.Visible(remote.Address("/")) {
+				continue
+			}
+			err := dc.view(ctx, remote, func(tree *remoteTree, localPathMap map[string]*Dir) {
+				root := remoteRoot(remote, tree)
+				if access != nil {
+					root = access.prune(remote, root, nil)
+					localPathMap = access.pruneMap(remote, localPathMap)
+				}
+				markDir(root, localPathMap)
+				result.Results = append(result.Results, newTreeNode(remote, root, depth, access))
+				age.add(tree, dc.config.DirsRefreshInterval)
+			})
+			if err != nil {
+				errs = append(errs, err)
+				result.Stale = true
+			}
+		}
+		if len(errs) == len(dc.remotes.List()) {
+			return Result[TreeNode]{}, errors.Join(errs...)
+		}
+	} else {
+		remote, remotePath, err := dc.remotes.Resolve(address)
+		if err != nil {
+			return Result[TreeNode]{}, err
+		}
+		if !access.Visible(remote.Address(remotePath)) {
+			return Result[TreeNode]{}, fmt.Errorf("%w: %s", errUnknownDir, address)
+		}
+
+		found := false
+		err = dc.view(ctx, remote, func(tree *remoteTree, localPathMap map[string]*Dir) {
+			parent, ok := tree.dirs[remotePath]
+			if remotePath == "/" {
+				parent, ok = remoteRoot(remote, tree), true
+			}
+			if !ok {
+				return
+			}
+			found = true
+			if access != nil {
+				parent = access.prune(remote, parent, nil)
+				markDir(parent, access.pruneMap(remote, localPathMap))
+			}
+			for _, child := range sortedChildren(parent) {
+				result.Results = append(result.Results, newTreeNode(remote, child, depth, access))
+			}
+			age.add(tree, dc.config.DirsRefreshInterval)
+		})
+		if err != nil {
+			return Result[TreeNode]{}, err
+		}
+		if !found {
+			return Result[TreeNode]{}, fmt.Errorf("%w: %s", errUnknownDir, address)
+		}
+	}
+
+	result.GeneratedAt = &age.oldest
+	result.Stale = result.Stale || age.stale
+	result.Partial = age.partial
+	return result, nil
+}
+
+// remoteRoot gathers the top level directories of tree under a root that is
+// not part of the listing itself.
+func remoteRoot(remote *Remote, tree *remoteTree) *Dir {
+	root := &Dir{
+		Path:     "/",
+		Name:     remote.Name,
+		Children: map[string]*Dir{},
+	}
+	for _, item := range tree.dirs {
+		if item.Parent == nil {
+			root.Children[item.Name] = item
+		}
+	}
+	return root
+}
+
+func newTreeNode(remote *Remote, item *Dir, depth int, access *pathAccess) TreeNode {
+	node := TreeNode{
+		Remote:      remote.Name,
+		Path:        remote.Address(item.Path),
+		Name:        item.Name,
+		Status:      item.Status,
+		LocalBytes:  item.LocalBytes,
+		RemoteBytes: item.RemoteBytes,
+		HasChildren: len(item.Children) > 0,
+	}
+	node.Actions = access.Actions(node.Path)
+	if depth > 1 && node.HasChildren {
+		node.Children = make([]TreeNode, 0, len(item.Children))
+		for _, child := range sortedChildren(item) {
+			node.Children = append(node.Children, newTreeNode(remote, child, depth-1, access))
+		}
+	}
+	return node
+}
+
+func sortedChildren(item *Dir) []*Dir {
+	children := make([]*Dir, 0, len(item.Children))
+	for _, child := range item.Children {
+		children = append(children, child)
+	}
+	sort.Slice(children, func(i, j int) bool {
+		return children[i].Name < children[j].Name
+	})
+	return children
+}
+
+func ListDirs(ctx context.Context, dirs *dirCache) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		result, err := dirs.Result(ctx, requestAccess(c))
+		if err != nil {
+			return fmt.Errorf("list dirs: %w", err)
+		}
+		return c.JSON(http.StatusOK, result)
+	}
+}
+
+// RefreshDirs rebuilds every tree before answering like ListDirs. Remotes that
+// fail keep their previous tree and show up as stale.
+func RefreshDirs(ctx context.Context, dirs *dirCache) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		_ = dirs.RefreshAll(ctx)
+
+		result, err := dirs.Result(ctx, requestAccess(c))
+		if err != nil {
+			return fmt.Errorf("list dirs: %w", err)
+		}
+		return c.JSON(http.StatusOK, result)
+	}
+}
+
+// ListTree answers GET /api/tree?path=&depth= with the children of path, or the
+// root of every remote when path is empty. depth says how many levels of
+// children come nested in each node, deeper ones are loaded when expanded.
+func ListTree(ctx context.Context, dirs *dirCache) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		depth := defaultTreeDepth
+		if raw := c.QueryParam("depth"); raw != "" {
+			v, err := strconv.Atoi(raw)
+			if err != nil || v <= 0 {
+				return c.JSON(http.StatusBadRequest, Result[string]{Error: fmt.Sprintf("invalid depth %q", raw)})
+			}
+			depth = min(v, maxTreeDepth)
+		}
+
+		result, err := dirs.Tree(ctx, strings.TrimSpace(c.QueryParam("path")), depth, requestAccess(c))
+		if errors.Is(err, errUnknownRemote) || errors.Is(err, errUnknownDir) {
+			return c.JSON(http.StatusBadRequest, Result[string]{Error: err.Error()})
+		} else if err != nil {
+			return fmt.Errorf("list tree: %w", err)
+		}
+		return c.JSON(http.StatusOK, result)
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"io/fs"
+	"time"
+)
+
+const (
+	dirStatusMissing  = "missing"
+	dirStatusPartial  = "partial"
+	dirStatusOutdated = "outdated"
+	dirStatusSynced   = "synced"
+)
+
+// dirStatusRank orders the statuses from best to worst, a directory is only as
+// synced as the worst of its subdirectories.
+var dirStatusRank = map[string]int{
+	dirStatusSynced:   0,
+	dirStatusOutdated: 1,
+	dirStatusPartial:  2,
+	dirStatusMissing:  3,
+}
+
+// DirStats sums up the regular files directly in a directory. Modified is the
+// newest modification time, to the second as SFTP reports it.
+type DirStats struct {
+	Files    int
+	Bytes    uint64
+	Modified time.Time
+}
+
+func (st *DirStats) add(info fs.FileInfo) {
+	st.Files++
+	st.Bytes += uint64(info.Size())
+	if modified := info.ModTime().Truncate(time.Second); modified.After(st.Modified) {
+		st.Modified = modified
+	}
+}
+
+// compareDirStats tells how the local files of a directory measure up to the
+// remote ones. Fewer files means a sync did not get through, the same files
+// with a newer or bigger remote side means they changed since. Extra local
+// files left over from a sync without mirror do not count against it.
+func compareDirStats(local, remote *DirStats) string {
+	if remote == nil {
+		// The listing did not look inside, so existing is all we know.
+		return dirStatusSynced
+	}
+	if local == nil || local.Files < remote.Files {
+		return dirStatusPartial
+	}
+	if remote.Modified.After(local.Modified) || local.Bytes < remote.Bytes {
+		return dirStatusOutdated
+	}
+	return dirStatusSynced
+}
+
+// markStatus works out Status and the byte counts of every remote dir in
+// pathMap against its local copy in localPathMap.
+func markStatus(pathMap map[string]*Dir, localPathMap map[string]*Dir) {
+	for _, item := range pathMap {
+		if item.Parent == nil {
+			markDir(item, localPathMap)
+		}
+	}
+}
+
+// markDir works out Status and the byte counts of item and everything below
+// it. A missing subdirectory makes its parents partial.
+func markDir(item *Dir, localPathMap map[string]*Dir) string {
+	item.Status = dirStatusMissing
+	item.LocalBytes = 0
+	item.RemoteBytes = 0
+	if item.Stats != nil {
+		item.RemoteBytes = item.Stats.Bytes
+	}
+
+	local, ok := localPathMap[item.Path]
+	if ok {
+		item.Status = compareDirStats(local.Stats, item.Stats)
+		item.LocalBytes = localBytes(local)
+	}
+
+	for _, child := range item.Children {
+		status := markDir(child, localPathMap)
+		item.RemoteBytes += child.RemoteBytes
+		if ok && status == dirStatusMissing {
+			status = dirStatusPartial
+		}
+		if dirStatusRank[status] > dirStatusRank[item.Status] {
+			item.Status = status
+		}
+	}
+	return item.Status
+}
+
+// localBytes is the size of the files in the local dir item and below it.
+func localBytes(item *Dir) uint64 {
+	var bytes uint64
+	if item.Stats != nil {
+		bytes = item.Stats.Bytes
+	}
+	for _, child := range item.Children {
+		bytes += localBytes(child)
+	}
+	return bytes
+}
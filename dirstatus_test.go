@@ -0,0 +1,71 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCompareDirStats(t *testing.T) {
+	older := time.Unix(1700000000, 0)
+	newer := older.Add(time.Hour)
+	tests := []struct {
+		name   string
+		local  *DirStats
+		remote *DirStats
+		want   string
+	}{
+		{
+			name:  "remote not looked into",
+			local: nil,
+			want:  dirStatusSynced,
+		},
+		{
+			name:   "no local stats",
+			remote: &DirStats{Files: 1, Bytes: 10, Modified: older},
+			want:   dirStatusPartial,
+		},
+		{
+			name:   "fewer local files",
+			local:  &DirStats{Files: 1, Bytes: 10, Modified: older},
+			remote: &DirStats{Files: 2, Bytes: 10, Modified: older},
+			want:   dirStatusPartial,
+		},
+		{
+			name:   "newer remote files",
+			local:  &DirStats{Files: 2, Bytes: 10, Modified: older},
+			remote: &DirStats{Files: 2, Bytes: 10, Modified: newer},
+			want:   dirStatusOutdated,
+		},
+		{
+			name:   "bigger remote files",
+			local:  &DirStats{Files: 2, Bytes: 10, Modified: older},
+			remote: &DirStats{Files: 2, Bytes: 11, Modified: older},
+			want:   dirStatusOutdated,
+		},
+		{
+			name:   "same",
+			local:  &DirStats{Files: 2, Bytes: 10, Modified: older},
+			remote: &DirStats{Files: 2, Bytes: 10, Modified: older},
+			want:   dirStatusSynced,
+		},
+		{
+			name:   "extra local files",
+			local:  &DirStats{Files: 3, Bytes: 20, Modified: newer},
+			remote: &DirStats{Files: 2, Bytes: 10, Modified: older},
+			want:   dirStatusSynced,
+		},
+		{
+			name:   "empty on both sides",
+			local:  &DirStats{},
+			remote: &DirStats{},
+			want:   dirStatusSynced,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := compareDirStats(tt.local, tt.remote); got != tt.want {
+				t.Errorf("compareDirStats() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
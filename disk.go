@@ -0,0 +1,275 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	s "sync"
+	"time"
+
+	"github.com/docker/go-units"
+	"github.com/labstack/echo/v4"
+)
+
+const eventDisk = "disk"
+
+var errInsufficientSpace = errors.New("not enough free space")
+
+type DiskUsage struct {
+	Total uint64
+	Used  uint64
+	Free  uint64
+}
+
+type StatusResult struct {
+	Total uint64 `json:"total"`
+	Used  uint64 `json:"used"`
+	Free  uint64 `json:"free"`
+	// Available is what new syncs may still take: the free space minus the
+	// reserve and what queued and running syncs are expected to download.
+	Available uint64    `json:"available"`
+	Reserve   uint64    `json:"reserve"`
+	Low       bool      `json:"low"`
+	CheckedAt time.Time `json:"checked_at"`
+}
+
+// diskMonitor watches the free space of the volumes holding the local path of
+// each remote. Space is low once the fullest of them drops below the reserve,
+// and only counts as back when twice the reserve is free there, so paused
+// syncs do not flip between running and paused.
+type diskMonitor struct {
+	logger  *slog.Logger
+	config  Config
+	reserve uint64
+	// roots maps each remote name to its local path.
+	roots map[string]string
+	// usage is that of the fullest volume, usages that of each remote.
+	usage     DiskUsage
+	usages    map[string]DiskUsage
+	checkedAt time.Time
+	low       bool
+	// resumed is closed when low space is over.
+	resumed chan struct{}
+	s.Mutex
+}
+
+func newDiskMonitor(logger *slog.Logger, config Config, remotes *remoteSet) *diskMonitor {
+	// Validated on startup.
+	reserve, _ := parseSize(config.DiskReserve)
+	roots := map[string]string{}
+	for _, remote := range remotes.List() {
+		roots[remote.Name] = remote.LocalDir(config, "/")
+	}
+	return &diskMonitor{
+		logger:  logger,
+		config:  config,
+		reserve: reserve,
+		roots:   roots,
+		usages:  map[string]DiskUsage{},
+	}
+}
+
+// Check reads the free space of every local path again and reports whether
+// space just ran low or came back. The usage returned is the fullest volume's.
+func (dm *diskMonitor) Check() (DiskUsage, bool, error) {
+	usages := make(map[string]DiskUsage, len(dm.roots))
+	var usage DiskUsage
+	for name, root := range dm.roots {
+		rootUsage, err := diskUsage(existingDir(root))
+		if err != nil {
+			return DiskUsage{}, false, fmt.Errorf("remote %s: %w", name, err)
+		}
+		usages[name] = rootUsage
+		if len(usages) == 1 || rootUsage.Free < usage.Free {
+			usage = rootUsage
+		}
+	}
+
+	dm.Lock()
+	defer dm.Unlock()
+	dm.usage = usage
+	dm.usages = usages
+	dm.checkedAt = time.Now()
+
+	switch {
+	case !dm.low && usage.Free < dm.reserve:
+		dm.low = true
+		dm.resumed = make(chan struct{})
+		dm.logger.Warn("disk space low, pausing syncs",
+			slog.String("free", units.BytesSize(float64(usage.Free))),
+			slog.String("reserve", units.BytesSize(float64(dm.reserve))),
+		)
+		return usage, true, nil
+	case dm.low && usage.Free >= 2*dm.reserve:
+		dm.low = false
+		close(dm.resumed)
+		dm.logger.Info("disk space recovered, resuming syncs", slog.String("free", units.BytesSize(float64(usage.Free))))
+		return usage, true, nil
+	}
+	return usage, false, nil
+}
+
+// existingDir is dir or the closest parent of it that exists. Local paths are
+// only created by the first sync into them.
+func existingDir(dir string) string {
+	for {
+		if _, err := os.Stat(dir); err == nil {
+			return dir
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return dir
+		}
+		dir = parent
+	}
+}
+
+// paused returns a channel that is closed once low space is over, or nil when
+// space is not low.
+func (dm *diskMonitor) paused() <-chan struct{} {
+	dm.Lock()
+	defer dm.Unlock()
+	if !dm.low {
+		return nil
+	}
+	return dm.resumed
+}
+
+// Run checks the free space every disk check interval until ctx is done. While
+// space is low, running syncs are stopped at the end of their current attempt
+// and wait in startSync for it to come back.
+func (dm *diskMonitor) Run(ctx context.Context, runningSyncs *syncStorage) {
+	ticker := time.NewTicker(dm.config.DiskCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		_, changed, err := dm.Check()
+		if err != nil {
+			dm.logger.Error("disk check failed", slog.String("error", err.Error()))
+		}
+
+		runningSyncs.Lock()
+		if dm.paused() != nil {
+			for _, item := range runningSyncs.Data {
+				if item.State == syncStateRunning && !item.Paused && item.Restart != nil {
+					item.Restart()
+				}
+			}
+		}
+		if changed {
+			runningSyncs.publish(Event{Type: eventDisk})
+		}
+		runningSyncs.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// waitForSpace holds currentSync back while disk space is low.
+func (st *syncStorage) waitForSpace(logger *slog.Logger, ctx context.Context, config Config, currentSync *Sync) error {
+	resumed := st.Disk.paused()
+	if resumed == nil {
+		return nil
+	}
+
+	logger.Warn("sync paused for disk space", slog.String("path", currentSync.Path))
+	st.Lock()
+	currentSync.Paused = true
+	st.publish(Event{Type: eventProgress, Sync: st.resultPtr(currentSync, config)})
+	st.Unlock()
+
+	defer func() {
+		st.Lock()
+		currentSync.Paused = false
+		st.publish(Event{Type: eventProgress, Sync: st.resultPtr(currentSync, config)})
+		st.Unlock()
+	}()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-resumed:
+		logger.Info("sync resumed", slog.String("path", currentSync.Path))
+		return nil
+	}
+}
+
+// committed is what queued and running syncs are still expected to download.
+// Must be called with the storage locked.
+func (st *syncStorage) committed() uint64 {
+	var total uint64
+	for _, item := range st.Data {
+		if downloaded := uint64(item.Downloaded); item.Estimate > downloaded {
+			total += item.Estimate - downloaded
+		}
+	}
+	return total
+}
+
+// status reads the free space again and works out what is left for new
+// syncs of remote, or on the fullest volume when remote is nil.
+func (st *syncStorage) status(remote *Remote) (StatusResult, error) {
+	usage, changed, err := st.Disk.Check()
+	if err != nil {
+		return StatusResult{}, err
+	}
+
+	st.Lock()
+	if changed {
+		st.publish(Event{Type: eventDisk})
+	}
+	committed := st.committed()
+	st.Unlock()
+
+	st.Disk.Lock()
+	defer st.Disk.Unlock()
+	if remote != nil {
+		usage = st.Disk.usages[remote.Name]
+	}
+	result := StatusResult{
+		Total:     usage.Total,
+		Used:      usage.Used,
+		Free:      usage.Free,
+		Reserve:   st.Disk.reserve,
+		Low:       st.Disk.low,
+		CheckedAt: st.Disk.checkedAt,
+	}
+	if usage.Free > st.Disk.reserve+committed {
+		result.Available = usage.Free - st.Disk.reserve - committed
+	}
+	return result, nil
+}
+
+// checkSpace refuses a sync of remote expected to download estimate bytes when
+// that does not fit in the space left for new syncs.
+func (st *syncStorage) checkSpace(remote *Remote, estimate uint64) error {
+	status, err := st.status(remote)
+	if err != nil {
+		return err
+	}
+	if status.Low {
+		return fmt.Errorf("%w: only %s free, %s are kept in reserve", errInsufficientSpace, units.BytesSize(float64(status.Free)), units.BytesSize(float64(status.Reserve)))
+	}
+	if estimate > status.Available {
+		return fmt.Errorf("%w: about %s needed, %s available", errInsufficientSpace, units.BytesSize(float64(estimate)), units.BytesSize(float64(status.Available)))
+	}
+	return nil
+}
+
+func StatusHandler(runningSyncs *syncStorage) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		status, err := runningSyncs.status(nil)
+		if err != nil {
+			return fmt.Errorf("disk status: %w", err)
+		}
+		return c.JSON(http.StatusOK, Result[StatusResult]{Results: []StatusResult{status}})
+	}
+}
@@ -0,0 +1,11 @@
+//go:build !linux && !darwin && !freebsd && !windows
+
+package main
+
+import "errors"
+
+// diskUsage is not implemented here, so every space check fails rather than
+// letting syncs fill the disk unchecked.
+func diskUsage(path string) (DiskUsage, error) {
+	return DiskUsage{}, errors.New("disk usage is not supported on this platform")
+}
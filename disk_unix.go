@@ -0,0 +1,22 @@
+//go:build linux || darwin || freebsd
+
+package main
+
+import (
+	"fmt"
+
+	"golang.org/x/sys/unix"
+)
+
+func diskUsage(path string) (DiskUsage, error) {
+	var stat unix.Statfs_t
+	if err := unix.Statfs(path, &stat); err != nil {
+		return DiskUsage{}, fmt.Errorf("statfs: %w", err)
+	}
+	blockSize := uint64(stat.Bsize)
+	return DiskUsage{
+		Total: uint64(stat.Blocks) * blockSize,
+		Used:  (uint64(stat.Blocks) - uint64(stat.Bfree)) * blockSize,
+		Free:  uint64(stat.Bavail) * blockSize,
+	}, nil
+}
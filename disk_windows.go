@@ -0,0 +1,23 @@
+package main
+
+import (
+	"fmt"
+
+	"golang.org/x/sys/windows"
+)
+
+func diskUsage(path string) (DiskUsage, error) {
+	name, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return DiskUsage{}, fmt.Errorf("disk free space: %w", err)
+	}
+	var free, total, totalFree uint64
+	if err := windows.GetDiskFreeSpaceEx(name, &free, &total, &totalFree); err != nil {
+		return DiskUsage{}, fmt.Errorf("disk free space: %w", err)
+	}
+	return DiskUsage{
+		Total: total,
+		Used:  total - totalFree,
+		Free:  free,
+	}, nil
+}
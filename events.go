@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	s "sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	eventSnapshot = "snapshot"
+	eventQueued   = "queued"
+	eventStart    = "start"
+	eventProgress = "progress"
+	eventFinish   = "finish"
+	eventFailure  = "failure"
+	eventRemove   = "remove"
+)
+
+const (
+	progressEventInterval = 500 * time.Millisecond
+	eventKeepAlive        = 15 * time.Second
+	eventBuffer           = 64
+)
+
+type Event struct {
+	Type   string        `json:"type"`
+	Sync   *SyncResult   `json:"sync,omitempty"`
+	Syncs  []SyncResult  `json:"syncs,omitempty"`
+	Result *HistoryEntry `json:"result,omitempty"`
+}
+
+// eventBus fans sync events out to every subscriber. Publishing never blocks:
+// a stream subscriber that falls behind is closed rather than stalling the
+// syncs, so its client reconnects and starts over from a snapshot instead of
+// silently missing events. Other subscribers only miss the events that do not
+// fit their buffer.
+type eventBus struct {
+	// subscribers maps every channel to whether it is closed when it falls
+	// behind.
+	subscribers map[chan Event]bool
+	s.Mutex
+}
+
+func newEventBus() *eventBus {
+	return &eventBus{
+		subscribers: map[chan Event]bool{},
+	}
+}
+
+// Subscribe returns a channel receiving every event from now on. With
+// closeOnLag the channel is closed once it falls behind, otherwise events
+// that do not fit are dropped.
+func (b *eventBus) Subscribe(closeOnLag bool) chan Event {
+	ch := make(chan Event, eventBuffer)
+
+	b.Lock()
+	defer b.Unlock()
+	b.subscribers[ch] = closeOnLag
+	return ch
+}
+
+// Unsubscribe removes ch, unless Publish already closed and removed it.
+func (b *eventBus) Unsubscribe(ch chan Event) {
+	b.Lock()
+	defer b.Unlock()
+	delete(b.subscribers, ch)
+}
+
+func (b *eventBus) Publish(event Event) {
+	b.Lock()
+	defer b.Unlock()
+
+	for ch, closeOnLag := range b.subscribers {
+		select {
+		case ch <- event:
+		default:
+			if closeOnLag {
+				close(ch)
+				delete(b.subscribers, ch)
+			}
+		}
+	}
+}
+
+// publish sends a sync event. Must be called with the storage locked, so
+// events reach subscribers in the order the state changed.
+func (st *syncStorage) publish(event Event) {
+	if st.Events != nil {
+		st.Events.Publish(event)
+	}
+}
+
+func (st *syncStorage) snapshot(config Config) []SyncResult {
+	results := make([]SyncResult, 0, len(st.Data))
+	for _, value := range st.Data {
+		results = append(results, st.result(value, config))
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		a, b := results[i], results[j]
+		if a.State != b.State {
+			return a.State == syncStateRunning
+		}
+		if a.Position != b.Position {
+			return a.Position < b.Position
+		}
+		return a.Path > b.Path
+	})
+	return results
+}
+
+func writeEvent(c echo.Context, event Event) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("encode event: %w", err)
+	}
+	if _, err := fmt.Fprintf(c.Response(), "data: %s\n\n", data); err != nil {
+		return err
+	}
+	c.Response().Flush()
+	return nil
+}
+
+func StreamEvents(ctx context.Context, config Config, runningSyncs *syncStorage) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		access := requestAccess(c)
+		events := runningSyncs.Events.Subscribe(true)
+		defer runningSyncs.Events.Unsubscribe(events)
+
+		runningSyncs.Lock()
+		snapshot := access.syncs(runningSyncs.snapshot(config))
+		runningSyncs.Unlock()
+
+		c.Response().Header().Set(echo.HeaderContentType, "text/event-stream")
+		c.Response().Header().Set(echo.HeaderCacheControl, "no-cache")
+		c.Response().Header().Set(echo.HeaderConnection, "keep-alive")
+		c.Response().WriteHeader(http.StatusOK)
+
+		if err := writeEvent(c, Event{Type: eventSnapshot, Syncs: snapshot}); err != nil {
+			return nil
+		}
+
+		keepAlive := time.NewTicker(eventKeepAlive)
+		defer keepAlive.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-c.Request().Context().Done():
+				return nil
+			case <-keepAlive.C:
+				if _, err := fmt.Fprint(c.Response(), ": keep-alive\n\n"); err != nil {
+					return nil
+				}
+				c.Response().Flush()
+			case event, ok := <-events:
+				if !ok {
+					// Fell behind, the client reconnects for a new snapshot.
+					return nil
+				}
+				event, ok = access.event(event)
+				if !ok {
+					continue
+				}
+				if err := writeEvent(c, event); err != nil {
+					return nil
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	s "sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+const maxFilterPatterns = 100
+
+var errFilterNotFound = errors.New("filter profile not found")
+
+// FilterProfile is a named set of include and exclude patterns that can be
+// picked when starting a sync instead of spelling the patterns out every time.
+type FilterProfile struct {
+	Name      string    `json:"name"`
+	Include   []string  `json:"include,omitempty"`
+	Exclude   []string  `json:"exclude,omitempty"`
+	UpdatedBy string    `json:"updated_by,omitempty"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+type FilterProfileRequest struct {
+	Include []string `json:"include"`
+	Exclude []string `json:"exclude"`
+}
+
+type filterStorage struct {
+	path string
+	data []*FilterProfile
+	s.Mutex
+}
+
+func newFilterStorage(path string) (*filterStorage, error) {
+	filters := &filterStorage{
+		path: path,
+		data: []*FilterProfile{},
+	}
+	if err := readJSONFile(path, &filters.data); err != nil {
+		return nil, fmt.Errorf("load filters: %w", err)
+	}
+	for _, profile := range filters.data {
+		if err := validateFilterPatterns(profile.Include, profile.Exclude); err != nil {
+			return nil, fmt.Errorf("filter profile %s: %w", profile.Name, err)
+		}
+	}
+	return filters, nil
+}
+
+func (st *filterStorage) find(name string) (*FilterProfile, int) {
+	for i, profile := range st.data {
+		if profile.Name == name {
+			return profile, i
+		}
+	}
+	return nil, -1
+}
+
+// Rules returns the rsync filter rules of the named profile followed by the
+// extra patterns, includes before excludes, since rsync stops at the first
+// matching rule.
+func (st *filterStorage) Rules(name string, include []string, exclude []string) ([]string, error) {
+	if name != "" {
+		st.Lock()
+		profile, _ := st.find(name)
+		if profile != nil {
+			include = append(slices.Clone(profile.Include), include...)
+			exclude = append(slices.Clone(profile.Exclude), exclude...)
+		}
+		st.Unlock()
+
+		if profile == nil {
+			return nil, errFilterNotFound
+		}
+	}
+
+	if err := validateFilterPatterns(include, exclude); err != nil {
+		return nil, err
+	}
+	return filterRules(include, exclude), nil
+}
+
+func filterRules(include []string, exclude []string) []string {
+	rules := make([]string, 0, len(include)+len(exclude))
+	for _, pattern := range include {
+		rules = append(rules, "+ "+pattern)
+	}
+	for _, pattern := range exclude {
+		rules = append(rules, "- "+pattern)
+	}
+	return rules
+}
+
+func filterArgs(rules []string) []string {
+	args := make([]string, 0, len(rules))
+	for _, rule := range rules {
+		args = append(args, "--filter="+rule)
+	}
+	return args
+}
+
+// validateFilterPatterns rejects patterns rsync would read as something other
+// than a single plain pattern, and those the sftp backend cannot compile, so
+// they fail when a sync is requested rather than when it runs.
+func validateFilterPatterns(include []string, exclude []string) error {
+	if len(include)+len(exclude) > maxFilterPatterns {
+		return fmt.Errorf("at most %d filter patterns are allowed", maxFilterPatterns)
+	}
+	for _, pattern := range slices.Concat(include, exclude) {
+		if strings.TrimSpace(pattern) == "" {
+			return errors.New("filter patterns must not be empty")
+		}
+		if strings.ContainsAny(pattern, "\r\n\x00") {
+			return fmt.Errorf("filter pattern %q must be a single line", pattern)
+		}
+	}
+	if _, err := compileSFTPRules(filterRules(include, exclude)); err != nil {
+		return err
+	}
+	return nil
+}
+
+func ListFilters(filters *filterStorage) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		filters.Lock()
+		defer filters.Unlock()
+
+		result := Result[FilterProfile]{
+			Error:   "",
+			Results: make([]FilterProfile, 0, len(filters.data)),
+		}
+		for _, profile := range filters.data {
+			result.Results = append(result.Results, *profile)
+		}
+
+		return c.JSON(http.StatusOK, result)
+	}
+}
+
+func SaveFilter(filters *filterStorage) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		request := &FilterProfileRequest{}
+
+		err := c.Bind(request)
+		if err != nil {
+			return fmt.Errorf("load request: %w", err)
+		}
+
+		name := strings.TrimSpace(c.Param("name"))
+		if name == "" {
+			return c.JSON(http.StatusBadRequest, Result[string]{Error: "filter profile name must not be empty"})
+		}
+		if err := validateFilterPatterns(request.Include, request.Exclude); err != nil {
+			return c.JSON(http.StatusBadRequest, Result[string]{Error: err.Error()})
+		}
+
+		filters.Lock()
+		defer filters.Unlock()
+
+		profile, _ := filters.find(name)
+		if profile == nil {
+			profile = &FilterProfile{Name: name}
+			filters.data = append(filters.data, profile)
+			slices.SortFunc(filters.data, func(a, b *FilterProfile) int {
+				return strings.Compare(a.Name, b.Name)
+			})
+		}
+		profile.Include = request.Include
+		profile.Exclude = request.Exclude
+		profile.UpdatedBy = requestActor(c)
+		profile.UpdatedAt = time.Now()
+
+		if err := writeJSONFile(filters.path, filters.data); err != nil {
+			return fmt.Errorf("save filters: %w", err)
+		}
+
+		return c.JSON(http.StatusOK, Result[FilterProfile]{Results: []FilterProfile{*profile}})
+	}
+}
+
+func DeleteFilter(filters *filterStorage) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		filters.Lock()
+		defer filters.Unlock()
+
+		_, index := filters.find(c.Param("name"))
+		if index < 0 {
+			return c.JSON(http.StatusNotFound, Result[string]{Error: errFilterNotFound.Error()})
+		}
+
+		filters.data = slices.Delete(filters.data, index, index+1)
+		if err := writeJSONFile(filters.path, filters.data); err != nil {
+			return fmt.Errorf("save filters: %w", err)
+		}
+
+		return c.JSON(http.StatusOK, Result[string]{})
+	}
+}
@@ -7,6 +7,9 @@ import (
 	"fmt"
 	"net/http"
 	"net/url"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -16,26 +19,167 @@ import (
 
 var registerOnce sync.Once
 
+type remote struct {
+	Name string `json:"name"`
+	Host string `json:"host"`
+}
+
 type dir struct {
-	Path   string `json:"path"`
-	Synced bool   `json:"synced"`
+	Remote      string `json:"remote"`
+	Path        string `json:"path"`
+	Synced      bool   `json:"synced"`
+	Status      string `json:"status"`
+	LocalBytes  uint64 `json:"local_bytes"`
+	RemoteBytes uint64 `json:"remote_bytes"`
+	// Actions lists what the path access of the user allows on the dir.
+	Actions []string `json:"actions"`
+}
+
+// allows reports whether the dir grants action to the current user.
+func (current dir) allows(action string) bool {
+	return slices.Contains(current.Actions, action)
+}
+
+// treeNode is a directory in the dirs tree. Children is nil until loaded.
+type treeNode struct {
+	Remote      string     `json:"remote"`
+	Path        string     `json:"path"`
+	Name        string     `json:"name"`
+	Status      string     `json:"status"`
+	LocalBytes  uint64     `json:"local_bytes"`
+	RemoteBytes uint64     `json:"remote_bytes"`
+	HasChildren bool       `json:"has_children"`
+	Actions     []string   `json:"actions"`
+	Children    []treeNode `json:"children"`
 }
 
 type syncItem struct {
-	Path       string `json:"path"`
-	Progress   uint   `json:"progress"`
-	Speed      uint   `json:"speed"`
-	Downloaded uint   `json:"downloaded"`
-	TimeLeft   string `json:"time_left"`
+	ID             string     `json:"id"`
+	Path           string     `json:"path"`
+	Remote         string     `json:"remote"`
+	State          string     `json:"state"`
+	Position       int        `json:"position"`
+	Attempt        int        `json:"attempt"`
+	MaxAttempts    int        `json:"max_attempts"`
+	NextRetry      *time.Time `json:"next_retry"`
+	BwLimit        uint       `json:"bwlimit"`
+	AppliedBwLimit uint       `json:"applied_bwlimit"`
+	FilterProfile  string     `json:"filter_profile"`
+	Filter         []string   `json:"filter"`
+	Mirror         bool       `json:"mirror"`
+	Progress       uint       `json:"progress"`
+	Speed          uint       `json:"speed"`
+	Downloaded     uint       `json:"downloaded"`
+	TimeLeft       string     `json:"time_left"`
+	Estimate       uint64     `json:"estimate"`
+	Paused         bool       `json:"paused"`
+}
+
+type historyItem struct {
+	ID           string    `json:"id"`
+	Path         string    `json:"path"`
+	StartedBy    string    `json:"started_by"`
+	StartedAt    time.Time `json:"started_at"`
+	FinishedAt   time.Time `json:"finished_at"`
+	Status       string    `json:"status"`
+	Attempts     int       `json:"attempts"`
+	Downloaded   uint      `json:"downloaded"`
+	Progress     uint      `json:"progress"`
+	ExitCode     int       `json:"exit_code"`
+	Error        string    `json:"error"`
+	Stderr       []string  `json:"stderr"`
+	Mirror       bool      `json:"mirror"`
+	Deleted      []string  `json:"deleted"`
+	DeletedCount int       `json:"deleted_count"`
+	CancelledBy  string    `json:"cancelled_by"`
+}
+
+type scheduleItem struct {
+	ID         string     `json:"id"`
+	Path       string     `json:"path"`
+	Cron       string     `json:"cron"`
+	Enabled    bool       `json:"enabled"`
+	Mirror     bool       `json:"mirror"`
+	LastRun    time.Time  `json:"last_run"`
+	NextRun    *time.Time `json:"next_run"`
+	LastResult string     `json:"last_result"`
+}
+
+// apiToken is an API token for scripts. Token only holds the secret right
+// after it was created.
+type apiToken struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	Owner      string     `json:"owner"`
+	Scope      string     `json:"scope"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	Expired    bool       `json:"expired"`
+	Token      string     `json:"token"`
+}
+
+// session is a browser login, listed for admins.
+type session struct {
+	ID         string    `json:"id"`
+	User       string    `json:"user"`
+	Subject    string    `json:"subject"`
+	Role       string    `json:"role"`
+	IP         string    `json:"ip"`
+	UserAgent  string    `json:"user_agent"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	Current    bool      `json:"current"`
+}
+
+type filterProfile struct {
+	Name    string   `json:"name"`
+	Include []string `json:"include"`
+	Exclude []string `json:"exclude"`
+}
+
+type previewItem struct {
+	Path   string `json:"path"`
+	Change string `json:"change"`
+	Dir    bool   `json:"dir"`
+	Size   uint64 `json:"size"`
+}
+
+type syncPreview struct {
+	Path         string        `json:"path"`
+	New          int           `json:"new"`
+	Changed      int           `json:"changed"`
+	Deleted      int           `json:"deleted"`
+	TotalBytes   uint64        `json:"total_bytes"`
+	DeletedBytes uint64        `json:"deleted_bytes"`
+	Items        []previewItem `json:"items"`
+	Truncated    bool          `json:"truncated"`
 }
 
 type baseResponse struct {
 	Error string `json:"error"`
 }
 
+type treeResponse struct {
+	baseResponse
+	Results     []treeNode `json:"results"`
+	GeneratedAt *time.Time `json:"generated_at"`
+	Stale       bool       `json:"stale"`
+	Partial     bool       `json:"partial"`
+}
+
+type remotesResponse struct {
+	baseResponse
+	Results []remote `json:"results"`
+}
+
 type dirsResponse struct {
 	baseResponse
-	Results []dir `json:"results"`
+	Results     []dir      `json:"results"`
+	GeneratedAt *time.Time `json:"generated_at"`
+	Stale       bool       `json:"stale"`
+	Partial     bool       `json:"partial"`
 }
 
 type syncsResponse struct {
@@ -43,10 +187,66 @@ type syncsResponse struct {
 	Results []syncItem `json:"results"`
 }
 
+type historyResponse struct {
+	baseResponse
+	Results []historyItem `json:"results"`
+	Total   int           `json:"total"`
+}
+
+type schedulesResponse struct {
+	baseResponse
+	Results []scheduleItem `json:"results"`
+}
+
+type filtersResponse struct {
+	baseResponse
+	Results []filterProfile `json:"results"`
+}
+
+type tokensResponse struct {
+	baseResponse
+	Results []apiToken `json:"results"`
+}
+
+type sessionsResponse struct {
+	baseResponse
+	Results []session `json:"results"`
+}
+
+type previewResponse struct {
+	baseResponse
+	Results []syncPreview `json:"results"`
+}
+
 type user struct {
 	Subject string `json:"subject"`
 	Email   string `json:"email"`
 	Name    string `json:"name"`
+	Role    string `json:"role"`
+}
+
+// roleRank mirrors the server side roles, each one may do everything the
+// roles below it may.
+var roleRank = map[string]int{
+	"viewer":   1,
+	"operator": 2,
+	"admin":    3,
+}
+
+// diskStatus is the free space of the fullest volume holding local copies.
+type diskStatus struct {
+	Total     uint64    `json:"total"`
+	Used      uint64    `json:"used"`
+	Free      uint64    `json:"free"`
+	Available uint64    `json:"available"`
+	Reserve   uint64    `json:"reserve"`
+	Low       bool      `json:"low"`
+	CheckedAt time.Time `json:"checked_at"`
+}
+
+type statusResponse struct {
+	baseResponse
+	Results []diskStatus `json:"results"`
 }
 
 type userResponse struct {
@@ -54,21 +254,92 @@ type userResponse struct {
 	Results []user `json:"results"`
 }
 
+type syncEvent struct {
+	Type  string     `json:"type"`
+	Sync  *syncItem  `json:"sync"`
+	Syncs []syncItem `json:"syncs"`
+}
+
 type pathRequest struct {
 	Path string `json:"path"`
 }
 
+type syncRequest struct {
+	Path    string `json:"path"`
+	Profile string `json:"profile,omitempty"`
+	Mirror  bool   `json:"mirror,omitempty"`
+}
+
+type scheduleRequest struct {
+	Path    string `json:"path,omitempty"`
+	Cron    string `json:"cron,omitempty"`
+	Enabled *bool  `json:"enabled,omitempty"`
+	Mirror  *bool  `json:"mirror,omitempty"`
+}
+
+type tokenRequest struct {
+	Name          string `json:"name"`
+	Scope         string `json:"scope"`
+	ExpiresInDays int    `json:"expires_in_days,omitempty"`
+}
+
+type bandwidthRequest struct {
+	Path    string `json:"path"`
+	BwLimit string `json:"bwlimit"`
+}
+
+type moveRequest struct {
+	Path     string `json:"path"`
+	Position int    `json:"position"`
+}
+
 type dashboard struct {
 	app.Compo
 
-	dirs        []dir
-	syncs       []syncItem
-	errors      []string
-	currentUser user
-	dirFilter   string
-	active      bool
+	remotes      []remote
+	dirs         []dir
+	tree         map[string][]treeNode
+	expanded     map[string]bool
+	dirsAt       *time.Time
+	dirsStale    bool
+	dirsPartial  bool
+	refreshing   bool
+	syncs        []syncItem
+	history      []historyItem
+	historyTotal int
+	schedules    []scheduleItem
+	scheduleForm scheduleRequest
+	filters      []filterProfile
+	tokens       []apiToken
+	tokenForm    tokenRequest
+	newToken     *apiToken
+	sessions     []session
+	syncProfile  string
+	syncMirror   bool
+	preview      *syncPreview
+	previewing   string
+	errors       []string
+	currentUser  user
+	disk         *diskStatus
+	dirFilter    string
+	dirRemote    string
+	active       bool
+
+	events    app.Value
+	listeners []app.Func
+	streaming bool
 }
 
+const (
+	historyPageSize = 20
+	// previewRows caps how many changed items the preview dialog lists.
+	previewRows = 200
+	// eventSourceClosed is the EventSource readyState after the server refused
+	// the stream, in which case the browser does not reconnect by itself.
+	eventSourceClosed  = 2
+	eventRetryInterval = 30 * time.Second
+)
+
 var errAuthenticationRequired = errors.New("authentication required")
 
 func RegisterRoutes() {
@@ -98,11 +369,13 @@ func NewHandler() *app.Handler {
 func (d *dashboard) OnMount(ctx app.Context) {
 	d.active = true
 	d.refreshAll(ctx)
+	d.connectEvents(ctx)
 	d.schedulePoll(ctx)
 }
 
 func (d *dashboard) OnDismount() {
 	d.active = false
+	d.closeEvents()
 }
 
 func (d *dashboard) Render() app.UI {
@@ -117,37 +390,101 @@ func (d *dashboard) Render() app.UI {
 				app.Div().Class("inline-actions").Body(
 					app.Div().Class("status-chip").Body(
 						app.Span().Class("status-label").Text("Active syncs"),
-						app.Strong().Text(fmt.Sprintf("%d", len(d.syncs))),
+						app.Strong().Text(fmt.Sprintf("%d", len(d.syncs)-d.queuedCount())),
+					),
+					app.Div().Class("status-chip").Body(
+						app.Span().Class("status-label").Text("Queued"),
+						app.Strong().Text(fmt.Sprintf("%d", d.queuedCount())),
 					),
+					d.renderDiskChip(),
 					d.renderUserChip(),
 				),
 			),
 		),
+		d.renderPreviewDialog(),
 		app.Div().Class("page").Body(
+			d.renderDiskNotice(),
 			d.renderErrors(),
 			app.Div().Class("cards").Body(
 				app.Section().Class("card").Body(
 					app.Div().Class("card-head").Body(
 						app.H2().Text("Syncs"),
-						app.P().Text("Refreshing every 2 seconds."),
+						app.P().Text(d.syncsCaption()),
 					),
 					d.renderSyncsTable(),
 				),
 				app.Section().Class("card").Body(
 					app.Div().Class("card-head").Body(
 						app.H2().Text("Remote dirs"),
-						app.P().Text("Start, re-run, or remove synced directories."),
+						app.P().Text(d.dirsCaption()),
 					),
-					app.Div().Class("filter-row").Body(
+					app.Div().Class("filter-row form-row").Body(
 						d.renderDirFilter(),
+						app.If(d.can("operator"), func() app.UI {
+							return app.Button().
+								Class("action-button secondary").
+								Type("button").
+								Text("Refresh").
+								Disabled(d.refreshing).
+								OnClick(func(ctx app.Context, e app.Event) {
+									d.handleRefreshDirs(ctx)
+								})
+						}),
+						d.renderRemoteSelect(),
+						d.renderProfileSelect(),
+						renderCheckbox("Mirror", d.syncMirror, func(checked bool) {
+							d.syncMirror = checked
+						}),
+					),
+					d.renderDirsTree(),
+				),
+				app.Section().Class("card").Body(
+					app.Div().Class("card-head").Body(
+						app.H2().Text("Schedules"),
+						app.P().Text("Recurring syncs, using cron expressions such as \"0 3 * * *\" or \"@every 6h\"."),
+					),
+					d.renderScheduleForm(),
+					d.renderSchedulesTable(),
+				),
+				app.Section().Class("card").Body(
+					app.Div().Class("card-head").Body(
+						app.H2().Text("History"),
+						app.P().Text(d.historyCaption()),
 					),
-					d.renderDirsTable(),
+					d.renderHistoryTable(),
 				),
+				app.If(d.currentUser.Subject != "", func() app.UI {
+					return app.Section().Class("card").Body(
+						app.Div().Class("card-head").Body(
+							app.H2().Text("API tokens"),
+							app.P().Text("Tokens let scripts call the API as you, sent as \"Authorization: Bearer <token>\"."),
+						),
+						d.renderNewToken(),
+						d.renderTokenForm(),
+						d.renderTokensTable(),
+					)
+				}),
+				app.If(d.currentUser.Subject != "" && d.can("admin"), func() app.UI {
+					return app.Section().Class("card").Body(
+						app.Div().Class("card-head").Body(
+							app.H2().Text("Sessions"),
+							app.P().Text("Browsers signed in right now. Revoking a session logs it out on its next request."),
+						),
+						d.renderSessionsTable(),
+					)
+				}),
 			),
 		),
 	)
 }
 
+func (d *dashboard) syncsCaption() string {
+	if d.streaming {
+		return "Live updates."
+	}
+	return "Refreshing every 2 seconds."
+}
+
 func (d *dashboard) renderUserChip() app.UI {
 	label := strings.TrimSpace(d.currentUser.Name)
 	if label == "" {
@@ -159,6 +496,41 @@ func (d *dashboard) renderUserChip() app.UI {
 
 	return app.Div().Class("status-chip").Body(
 		app.Span().Class("status-label").Text(label),
+		app.If(d.currentUser.Role != "", func() app.UI {
+			return app.Strong().Text(d.currentUser.Role)
+		}),
+	)
+}
+
+// can reports whether the current user has at least role. Without
+// authentication there is no role and everything is allowed.
+func (d *dashboard) can(role string) bool {
+	return d.currentUser.Role == "" || roleRank[d.currentUser.Role] >= roleRank[role]
+}
+
+func (d *dashboard) renderDiskChip() app.UI {
+	if d.disk == nil {
+		return app.Div()
+	}
+	return app.Div().Class("status-chip").Title(fmt.Sprintf("%s of %s used", formatIEC(d.disk.Used), formatIEC(d.disk.Total))).Body(
+		app.Span().Class("status-label").Text("Free space"),
+		app.Strong().Text(formatIEC(d.disk.Free)),
+	)
+}
+
+// renderDiskNotice warns that syncs are paused because a volume holding local
+// copies is running out of space.
+func (d *dashboard) renderDiskNotice() app.UI {
+	if d.disk == nil || !d.disk.Low {
+		return app.Div()
+	}
+	return app.Div().Class("notice-stack").Body(
+		app.Div().Class("notice warning").Body(
+			app.Div().Class("notice-copy").Text(fmt.Sprintf(
+				"Disk space is low: %s free, %s is kept in reserve. Running syncs are paused and new ones refused until %s is free.",
+				formatIEC(d.disk.Free), formatIEC(d.disk.Reserve), formatIEC(2*d.disk.Reserve),
+			)),
+		),
 	)
 }
 
@@ -194,10 +566,65 @@ func (d *dashboard) renderDirFilter() app.UI {
 		Value(d.dirFilter).
 		OnInput(func(ctx app.Context, e app.Event) {
 			d.dirFilter = e.Get("target").Get("value").String()
+			if d.dirs == nil && strings.TrimSpace(d.dirFilter) != "" {
+				d.refreshFlatDirs(ctx)
+			}
 			ctx.Update()
 		})
 }
 
+// renderRemoteSelect narrows the dirs table to one remote. It is hidden when
+// there is only one.
+func (d *dashboard) renderRemoteSelect() app.UI {
+	if len(d.remotes) < 2 {
+		return app.Div()
+	}
+
+	options := make([]app.UI, 0, len(d.remotes)+1)
+	options = append(options, app.Option().Value("").Text("All remotes").Selected(d.dirRemote == ""))
+	for _, current := range d.remotes {
+		options = append(options, app.Option().Value(current.Name).Text(current.Name+" ("+current.Host+")").Selected(d.dirRemote == current.Name))
+	}
+
+	return app.Select().
+		Class("filter-input profile-select").
+		Title("Remote to list").
+		OnChange(func(ctx app.Context, e app.Event) {
+			d.dirRemote = e.Get("target").Get("value").String()
+			ctx.Update()
+		}).
+		Body(options...)
+}
+
+func (d *dashboard) renderProfileSelect() app.UI {
+	options := make([]app.UI, 0, len(d.filters)+1)
+	options = append(options, app.Option().Value("").Text("No filter profile").Selected(d.syncProfile == ""))
+	for _, profile := range d.filters {
+		options = append(options, app.Option().Value(profile.Name).Text(profile.Name).Selected(d.syncProfile == profile.Name))
+	}
+
+	return app.Select().
+		Class("filter-input profile-select").
+		Title("Filter profile used when starting a sync").
+		OnChange(func(ctx app.Context, e app.Event) {
+			d.syncProfile = e.Get("target").Get("value").String()
+		}).
+		Body(options...)
+}
+
+// renderCheckbox is a labelled checkbox for the form rows.
+func renderCheckbox(label string, checked bool, onChange func(bool)) app.UI {
+	return app.Label().Class("check-label").Body(
+		app.Input().
+			Type("checkbox").
+			Checked(checked).
+			OnChange(func(ctx app.Context, e app.Event) {
+				onChange(e.Get("target").Get("checked").Bool())
+			}),
+		app.Text(label),
+	)
+}
+
 func (d *dashboard) renderSyncsTable() app.UI {
 	rows := make([]app.UI, 0, len(d.syncs)+1)
 	if len(d.syncs) == 0 {
@@ -207,8 +634,17 @@ func (d *dashboard) renderSyncsTable() app.UI {
 	} else {
 		for _, sync := range d.syncs {
 			current := sync
+			if current.State == "queued" {
+				rows = append(rows, d.renderQueuedRow(current))
+				continue
+			}
 			rows = append(rows, app.Tr().Body(
-				app.Td().Class("path-cell").Text(current.Path),
+				app.Td().Class("path-cell").Body(
+					app.Div().Text(current.Path),
+					renderAttemptDetail(current),
+					renderBandwidthDetail(current),
+					renderFilterDetail(current),
+				),
 				app.Td().Body(
 					app.Div().Class("progress-wrap").Body(
 						app.Div().Class("progress-track").Body(
@@ -220,16 +656,21 @@ func (d *dashboard) renderSyncsTable() app.UI {
 					),
 				),
 				app.Td().Text(emptyDash(current.TimeLeft)),
-				app.Td().Text(formatIEC(uint64(current.Downloaded))),
+				app.Td().Text(formatTransferred(current)),
 				app.Td().Text(formatIEC(uint64(current.Speed))+"/s"),
 				app.Td().Class("actions-cell").Body(
-					app.Button().
-						Class("action-button danger").
-						Type("button").
-						Text("Cancel").
-						OnClick(func(ctx app.Context, e app.Event) {
-							d.handleCancel(ctx, current.Path)
-						}),
+					app.If(d.can("operator"), func() app.UI {
+						return app.Div().Class("inline-actions").Body(
+							d.renderLimitButton(current),
+							app.Button().
+								Class("action-button danger").
+								Type("button").
+								Text("Cancel").
+								OnClick(func(ctx app.Context, e app.Event) {
+									d.handleCancel(ctx, current.Path)
+								}),
+						)
+					}),
 				),
 			))
 		}
@@ -252,6 +693,233 @@ func (d *dashboard) renderSyncsTable() app.UI {
 	)
 }
 
+func renderAttemptDetail(current syncItem) app.UI {
+	if current.Paused {
+		return app.Div().Class("history-detail").Text("Paused until disk space is freed")
+	}
+	if current.NextRetry != nil {
+		return app.Div().Class("history-detail").Text(fmt.Sprintf(
+			"Attempt %d of %d failed, retrying at %s",
+			current.Attempt, current.MaxAttempts, current.NextRetry.Local().Format("15:04:05"),
+		))
+	}
+	if current.Attempt > 1 {
+		return app.Div().Class("history-detail").Text(fmt.Sprintf("Attempt %d of %d", current.Attempt, current.MaxAttempts))
+	}
+	return app.Div()
+}
+
+func renderBandwidthDetail(current syncItem) app.UI {
+	if current.AppliedBwLimit == 0 {
+		return app.Div()
+	}
+	return app.Div().Class("history-detail").Text(fmt.Sprintf("Limited to %s/s", formatIEC(uint64(current.AppliedBwLimit)*1024)))
+}
+
+func renderFilterDetail(current syncItem) app.UI {
+	details := make([]string, 0, 2)
+	if current.Mirror {
+		details = append(details, "Mirror, deleting local files gone on the remote")
+	}
+	if len(current.Filter) > 0 {
+		label := strings.Join(current.Filter, ", ")
+		if current.FilterProfile != "" {
+			label = current.FilterProfile + ": " + label
+		}
+		details = append(details, "Filter "+label)
+	}
+	if len(details) == 0 {
+		return app.Div()
+	}
+	return app.Div().Class("history-detail").Text(strings.Join(details, ". "))
+}
+
+func (d *dashboard) renderLimitButton(current syncItem) app.UI {
+	return app.Button().
+		Class("action-button secondary compact").
+		Type("button").
+		Text("Limit").
+		OnClick(func(ctx app.Context, e app.Event) {
+			d.handleBandwidth(ctx, current)
+		})
+}
+
+func (d *dashboard) renderQueuedRow(current syncItem) app.UI {
+	return app.Tr().Class("queued-row").Body(
+		app.Td().Class("path-cell").Body(
+			app.Div().Text(current.Path),
+			renderFilterDetail(current),
+		),
+		app.Td().Body(
+			app.Span().Class("state-pill pending").Text(fmt.Sprintf("queued #%d", current.Position)),
+		),
+		app.Td().Text("-"),
+		app.Td().Text("-"),
+		app.Td().Text("-"),
+		app.Td().Class("actions-cell").Body(
+			app.If(d.can("operator"), func() app.UI {
+				return app.Div().Class("inline-actions").Body(
+					app.Button().
+						Class("action-button secondary compact").
+						Type("button").
+						Text("Up").
+						Disabled(current.Position <= 1).
+						OnClick(func(ctx app.Context, e app.Event) {
+							d.handleMove(ctx, current.Path, current.Position-1)
+						}),
+					app.Button().
+						Class("action-button secondary compact").
+						Type("button").
+						Text("Down").
+						Disabled(current.Position >= d.queuedCount()).
+						OnClick(func(ctx app.Context, e app.Event) {
+							d.handleMove(ctx, current.Path, current.Position+1)
+						}),
+					d.renderLimitButton(current),
+					app.Button().
+						Class("action-button danger").
+						Type("button").
+						Text("Drop").
+						OnClick(func(ctx app.Context, e app.Event) {
+							d.handleDrop(ctx, current.Path)
+						}),
+				)
+			}),
+		),
+	)
+}
+
+func (d *dashboard) queuedCount() int {
+	count := 0
+	for _, sync := range d.syncs {
+		if sync.State == "queued" {
+			count++
+		}
+	}
+	return count
+}
+
+// renderDirsTree shows the remote dirs as a tree, each remote being a root
+// that starts out expanded. Filtering switches to the flat table of matches.
+func (d *dashboard) renderDirsTree() app.UI {
+	if strings.TrimSpace(d.dirFilter) != "" {
+		return d.renderDirsTable()
+	}
+
+	rows := d.renderTreeRows(nil, "", 0)
+	if len(rows) == 0 {
+		rows = append(rows, app.Tr().Body(
+			app.Td().ColSpan(3).Class("empty-state").Text("No remote directories found."),
+		))
+	}
+
+	return app.Div().Class("table-wrap").Body(
+		app.Table().Class("data-table").Body(
+			app.THead().Body(
+				app.Tr().Body(
+					app.Th().Text("Path"),
+					app.Th().Text("Status"),
+					app.Th().Text(""),
+				),
+			),
+			app.TBody().Body(rows...),
+		),
+	)
+}
+
+func (d *dashboard) renderTreeRows(rows []app.UI, parent string, level int) []app.UI {
+	for _, node := range d.tree[parent] {
+		if level == 0 && d.dirRemote != "" && node.Remote != d.dirRemote {
+			continue
+		}
+		rows = append(rows, d.renderTreeRow(node, level))
+		if !node.HasChildren || !d.isExpanded(node) {
+			continue
+		}
+		if _, ok := d.tree[node.Path]; !ok {
+			rows = append(rows, app.Tr().Body(
+				app.Td().ColSpan(3).Class("empty-state").Text("Loading..."),
+			))
+			continue
+		}
+		rows = d.renderTreeRows(rows, node.Path, level+1)
+	}
+	return rows
+}
+
+func (d *dashboard) renderTreeRow(node treeNode, level int) app.UI {
+	rowClass := "dir-row"
+	if node.Status == "synced" {
+		rowClass += " synced"
+	}
+
+	toggle := app.UI(app.Span().Class("tree-toggle"))
+	if node.HasChildren {
+		symbol := "▸"
+		if d.isExpanded(node) {
+			symbol = "▾"
+		}
+		toggle = app.Button().
+			Class("tree-toggle").
+			Type("button").
+			Text(symbol).
+			OnClick(func(ctx app.Context, e app.Event) {
+				d.handleToggleDir(ctx, node)
+			})
+	}
+
+	// A remote root cannot be synced as a whole, only the dirs below it.
+	actions := app.UI(app.Div())
+	nameClass := "tree-name"
+	if isTreeRoot(node) {
+		nameClass += " root"
+	} else {
+		actions = renderDirActions(d, node.dir())
+	}
+
+	return app.Tr().Class(rowClass).Body(
+		app.Td().Class("path-cell dir-path-cell").Body(
+			app.Div().
+				Class("tree-node").
+				Style("padding-left", fmt.Sprintf("%dpx", level*18)).
+				Title(node.Path).
+				Body(
+					toggle,
+					app.Span().Class(nameClass).Text(node.Name),
+				),
+			app.Div().Class("mobile-dir-actions").Body(actions),
+		),
+		app.Td().Body(
+			app.Span().Class(syncStateClass(node.Status)).Text(syncStateText(node.Status)),
+			app.Div().Class("history-detail").Text(formatIEC(node.LocalBytes)+" of "+formatIEC(node.RemoteBytes)),
+		),
+		app.Td().Class("desktop-dir-actions").Body(app.Div().Class("actions-cell").Body(actions)),
+	)
+}
+
+func (d *dashboard) isExpanded(node treeNode) bool {
+	if expanded, ok := d.expanded[node.Path]; ok {
+		return expanded
+	}
+	return isTreeRoot(node)
+}
+
+func isTreeRoot(node treeNode) bool {
+	return strings.HasSuffix(node.Path, ":/")
+}
+
+func (node treeNode) dir() dir {
+	return dir{
+		Remote:      node.Remote,
+		Path:        node.Path,
+		Synced:      node.Status == "synced",
+		Status:      node.Status,
+		LocalBytes:  node.LocalBytes,
+		RemoteBytes: node.RemoteBytes,
+		Actions:     node.Actions,
+	}
+}
+
 func (d *dashboard) renderDirsTable() app.UI {
 	dirs := d.filteredDirs()
 	rows := make([]app.UI, 0, len(dirs)+1)
@@ -277,7 +945,8 @@ func (d *dashboard) renderDirsTable() app.UI {
 					app.Div().Class("mobile-dir-actions").Body(renderDirActions(d, current)),
 				),
 				app.Td().Body(
-					app.Span().Class(syncStateClass(current.Synced)).Text(syncStateText(current.Synced)),
+					app.Span().Class(syncStateClass(current.Status)).Text(syncStateText(current.Status)),
+					app.Div().Class("history-detail").Text(formatIEC(current.LocalBytes)+" of "+formatIEC(current.RemoteBytes)),
 				),
 				app.Td().Class("desktop-dir-actions").Body(app.Div().Class("actions-cell").Body(renderDirActions(d, current))),
 			))
@@ -289,7 +958,7 @@ func (d *dashboard) renderDirsTable() app.UI {
 			app.THead().Body(
 				app.Tr().Body(
 					app.Th().Text("Path"),
-					app.Th().Text("Synced"),
+					app.Th().Text("Status"),
 					app.Th().Text(""),
 				),
 			),
@@ -298,123 +967,1014 @@ func (d *dashboard) renderDirsTable() app.UI {
 	)
 }
 
-func (d *dashboard) filteredDirs() []dir {
-	filter := strings.ToLower(strings.TrimSpace(d.dirFilter))
-	if filter == "" {
-		return d.dirs
+func (d *dashboard) renderScheduleForm() app.UI {
+	if !d.can("operator") {
+		return app.Div()
 	}
+	return app.Div().Class("filter-row form-row").Body(
+		app.Input().
+			Class("filter-input").
+			Type("text").
+			Placeholder("Remote path, e.g. remote:/dir").
+			Value(d.scheduleForm.Path).
+			OnInput(func(ctx app.Context, e app.Event) {
+				d.scheduleForm.Path = e.Get("target").Get("value").String()
+			}),
+		app.Input().
+			Class("filter-input").
+			Type("text").
+			Placeholder("Cron expression").
+			Value(d.scheduleForm.Cron).
+			OnInput(func(ctx app.Context, e app.Event) {
+				d.scheduleForm.Cron = e.Get("target").Get("value").String()
+			}),
+		renderCheckbox("Mirror", d.scheduleForm.Mirror != nil && *d.scheduleForm.Mirror, func(checked bool) {
+			d.scheduleForm.Mirror = &checked
+		}),
+		app.Button().
+			Class("action-button success").
+			Type("button").
+			Text("Add").
+			OnClick(func(ctx app.Context, e app.Event) {
+				d.handleCreateSchedule(ctx)
+			}),
+	)
+}
 
-	filtered := make([]dir, 0, len(d.dirs))
-	for _, dir := range d.dirs {
-		if strings.Contains(strings.ToLower(dir.Path), filter) {
-			filtered = append(filtered, dir)
-		}
+func (d *dashboard) renderTokenForm() app.UI {
+	scope := d.tokenForm.Scope
+	if scope == "" {
+		scope = "read"
 	}
-	return filtered
+	expiry := ""
+	if d.tokenForm.ExpiresInDays > 0 {
+		expiry = fmt.Sprintf("%d", d.tokenForm.ExpiresInDays)
+	}
+
+	return app.Div().Class("filter-row form-row").Body(
+		app.Input().
+			Class("filter-input").
+			Type("text").
+			Placeholder("Token name").
+			Value(d.tokenForm.Name).
+			OnInput(func(ctx app.Context, e app.Event) {
+				d.tokenForm.Name = e.Get("target").Get("value").String()
+			}),
+		app.Select().
+			Class("filter-input profile-select").
+			Title("What the token may do").
+			OnChange(func(ctx app.Context, e app.Event) {
+				d.tokenForm.Scope = e.Get("target").Get("value").String()
+			}).
+			Body(
+				app.Option().Value("read").Text("Read only").Selected(scope == "read"),
+				app.Option().Value("operate").Text("Operate").Selected(scope == "operate"),
+			),
+		app.Input().
+			Class("filter-input").
+			Type("number").
+			Min(0).
+			Placeholder("Expires in days, empty for never").
+			Value(expiry).
+			OnInput(func(ctx app.Context, e app.Event) {
+				days, _ := strconv.Atoi(e.Get("target").Get("value").String())
+				d.tokenForm.ExpiresInDays = max(days, 0)
+			}),
+		app.Button().
+			Class("action-button success").
+			Type("button").
+			Text("Create").
+			OnClick(func(ctx app.Context, e app.Event) {
+				d.handleCreateToken(ctx)
+			}),
+	)
 }
 
-func renderDirActions(d *dashboard, current dir) app.UI {
-	if current.Synced {
-		return app.Div().Class("inline-actions").Body(
-			app.Button().
-				Class("action-button secondary").
-				Type("button").
-				Text("Resync").
-				OnClick(func(ctx app.Context, e app.Event) {
-					d.handleSync(ctx, current.Path)
-				}),
+// renderNewToken shows the secret of the token just created, which cannot be
+// fetched again.
+func (d *dashboard) renderNewToken() app.UI {
+	if d.newToken == nil {
+		return app.Div()
+	}
+	return app.Div().Class("notice-stack").Body(
+		app.Div().Class("notice warning").Body(
+			app.Div().Class("notice-copy").Body(
+				app.Div().Text(fmt.Sprintf("Token %q created. Copy it now, it is not shown again:", d.newToken.Name)),
+				app.Code().Class("token-secret").Text(d.newToken.Token),
+			),
 			app.Button().
-				Class("action-button danger").
+				Class("action-button secondary compact").
 				Type("button").
-				Text("Remove").
+				Text("Dismiss").
 				OnClick(func(ctx app.Context, e app.Event) {
-					d.handleRemove(ctx, current.Path)
+					d.newToken = nil
 				}),
-		)
-	}
+		),
+	)
+}
 
-	return app.Button().
-		Class("action-button success").
-		Type("button").
-		Text("Sync").
-		OnClick(func(ctx app.Context, e app.Event) {
-			d.handleSync(ctx, current.Path)
+func (d *dashboard) renderTokensTable() app.UI {
+	rows := make([]app.UI, 0, len(d.tokens)+1)
+	if len(d.tokens) == 0 {
+		rows = append(rows, app.Tr().Body(
+			app.Td().ColSpan(6).Class("empty-state").Text("No API tokens."),
+		))
+	}
+	for _, token := range d.tokens {
+		current := token
+		expires := "never"
+		if current.ExpiresAt != nil {
+			expires = formatTime(*current.ExpiresAt)
+		}
+		if current.Expired {
+			expires += " (expired)"
+		}
+		lastUsed := "-"
+		if current.LastUsedAt != nil {
+			lastUsed = formatTime(*current.LastUsedAt)
+		}
+
+		rows = append(rows, app.Tr().Body(
+			app.Td().Class("path-cell").Text(current.Name),
+			app.Td().Text(current.Owner),
+			app.Td().Text(current.Scope),
+			app.Td().Text(expires),
+			app.Td().Text(lastUsed),
+			app.Td().Class("actions-cell").Body(
+				app.Button().
+					Class("action-button danger").
+					Type("button").
+					Text("Revoke").
+					OnClick(func(ctx app.Context, e app.Event) {
+						d.handleRevokeToken(ctx, current.ID)
+					}),
+			),
+		))
+	}
+
+	return app.Div().Class("table-wrap").Body(
+		app.Table().Class("data-table").Body(
+			app.THead().Body(
+				app.Tr().Body(
+					app.Th().Text("Name"),
+					app.Th().Text("Owner"),
+					app.Th().Text("Scope"),
+					app.Th().Text("Expires"),
+					app.Th().Text("Last used"),
+					app.Th().Text(""),
+				),
+			),
+			app.TBody().Body(rows...),
+		),
+	)
+}
+
+func (d *dashboard) renderSessionsTable() app.UI {
+	rows := make([]app.UI, 0, len(d.sessions)+1)
+	if len(d.sessions) == 0 {
+		rows = append(rows, app.Tr().Body(
+			app.Td().ColSpan(6).Class("empty-state").Text("No active sessions."),
+		))
+	}
+	for _, item := range d.sessions {
+		current := item
+		name := current.User
+		if current.Current {
+			name += " (this session)"
+		}
+
+		rows = append(rows, app.Tr().Body(
+			app.Td().Class("path-cell").Title(current.UserAgent).Text(name),
+			app.Td().Text(current.Role),
+			app.Td().Text(current.IP),
+			app.Td().Text(formatTime(current.LastSeenAt)),
+			app.Td().Text(formatTime(current.ExpiresAt)),
+			app.Td().Class("actions-cell").Body(
+				app.Button().
+					Class("action-button danger").
+					Type("button").
+					Text("Revoke").
+					OnClick(func(ctx app.Context, e app.Event) {
+						d.handleRevokeSession(ctx, "/api/sessions/"+url.PathEscape(current.ID))
+					}),
+				app.Button().
+					Class("action-button danger").
+					Type("button").
+					Title("Revoke every session of "+current.User).
+					Text("Revoke all").
+					OnClick(func(ctx app.Context, e app.Event) {
+						d.handleRevokeSession(ctx, "/api/sessions?subject="+url.QueryEscape(current.Subject))
+					}),
+			),
+		))
+	}
+
+	return app.Div().Class("table-wrap").Body(
+		app.Table().Class("data-table").Body(
+			app.THead().Body(
+				app.Tr().Body(
+					app.Th().Text("User"),
+					app.Th().Text("Role"),
+					app.Th().Text("IP"),
+					app.Th().Text("Last seen"),
+					app.Th().Text("Expires"),
+					app.Th().Text(""),
+				),
+			),
+			app.TBody().Body(rows...),
+		),
+	)
+}
+
+func (d *dashboard) renderSchedulesTable() app.UI {
+	rows := make([]app.UI, 0, len(d.schedules)+1)
+	if len(d.schedules) == 0 {
+		rows = append(rows, app.Tr().Body(
+			app.Td().ColSpan(6).Class("empty-state").Text("No schedules defined."),
+		))
+	} else {
+		for _, schedule := range d.schedules {
+			current := schedule
+			toggleText := "Disable"
+			if !current.Enabled {
+				toggleText = "Enable"
+			}
+
+			nextRun := "disabled"
+			if current.NextRun != nil {
+				nextRun = formatTime(*current.NextRun)
+			}
+
+			rows = append(rows, app.Tr().Body(
+				app.Td().Class("path-cell").Text(current.Path),
+				app.Td().Class("path-cell").Body(
+					app.Text(current.Cron),
+					app.If(current.Mirror, func() app.UI {
+						return app.Div().Class("history-detail").Text("mirror")
+					}),
+				),
+				app.Td().Text(nextRun),
+				app.Td().Text(formatTime(current.LastRun)),
+				app.Td().Body(
+					app.If(current.LastResult != "", func() app.UI {
+						return app.Span().Class(historyStateClass(current.LastResult)).Text(current.LastResult)
+					}).Else(func() app.UI {
+						return app.Text("-")
+					}),
+				),
+				app.Td().Class("actions-cell").Body(
+					app.If(d.can("operator"), func() app.UI {
+						return app.Div().Class("inline-actions").Body(
+							app.Button().
+								Class("action-button secondary").
+								Type("button").
+								Text(toggleText).
+								OnClick(func(ctx app.Context, e app.Event) {
+									d.handleToggleSchedule(ctx, current)
+								}),
+							app.Button().
+								Class("action-button danger").
+								Type("button").
+								Text("Delete").
+								OnClick(func(ctx app.Context, e app.Event) {
+									d.handleDeleteSchedule(ctx, current.ID)
+								}),
+						)
+					}),
+				),
+			))
+		}
+	}
+
+	return app.Div().Class("table-wrap").Body(
+		app.Table().Class("data-table").Body(
+			app.THead().Body(
+				app.Tr().Body(
+					app.Th().Text("Path"),
+					app.Th().Text("Schedule"),
+					app.Th().Text("Next run"),
+					app.Th().Text("Last run"),
+					app.Th().Text("Last result"),
+					app.Th().Text(""),
+				),
+			),
+			app.TBody().Body(rows...),
+		),
+	)
+}
+
+func (d *dashboard) dirsCaption() string {
+	caption := "Start, re-run, or remove synced directories."
+	if d.dirsAt == nil {
+		return caption
+	}
+	caption += " Listed " + formatTime(*d.dirsAt)
+	if d.dirsStale {
+		caption += ", refresh pending"
+	}
+	if d.dirsPartial {
+		caption += ", some remote directories could not be read"
+	}
+	return caption + "."
+}
+
+func (d *dashboard) historyCaption() string {
+	if d.historyTotal > len(d.history) {
+		return fmt.Sprintf("Latest %d of %d finished syncs.", len(d.history), d.historyTotal)
+	}
+	return "Finished, failed, and cancelled syncs."
+}
+
+func (d *dashboard) renderHistoryTable() app.UI {
+	rows := make([]app.UI, 0, len(d.history)+1)
+	if len(d.history) == 0 {
+		rows = append(rows, app.Tr().Body(
+			app.Td().ColSpan(6).Class("empty-state").Text("No syncs have finished yet."),
+		))
+	} else {
+		for _, item := range d.history {
+			rows = append(rows, app.Tr().Body(
+				app.Td().Class("path-cell").Body(
+					app.Div().Text(item.Path),
+					renderHistoryDetail(item),
+				),
+				app.Td().Body(
+					app.Span().Class(historyStateClass(item.Status)).Text(item.Status),
+				),
+				app.Td().Text(formatTime(item.StartedAt)),
+				app.Td().Text(formatDuration(item.FinishedAt.Sub(item.StartedAt))),
+				app.Td().Text(fmt.Sprintf("%s (%d%%)", formatIEC(uint64(item.Downloaded)), item.Progress)),
+				app.Td().Text(emptyDash(item.StartedBy)),
+			))
+		}
+	}
+
+	return app.Div().Class("table-wrap").Body(
+		app.Table().Class("data-table").Body(
+			app.THead().Body(
+				app.Tr().Body(
+					app.Th().Text("Path"),
+					app.Th().Text("Status"),
+					app.Th().Text("Started"),
+					app.Th().Text("Duration"),
+					app.Th().Text("Transferred"),
+					app.Th().Text("Started by"),
+				),
+			),
+			app.TBody().Body(rows...),
+		),
+	)
+}
+
+func renderHistoryDetail(item historyItem) app.UI {
+	switch {
+	case item.Status == "cancelled" && item.CancelledBy != "":
+		return app.Div().Class("history-detail").Text("Cancelled by " + item.CancelledBy)
+	case item.Status == "failed":
+		detail := fmt.Sprintf("Exit code %d", item.ExitCode)
+		if item.Attempts > 1 {
+			detail = fmt.Sprintf("Exit code %d after %d attempts", item.ExitCode, item.Attempts)
+		}
+		if len(item.Stderr) > 0 {
+			detail += ": " + item.Stderr[len(item.Stderr)-1]
+		} else if item.Error != "" {
+			detail += ": " + item.Error
+		}
+		return app.Div().Class("history-detail").Text(detail)
+	case item.DeletedCount > 0:
+		shown := item.Deleted[:min(len(item.Deleted), 3)]
+		detail := fmt.Sprintf("Deleted %d: %s", item.DeletedCount, strings.Join(shown, ", "))
+		if item.DeletedCount > len(shown) {
+			detail += ", ..."
+		}
+		return app.Div().Class("history-detail").Title(strings.Join(item.Deleted, "\n")).Text(detail)
+	}
+	return app.Div()
+}
+
+func (d *dashboard) filteredDirs() []dir {
+	filter := strings.ToLower(strings.TrimSpace(d.dirFilter))
+	if filter == "" && d.dirRemote == "" {
+		return d.dirs
+	}
+
+	filtered := make([]dir, 0, len(d.dirs))
+	for _, dir := range d.dirs {
+		if d.dirRemote != "" && dir.Remote != d.dirRemote {
+			continue
+		}
+		if strings.Contains(strings.ToLower(dir.Path), filter) {
+			filtered = append(filtered, dir)
+		}
+	}
+	return filtered
+}
+
+// renderDirActions offers what both the role and the path access of the user
+// allow on current. Dirs only shown on the way to granted ones get nothing.
+func renderDirActions(d *dashboard, current dir) app.UI {
+	preview := app.If(d.can("operator") && current.allows("view"), func() app.UI {
+		return app.Button().
+			Class("action-button secondary").
+			Type("button").
+			Text("Preview").
+			Disabled(d.previewing != "").
+			OnClick(func(ctx app.Context, e app.Event) {
+				d.handlePreview(ctx, current.Path)
+			})
+	})
+	canSync := d.can("operator") && current.allows("sync")
+
+	if current.Status != "missing" {
+		return app.Div().Class("inline-actions").Body(
+			preview,
+			app.If(canSync, func() app.UI {
+				return app.Button().
+					Class("action-button secondary").
+					Type("button").
+					Text("Resync").
+					OnClick(func(ctx app.Context, e app.Event) {
+						d.handleSync(ctx, current.Path)
+					})
+			}),
+			app.If(d.can("admin") && current.allows("remove"), func() app.UI {
+				return app.Button().
+					Class("action-button danger").
+					Type("button").
+					Text("Remove").
+					OnClick(func(ctx app.Context, e app.Event) {
+						d.handleRemove(ctx, current.Path)
+					})
+			}),
+		)
+	}
+
+	return app.Div().Class("inline-actions").Body(
+		preview,
+		app.If(canSync, func() app.UI {
+			return app.Button().
+				Class("action-button success").
+				Type("button").
+				Text("Sync").
+				OnClick(func(ctx app.Context, e app.Event) {
+					d.handleSync(ctx, current.Path)
+				})
+		}),
+	)
+}
+
+func (d *dashboard) renderPreviewDialog() app.UI {
+	if d.preview == nil {
+		return app.Div()
+	}
+	preview := *d.preview
+
+	rows := make([]app.UI, 0, min(len(preview.Items), previewRows)+1)
+	if len(preview.Items) == 0 {
+		rows = append(rows, app.Tr().Body(
+			app.Td().ColSpan(3).Class("empty-state").Text("Everything is already up to date."),
+		))
+	}
+	for i, item := range preview.Items {
+		if i >= previewRows {
+			break
+		}
+		path := item.Path
+		if item.Dir {
+			path += "/"
+		}
+		size := "-"
+		if !item.Dir {
+			size = formatIEC(item.Size)
+		}
+		rows = append(rows, app.Tr().Body(
+			app.Td().Body(app.Span().Class(previewChangeClass(item.Change)).Text(item.Change)),
+			app.Td().Class("path-cell").Text(path),
+			app.Td().Text(size),
+		))
+	}
+
+	summary := fmt.Sprintf("%d new, %d changed, %d deleted, %s to transfer.", preview.New, preview.Changed, preview.Deleted, formatIEC(preview.TotalBytes))
+	if preview.DeletedBytes > 0 {
+		summary += fmt.Sprintf(" %s would be deleted.", formatIEC(preview.DeletedBytes))
+	}
+	if preview.Truncated || len(preview.Items) > previewRows {
+		summary += fmt.Sprintf(" Showing the first %d items.", min(len(preview.Items), previewRows))
+	}
+
+	return app.Div().Class("dialog-backdrop").Body(
+		app.Section().Class("card dialog").Body(
+			app.Div().Class("card-head").Body(
+				app.H2().Text("Preview "+preview.Path),
+				app.P().Text(summary),
+			),
+			app.Div().Class("table-wrap dialog-body").Body(
+				app.Table().Class("data-table").Body(
+					app.THead().Body(
+						app.Tr().Body(
+							app.Th().Text("Change"),
+							app.Th().Text("Path"),
+							app.Th().Text("Size"),
+						),
+					),
+					app.TBody().Body(rows...),
+				),
+			),
+			app.Div().Class("inline-actions dialog-actions").Body(
+				app.Button().
+					Class("action-button secondary").
+					Type("button").
+					Text("Close").
+					OnClick(func(ctx app.Context, e app.Event) {
+						d.preview = nil
+					}),
+				app.If(d.can("operator"), func() app.UI {
+					return app.Button().
+						Class("action-button success").
+						Type("button").
+						Text("Start sync").
+						OnClick(func(ctx app.Context, e app.Event) {
+							d.preview = nil
+							d.handleSync(ctx, preview.Path)
+						})
+				}),
+			),
+		),
+	)
+}
+
+func (d *dashboard) handleSync(ctx app.Context, path string) {
+	request := syncRequest{Path: path, Profile: d.syncProfile, Mirror: d.syncMirror}
+	ctx.Async(func() {
+		if err := postJSON("/api/sync", request); err != nil {
+			ctx.Dispatch(func(ctx app.Context) {
+				d.handleError(err)
+			})
+			return
+		}
+
+		ctx.Dispatch(func(ctx app.Context) {
+			d.refreshAll(ctx)
+		})
+	})
+}
+
+func (d *dashboard) handlePreview(ctx app.Context, path string) {
+	request := syncRequest{Path: path, Profile: d.syncProfile, Mirror: d.syncMirror}
+	d.previewing = path
+	ctx.Async(func() {
+		result, err := fetchPreview(request)
+		ctx.Dispatch(func(ctx app.Context) {
+			d.previewing = ""
+			if err != nil {
+				d.handleError(err)
+				return
+			}
+			d.preview = &result
+		})
+	})
+}
+
+func (d *dashboard) handleRemove(ctx app.Context, path string) {
+	ctx.Async(func() {
+		if err := postPath("/api/remove", path); err != nil {
+			ctx.Dispatch(func(ctx app.Context) {
+				d.handleError(err)
+			})
+			return
+		}
+
+		ctx.Dispatch(func(ctx app.Context) {
+			d.refreshAll(ctx)
+		})
+	})
+}
+
+func (d *dashboard) handleCancel(ctx app.Context, path string) {
+	ctx.Async(func() {
+		if err := postPath("/api/cancel", path); err != nil {
+			ctx.Dispatch(func(ctx app.Context) {
+				d.handleError(err)
+			})
+			return
+		}
+
+		ctx.Dispatch(func(ctx app.Context) {
+			d.refreshSyncs(ctx, false)
+		})
+	})
+}
+
+// handleBandwidth asks for a new rsync --bwlimit value, such as "2m". An empty
+// answer goes back to the server default.
+func (d *dashboard) handleBandwidth(ctx app.Context, current syncItem) {
+	value := ""
+	if current.BwLimit > 0 {
+		value = fmt.Sprintf("%dk", current.BwLimit)
+	}
+	answer := app.Window().Call("prompt", "Bandwidth limit for "+current.Path+" (e.g. 512k, 2m; empty for default)", value)
+	if answer.Type() != app.TypeString {
+		return
+	}
+
+	request := bandwidthRequest{Path: current.Path, BwLimit: answer.String()}
+	ctx.Async(func() {
+		if err := postJSON("/api/sync/bwlimit", request); err != nil {
+			ctx.Dispatch(func(ctx app.Context) {
+				d.handleError(err)
+			})
+			return
+		}
+
+		ctx.Dispatch(func(ctx app.Context) {
+			d.refreshSyncs(ctx, false)
+		})
+	})
+}
+
+func (d *dashboard) handleMove(ctx app.Context, path string, position int) {
+	ctx.Async(func() {
+		if err := postJSON("/api/queue/move", moveRequest{Path: path, Position: position}); err != nil {
+			ctx.Dispatch(func(ctx app.Context) {
+				d.handleError(err)
+			})
+			return
+		}
+
+		ctx.Dispatch(func(ctx app.Context) {
+			d.refreshSyncs(ctx, false)
+		})
+	})
+}
+
+func (d *dashboard) handleDrop(ctx app.Context, path string) {
+	ctx.Async(func() {
+		if err := postPath("/api/queue/drop", path); err != nil {
+			ctx.Dispatch(func(ctx app.Context) {
+				d.handleError(err)
+			})
+			return
+		}
+
+		ctx.Dispatch(func(ctx app.Context) {
+			d.refreshSyncs(ctx, true)
+		})
+	})
+}
+
+func (d *dashboard) handleCreateSchedule(ctx app.Context) {
+	request := d.scheduleForm
+	ctx.Async(func() {
+		if err := sendJSON(http.MethodPost, "/api/schedules", request); err != nil {
+			ctx.Dispatch(func(ctx app.Context) {
+				d.handleError(err)
+			})
+			return
+		}
+
+		ctx.Dispatch(func(ctx app.Context) {
+			d.scheduleForm = scheduleRequest{}
+			d.refreshSchedules(ctx)
+		})
+	})
+}
+
+func (d *dashboard) handleToggleSchedule(ctx app.Context, schedule scheduleItem) {
+	enabled := !schedule.Enabled
+	ctx.Async(func() {
+		if err := sendJSON(http.MethodPut, "/api/schedules/"+url.PathEscape(schedule.ID), scheduleRequest{Enabled: &enabled}); err != nil {
+			ctx.Dispatch(func(ctx app.Context) {
+				d.handleError(err)
+			})
+			return
+		}
+
+		ctx.Dispatch(func(ctx app.Context) {
+			d.refreshSchedules(ctx)
+		})
+	})
+}
+
+func (d *dashboard) handleDeleteSchedule(ctx app.Context, id string) {
+	ctx.Async(func() {
+		if err := sendJSON(http.MethodDelete, "/api/schedules/"+url.PathEscape(id), nil); err != nil {
+			ctx.Dispatch(func(ctx app.Context) {
+				d.handleError(err)
+			})
+			return
+		}
+
+		ctx.Dispatch(func(ctx app.Context) {
+			d.refreshSchedules(ctx)
+		})
+	})
+}
+
+func (d *dashboard) handleCreateToken(ctx app.Context) {
+	request := d.tokenForm
+	if request.Scope == "" {
+		request.Scope = "read"
+	}
+	ctx.Async(func() {
+		created, err := createToken(request)
+		ctx.Dispatch(func(ctx app.Context) {
+			if err != nil {
+				d.handleError(err)
+				return
+			}
+			d.tokenForm = tokenRequest{}
+			d.newToken = &created
+			d.refreshTokens(ctx)
+		})
+	})
+}
+
+func (d *dashboard) handleRevokeToken(ctx app.Context, id string) {
+	ctx.Async(func() {
+		if err := sendJSON(http.MethodDelete, "/api/tokens/"+url.PathEscape(id), nil); err != nil {
+			ctx.Dispatch(func(ctx app.Context) {
+				d.handleError(err)
+			})
+			return
+		}
+
+		ctx.Dispatch(func(ctx app.Context) {
+			d.refreshTokens(ctx)
+		})
+	})
+}
+
+// handleRevokeSession revokes one session or, with the subject query, every
+// session of a user.
+func (d *dashboard) handleRevokeSession(ctx app.Context, path string) {
+	ctx.Async(func() {
+		if err := sendJSON(http.MethodDelete, path, nil); err != nil {
+			ctx.Dispatch(func(ctx app.Context) {
+				d.handleError(err)
+			})
+			return
+		}
+
+		ctx.Dispatch(func(ctx app.Context) {
+			d.refreshSessions(ctx)
+		})
+	})
+}
+
+func (d *dashboard) refreshAll(ctx app.Context) {
+	d.refreshUser(ctx)
+	d.refreshStatus(ctx)
+	d.refreshRemotes(ctx)
+	d.refreshDirs(ctx)
+	d.refreshSyncs(ctx, false)
+	d.refreshHistory(ctx)
+	d.refreshSchedules(ctx)
+	d.refreshFilters(ctx)
+}
+
+func (d *dashboard) refreshFilters(ctx app.Context) {
+	ctx.Async(func() {
+		result, err := fetchFilters()
+		ctx.Dispatch(func(ctx app.Context) {
+			if err != nil {
+				d.handleError(err)
+				return
+			}
+			d.filters = result
+		})
+	})
+}
+
+func (d *dashboard) refreshUser(ctx app.Context) {
+	ctx.Async(func() {
+		result, err := fetchUser()
+		ctx.Dispatch(func(ctx app.Context) {
+			if err != nil {
+				d.handleError(err)
+				return
+			}
+			d.currentUser = result
+			// Tokens only exist with authentication.
+			if result.Subject != "" {
+				d.refreshTokens(ctx)
+			}
+			if result.Subject != "" && d.can("admin") {
+				d.refreshSessions(ctx)
+			}
 		})
+	})
 }
 
-func (d *dashboard) handleSync(ctx app.Context, path string) {
+func (d *dashboard) refreshTokens(ctx app.Context) {
 	ctx.Async(func() {
-		if err := postPath("/api/sync", path); err != nil {
-			ctx.Dispatch(func(ctx app.Context) {
+		result, err := fetchTokens()
+		ctx.Dispatch(func(ctx app.Context) {
+			if err != nil {
 				d.handleError(err)
-			})
-			return
-		}
+				return
+			}
+			d.tokens = result
+		})
+	})
+}
 
+func (d *dashboard) refreshSessions(ctx app.Context) {
+	ctx.Async(func() {
+		result, err := fetchSessions()
 		ctx.Dispatch(func(ctx app.Context) {
-			d.refreshAll(ctx)
+			if err != nil {
+				d.handleError(err)
+				return
+			}
+			d.sessions = result
 		})
 	})
 }
 
-func (d *dashboard) handleRemove(ctx app.Context, path string) {
+func (d *dashboard) refreshStatus(ctx app.Context) {
 	ctx.Async(func() {
-		if err := postPath("/api/remove", path); err != nil {
-			ctx.Dispatch(func(ctx app.Context) {
+		result, err := fetchStatus()
+		ctx.Dispatch(func(ctx app.Context) {
+			if err != nil {
 				d.handleError(err)
-			})
-			return
-		}
+				return
+			}
+			d.disk = result
+		})
+	})
+}
 
+func (d *dashboard) refreshRemotes(ctx app.Context) {
+	ctx.Async(func() {
+		result, err := fetchRemotes()
 		ctx.Dispatch(func(ctx app.Context) {
-			d.refreshAll(ctx)
+			if err != nil {
+				d.handleError(err)
+				return
+			}
+			d.remotes = result
 		})
 	})
 }
 
-func (d *dashboard) handleCancel(ctx app.Context, path string) {
+// refreshDirs reloads the tree, and the flat list of dirs while it is being
+// filtered. Otherwise the flat list is dropped and fetched again once needed.
+func (d *dashboard) refreshDirs(ctx app.Context) {
+	d.refreshTree(ctx)
+	if strings.TrimSpace(d.dirFilter) == "" {
+		d.dirs = nil
+		return
+	}
+	d.refreshFlatDirs(ctx)
+}
+
+func (d *dashboard) refreshFlatDirs(ctx app.Context) {
 	ctx.Async(func() {
-		if err := postPath("/api/cancel", path); err != nil {
+		result, err := fetchDirs()
+		ctx.Dispatch(func(ctx app.Context) {
+			if err != nil {
+				d.handleError(err)
+				return
+			}
+			d.applyDirs(result)
+		})
+	})
+}
+
+func (d *dashboard) applyDirs(result dirsResponse) {
+	d.dirs = result.Results
+	d.dirsAt = result.GeneratedAt
+	d.dirsStale = result.Stale
+	d.dirsPartial = result.Partial
+}
+
+// refreshTree loads the remote roots with their top level dirs, and the
+// children of every dir that is expanded. Expanded dirs that are gone by now
+// are collapsed.
+func (d *dashboard) refreshTree(ctx app.Context) {
+	expanded := make([]string, 0, len(d.expanded))
+	for path, open := range d.expanded {
+		if open {
+			expanded = append(expanded, path)
+		}
+	}
+
+	ctx.Async(func() {
+		top, err := fetchTree("", 2)
+		if err != nil {
 			ctx.Dispatch(func(ctx app.Context) {
 				d.handleError(err)
 			})
 			return
 		}
 
+		tree := map[string][]treeNode{}
+		storeTree(tree, "", top.Results)
+		var gone []string
+		for _, path := range expanded {
+			if _, ok := tree[path]; ok {
+				continue
+			}
+			result, err := fetchTree(path, 1)
+			if err != nil {
+				gone = append(gone, path)
+				continue
+			}
+			storeTree(tree, path, result.Results)
+		}
+
 		ctx.Dispatch(func(ctx app.Context) {
-			d.refreshSyncs(ctx, false)
+			d.tree = tree
+			for _, path := range gone {
+				delete(d.expanded, path)
+			}
+			d.dirsAt = top.GeneratedAt
+			d.dirsStale = top.Stale
+			d.dirsPartial = top.Partial
 		})
 	})
 }
 
-func (d *dashboard) refreshAll(ctx app.Context) {
-	d.refreshUser(ctx)
-	d.refreshDirs(ctx)
-	d.refreshSyncs(ctx, false)
+func (d *dashboard) handleToggleDir(ctx app.Context, node treeNode) {
+	if d.expanded == nil {
+		d.expanded = map[string]bool{}
+	}
+	d.expanded[node.Path] = !d.isExpanded(node)
+	if !d.expanded[node.Path] {
+		return
+	}
+	if _, ok := d.tree[node.Path]; ok {
+		return
+	}
+
+	ctx.Async(func() {
+		result, err := fetchTree(node.Path, 1)
+		ctx.Dispatch(func(ctx app.Context) {
+			if err != nil {
+				d.expanded[node.Path] = false
+				d.handleError(err)
+				return
+			}
+			if d.tree == nil {
+				d.tree = map[string][]treeNode{}
+			}
+			storeTree(d.tree, node.Path, result.Results)
+		})
+	})
 }
 
-func (d *dashboard) refreshUser(ctx app.Context) {
+// storeTree files nodes under parent, along with whatever children came
+// nested in them.
+func storeTree(tree map[string][]treeNode, parent string, nodes []treeNode) {
+	tree[parent] = nodes
+	for _, node := range nodes {
+		if node.Children != nil {
+			storeTree(tree, node.Path, node.Children)
+		}
+	}
+}
+
+// handleRefreshDirs makes the server list every remote again instead of
+// answering from its cache.
+func (d *dashboard) handleRefreshDirs(ctx app.Context) {
+	d.refreshing = true
 	ctx.Async(func() {
-		result, err := fetchUser()
+		result, err := refreshRemoteDirs()
 		ctx.Dispatch(func(ctx app.Context) {
+			d.refreshing = false
 			if err != nil {
 				d.handleError(err)
 				return
 			}
-			d.currentUser = result
+			d.applyDirs(result)
+			d.refreshTree(ctx)
 		})
 	})
 }
 
-func (d *dashboard) refreshDirs(ctx app.Context) {
+func (d *dashboard) refreshHistory(ctx app.Context) {
 	ctx.Async(func() {
-		result, err := fetchDirs()
+		result, total, err := fetchHistory()
+		ctx.Dispatch(func(ctx app.Context) {
+			if err != nil {
+				d.handleError(err)
+				return
+			}
+			d.history = result
+			d.historyTotal = total
+		})
+	})
+}
+
+func (d *dashboard) refreshSchedules(ctx app.Context) {
+	ctx.Async(func() {
+		result, err := fetchSchedules()
 		ctx.Dispatch(func(ctx app.Context) {
 			if err != nil {
 				d.handleError(err)
 				return
 			}
-			d.dirs = result
+			d.schedules = result
 		})
 	})
 }
@@ -432,6 +1992,8 @@ func (d *dashboard) refreshSyncs(ctx app.Context, refreshDirsOnCountChange bool)
 			d.syncs = result
 			if refreshDirsOnCountChange && countChanged {
 				d.refreshDirs(ctx)
+				d.refreshHistory(ctx)
+				d.refreshSchedules(ctx)
 			}
 		})
 	})
@@ -447,11 +2009,137 @@ func (d *dashboard) schedulePoll(ctx app.Context) {
 			return
 		}
 
-		d.refreshSyncs(ctx, true)
+		if !d.streaming {
+			d.refreshSyncs(ctx, true)
+		}
 		d.schedulePoll(ctx)
 	})
 }
 
+// connectEvents subscribes to the server's sync event stream. Polling keeps
+// running underneath and takes over whenever the stream is down.
+func (d *dashboard) connectEvents(ctx app.Context) {
+	source := app.Window().Get("EventSource")
+	if !source.Truthy() {
+		return
+	}
+
+	events := source.New("/api/events")
+	onOpen := app.FuncOf(func(this app.Value, args []app.Value) any {
+		ctx.Dispatch(func(ctx app.Context) {
+			d.streaming = true
+		})
+		return nil
+	})
+	onMessage := app.FuncOf(func(this app.Value, args []app.Value) any {
+		data := args[0].Get("data").String()
+		ctx.Dispatch(func(ctx app.Context) {
+			d.applyEvent(ctx, data)
+		})
+		return nil
+	})
+	onError := app.FuncOf(func(this app.Value, args []app.Value) any {
+		closed := events.Get("readyState").Int() == eventSourceClosed
+		ctx.Dispatch(func(ctx app.Context) {
+			d.streaming = false
+			if !closed {
+				return
+			}
+
+			d.closeEvents()
+			d.refreshSyncs(ctx, true)
+			ctx.After(eventRetryInterval, func(ctx app.Context) {
+				if d.active && d.events == nil {
+					d.connectEvents(ctx)
+				}
+			})
+		})
+		return nil
+	})
+
+	events.Set("onopen", onOpen)
+	events.Set("onmessage", onMessage)
+	events.Set("onerror", onError)
+	d.events = events
+	d.listeners = []app.Func{onOpen, onMessage, onError}
+}
+
+func (d *dashboard) closeEvents() {
+	if d.events != nil {
+		d.events.Call("close")
+		d.events = nil
+	}
+	for _, listener := range d.listeners {
+		listener.Release()
+	}
+	d.listeners = nil
+	d.streaming = false
+}
+
+func (d *dashboard) applyEvent(ctx app.Context, data string) {
+	var event syncEvent
+	if err := json.Unmarshal([]byte(data), &event); err != nil {
+		d.pushError(fmt.Sprintf("invalid sync event: %s", err.Error()))
+		return
+	}
+
+	switch event.Type {
+	case "snapshot":
+		d.syncs = event.Syncs
+	case "remove":
+		if event.Sync != nil {
+			d.removeSync(event.Sync.Path)
+		}
+		d.refreshHistory(ctx)
+		d.refreshSchedules(ctx)
+		d.refreshStatus(ctx)
+	case "disk":
+		d.refreshStatus(ctx)
+	case "dirs":
+		d.refreshDirs(ctx)
+	default:
+		if event.Sync != nil {
+			d.upsertSync(*event.Sync)
+		}
+	}
+}
+
+func (d *dashboard) upsertSync(item syncItem) {
+	for i := range d.syncs {
+		if d.syncs[i].Path == item.Path {
+			d.syncs[i] = item
+			sortSyncs(d.syncs)
+			return
+		}
+	}
+	d.syncs = append(d.syncs, item)
+	sortSyncs(d.syncs)
+}
+
+func (d *dashboard) removeSync(path string) {
+	for i := range d.syncs {
+		if d.syncs[i].Path == path {
+			d.syncs = append(d.syncs[:i], d.syncs[i+1:]...)
+			return
+		}
+	}
+}
+
+// sortSyncs orders syncs the way /api/syncs does: running first, then the
+// queue in order.
+func sortSyncs(syncs []syncItem) {
+	sort.Slice(syncs, func(i, j int) bool {
+		a, b := syncs[i], syncs[j]
+		if a.State != b.State {
+			return a.State == "running"
+		}
+		if a.Position != b.Position {
+			return a.Position < b.Position
+		}
+		return a.Path > b.Path
+	})
+}
+
 func (d *dashboard) handleError(err error) {
 	if errors.Is(err, errAuthenticationRequired) {
 		redirectToLogin()
@@ -495,14 +2183,49 @@ func fetchUser() (user, error) {
 	return response.Results[0], nil
 }
 
-func fetchDirs() ([]dir, error) {
-	var response dirsResponse
-	if err := getJSON("/api/dirs", &response); err != nil {
+func fetchStatus() (*diskStatus, error) {
+	var response statusResponse
+	if err := getJSON("/api/status", &response); err != nil {
+		return nil, err
+	}
+	if len(response.Results) == 0 {
+		return nil, nil
+	}
+	return &response.Results[0], nil
+}
+
+func fetchRemotes() ([]remote, error) {
+	var response remotesResponse
+	if err := getJSON("/api/remotes", &response); err != nil {
 		return nil, err
 	}
 	return response.Results, nil
 }
 
+func fetchDirs() (dirsResponse, error) {
+	var response dirsResponse
+	if err := getJSON("/api/dirs", &response); err != nil {
+		return dirsResponse{}, err
+	}
+	return response, nil
+}
+
+func fetchTree(path string, depth int) (treeResponse, error) {
+	var response treeResponse
+	if err := getJSON(fmt.Sprintf("/api/tree?path=%s&depth=%d", url.QueryEscape(path), depth), &response); err != nil {
+		return treeResponse{}, err
+	}
+	return response, nil
+}
+
+func refreshRemoteDirs() (dirsResponse, error) {
+	var response dirsResponse
+	if err := requestJSON(http.MethodPost, "/api/dirs/refresh", nil, &response); err != nil {
+		return dirsResponse{}, err
+	}
+	return response, nil
+}
+
 func fetchSyncs() ([]syncItem, error) {
 	var response syncsResponse
 	if err := getJSON("/api/syncs", &response); err != nil {
@@ -511,6 +2234,68 @@ func fetchSyncs() ([]syncItem, error) {
 	return response.Results, nil
 }
 
+func fetchHistory() ([]historyItem, int, error) {
+	var response historyResponse
+	if err := getJSON(fmt.Sprintf("/api/history?limit=%d", historyPageSize), &response); err != nil {
+		return nil, 0, err
+	}
+	return response.Results, response.Total, nil
+}
+
+func fetchSchedules() ([]scheduleItem, error) {
+	var response schedulesResponse
+	if err := getJSON("/api/schedules", &response); err != nil {
+		return nil, err
+	}
+	return response.Results, nil
+}
+
+func fetchTokens() ([]apiToken, error) {
+	var response tokensResponse
+	if err := getJSON("/api/tokens", &response); err != nil {
+		return nil, err
+	}
+	return response.Results, nil
+}
+
+func fetchSessions() ([]session, error) {
+	var response sessionsResponse
+	if err := getJSON("/api/sessions", &response); err != nil {
+		return nil, err
+	}
+	return response.Results, nil
+}
+
+func createToken(request tokenRequest) (apiToken, error) {
+	var response tokensResponse
+	if err := requestJSON(http.MethodPost, "/api/tokens", request, &response); err != nil {
+		return apiToken{}, err
+	}
+	if len(response.Results) == 0 {
+		return apiToken{}, errors.New("empty token response")
+	}
+	return response.Results[0], nil
+}
+
+func fetchFilters() ([]filterProfile, error) {
+	var response filtersResponse
+	if err := getJSON("/api/filters", &response); err != nil {
+		return nil, err
+	}
+	return response.Results, nil
+}
+
+func fetchPreview(request syncRequest) (syncPreview, error) {
+	var response previewResponse
+	if err := requestJSON(http.MethodPost, "/api/sync/preview", request, &response); err != nil {
+		return syncPreview{}, err
+	}
+	if len(response.Results) == 0 {
+		return syncPreview{}, errors.New("empty preview response")
+	}
+	return response.Results[0], nil
+}
+
 func getJSON(url string, target any) error {
 	req, err := http.NewRequest(http.MethodGet, url, nil)
 	if err != nil {
@@ -527,16 +2312,34 @@ func getJSON(url string, target any) error {
 }
 
 func postPath(url, path string) error {
-	body, err := json.Marshal(pathRequest{Path: path})
-	if err != nil {
-		return err
+	return postJSON(url, pathRequest{Path: path})
+}
+
+func postJSON(url string, payload any) error {
+	return sendJSON(http.MethodPost, url, payload)
+}
+
+func sendJSON(method, url string, payload any) error {
+	return requestJSON(method, url, payload, nil)
+}
+
+func requestJSON(method, url string, payload any, target any) error {
+	var body []byte
+	if payload != nil {
+		var err error
+		body, err = json.Marshal(payload)
+		if err != nil {
+			return err
+		}
 	}
 
-	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	req, err := http.NewRequest(method, url, bytes.NewReader(body))
 	if err != nil {
 		return err
 	}
-	req.Header.Set("Content-Type", "application/json")
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
 
 	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
@@ -544,7 +2347,7 @@ func postPath(url, path string) error {
 	}
 	defer resp.Body.Close()
 
-	return decodeResponse(resp, nil)
+	return decodeResponse(resp, target)
 }
 
 func decodeResponse(resp *http.Response, target any) error {
@@ -560,12 +2363,22 @@ func decodeResponse(resp *http.Response, target any) error {
 		}
 
 		switch value := target.(type) {
+		case *remotesResponse:
+			payload = value.baseResponse
 		case *dirsResponse:
 			payload = value.baseResponse
 		case *syncsResponse:
 			payload = value.baseResponse
 		case *userResponse:
 			payload = value.baseResponse
+		case *historyResponse:
+			payload = value.baseResponse
+		case *schedulesResponse:
+			payload = value.baseResponse
+		case *filtersResponse:
+			payload = value.baseResponse
+		case *previewResponse:
+			payload = value.baseResponse
 		}
 	}
 
@@ -600,18 +2413,62 @@ func redirectToLogin() {
 	app.Window().Get("location").Set("href", "/auth/login?return_to="+url.QueryEscape(returnTo))
 }
 
-func syncStateClass(synced bool) string {
-	if synced {
+func syncStateClass(status string) string {
+	switch status {
+	case "synced":
+		return "state-pill synced"
+	case "partial":
+		return "state-pill partial"
+	case "outdated":
+		return "state-pill outdated"
+	}
+	return "state-pill pending"
+}
+
+func syncStateText(status string) string {
+	switch status {
+	case "synced":
+		return "synced"
+	case "partial":
+		return "partial"
+	case "outdated":
+		return "outdated"
+	}
+	return "missing"
+}
+
+func historyStateClass(status string) string {
+	switch status {
+	case "finished":
+		return "state-pill synced"
+	case "failed":
+		return "state-pill failed"
+	}
+	return "state-pill pending"
+}
+
+func previewChangeClass(change string) string {
+	switch change {
+	case "new":
 		return "state-pill synced"
+	case "deleted":
+		return "state-pill failed"
 	}
 	return "state-pill pending"
 }
 
-func syncStateText(synced bool) string {
-	if synced {
-		return "yes"
+func formatTime(value time.Time) string {
+	if value.IsZero() {
+		return "-"
+	}
+	return value.Local().Format("2006-01-02 15:04")
+}
+
+func formatDuration(value time.Duration) string {
+	if value <= 0 {
+		return "-"
 	}
-	return "no"
+	return value.Round(time.Second).String()
 }
 
 func emptyDash(v string) string {
@@ -621,6 +2478,15 @@ func emptyDash(v string) string {
 	return v
 }
 
+// formatTransferred shows the downloaded bytes of a sync against the size it
+// was expected to have.
+func formatTransferred(current syncItem) string {
+	if current.Estimate == 0 {
+		return formatIEC(uint64(current.Downloaded))
+	}
+	return formatIEC(uint64(current.Downloaded)) + " of ~" + formatIEC(current.Estimate)
+}
+
 func formatIEC(value uint64) string {
 	units := []string{"B", "KiB", "MiB", "GiB", "TiB"}
 	if value < 1024 {
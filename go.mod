@@ -4,9 +4,12 @@ go 1.23.0
 
 require (
 	github.com/docker/go-units v0.5.0
+	github.com/fsnotify/fsnotify v1.8.0
 	github.com/heetch/confita v0.10.0
 	github.com/labstack/echo/v4 v4.12.0
 	github.com/maxence-charriere/go-app/v10 v10.1.11
+	github.com/pkg/sftp v1.13.9
+	github.com/robfig/cron/v3 v3.0.1
 )
 
 require (
@@ -15,16 +18,17 @@ require (
 	github.com/golang-jwt/jwt v3.2.2+incompatible // indirect
 	github.com/google/uuid v1.6.0 // indirect
 	github.com/gorilla/securecookie v1.1.2
+	github.com/kr/fs v0.1.0 // indirect
 	github.com/labstack/gommon v0.4.2 // indirect
 	github.com/mattn/go-colorable v0.1.13 // indirect
 	github.com/mattn/go-isatty v0.0.20 // indirect
 	github.com/pkg/errors v0.8.1 // indirect
 	github.com/valyala/bytebufferpool v1.0.0 // indirect
 	github.com/valyala/fasttemplate v1.2.2 // indirect
-	golang.org/x/crypto v0.35.0 // indirect
+	golang.org/x/crypto v0.35.0
 	golang.org/x/net v0.35.0 // indirect
 	golang.org/x/oauth2 v0.24.0
-	golang.org/x/sys v0.30.0 // indirect
+	golang.org/x/sys v0.30.0
 	golang.org/x/text v0.22.0 // indirect
 	golang.org/x/time v0.5.0 // indirect
 )
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	s "sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	defaultHistoryLimit = 1000
+	defaultHistoryPage  = 50
+	maxHistoryPage      = 500
+	stderrTailLines     = 20
+)
+
+const (
+	syncStatusFinished  = "finished"
+	syncStatusFailed    = "failed"
+	syncStatusCancelled = "cancelled"
+)
+
+type HistoryEntry struct {
+	ID           string    `json:"id"`
+	Path         string    `json:"path"`
+	StartedBy    string    `json:"started_by,omitempty"`
+	QueuedAt     time.Time `json:"queued_at"`
+	StartedAt    time.Time `json:"started_at"`
+	FinishedAt   time.Time `json:"finished_at"`
+	Status       string    `json:"status"`
+	Attempts     int       `json:"attempts"`
+	Downloaded   uint      `json:"downloaded"`
+	Progress     uint      `json:"progress"`
+	ExitCode     int       `json:"exit_code"`
+	Error        string    `json:"error,omitempty"`
+	Stderr       []string  `json:"stderr,omitempty"`
+	Filter       []string  `json:"filter,omitempty"`
+	Mirror       bool      `json:"mirror,omitempty"`
+	Deleted      []string  `json:"deleted,omitempty"`
+	DeletedCount int       `json:"deleted_count,omitempty"`
+	CancelledBy  string    `json:"cancelled_by,omitempty"`
+}
+
+type historyFilter struct {
+	Path      string
+	Status    string
+	StartedBy string
+	Offset    int
+	Limit     int
+	// Access leaves out entries of paths the user cannot see.
+	Access *pathAccess
+}
+
+type historyStorage struct {
+	path    string
+	limit   int
+	entries []HistoryEntry
+	s.Mutex
+}
+
+// tailBuffer keeps the last few lines written by a command, so failures can
+// be explained after the fact without keeping the whole output around.
+type tailBuffer struct {
+	lines []string
+	size  int
+	s.Mutex
+}
+
+func newTailBuffer(size int) *tailBuffer {
+	return &tailBuffer{size: size}
+}
+
+func (t *tailBuffer) Add(line string) {
+	t.Lock()
+	defer t.Unlock()
+	t.lines = append(t.lines, line)
+	if len(t.lines) > t.size {
+		t.lines = t.lines[len(t.lines)-t.size:]
+	}
+}
+
+func (t *tailBuffer) Lines() []string {
+	t.Lock()
+	defer t.Unlock()
+	return append([]string(nil), t.lines...)
+}
+
+func newHistoryStorage(path string, limit int) (*historyStorage, error) {
+	if limit <= 0 {
+		limit = defaultHistoryLimit
+	}
+
+	history := &historyStorage{
+		path:    path,
+		limit:   limit,
+		entries: []HistoryEntry{},
+	}
+	if err := readJSONFile(path, &history.entries); err != nil {
+		return nil, fmt.Errorf("load history: %w", err)
+	}
+	return history, nil
+}
+
+func (h *historyStorage) Add(entry HistoryEntry) error {
+	h.Lock()
+	defer h.Unlock()
+
+	h.entries = append(h.entries, entry)
+	if len(h.entries) > h.limit {
+		h.entries = h.entries[len(h.entries)-h.limit:]
+	}
+	return writeJSONFile(h.path, h.entries)
+}
+
+func (h *historyStorage) Get(id string) (HistoryEntry, bool) {
+	h.Lock()
+	defer h.Unlock()
+
+	for i := len(h.entries) - 1; i >= 0; i-- {
+		if h.entries[i].ID == id {
+			return h.entries[i], true
+		}
+	}
+	return HistoryEntry{}, false
+}
+
+// LastFinished returns when the newest successful sync overlapping path
+// finished.
+func (h *historyStorage) LastFinished(path string) (time.Time, bool) {
+	h.Lock()
+	defer h.Unlock()
+
+	for i := len(h.entries) - 1; i >= 0; i-- {
+		if h.entries[i].Status == syncStatusFinished && pathsOverlap(h.entries[i].Path, path) {
+			return h.entries[i].FinishedAt, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// Query returns matching entries newest first together with the total number
+// of matches before pagination.
+func (h *historyStorage) Query(filter historyFilter) ([]HistoryEntry, int) {
+	h.Lock()
+	defer h.Unlock()
+
+	matched := make([]HistoryEntry, 0)
+	for i := len(h.entries) - 1; i >= 0; i-- {
+		entry := h.entries[i]
+		if filter.Path != "" && !pathWithin(entry.Path, filter.Path) {
+			continue
+		}
+		if !filter.Access.Allows(aclActionView, entry.Path) {
+			continue
+		}
+		if filter.Status != "" && entry.Status != filter.Status {
+			continue
+		}
+		if filter.StartedBy != "" && !strings.EqualFold(entry.StartedBy, filter.StartedBy) {
+			continue
+		}
+		matched = append(matched, entry)
+	}
+
+	total := len(matched)
+	if filter.Offset >= total {
+		return []HistoryEntry{}, total
+	}
+	end := filter.Offset + filter.Limit
+	if end > total {
+		end = total
+	}
+	return matched[filter.Offset:end], total
+}
+
+func readJSONFile(path string, target any) error {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("read file: %w", err)
+	}
+	if len(strings.TrimSpace(string(data))) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(data, target); err != nil {
+		return fmt.Errorf("decode %s: %w", path, err)
+	}
+	return nil
+}
+
+// writeJSONFile replaces the file atomically, so a crash mid-write never leaves
+// a truncated store behind.
+func writeJSONFile(path string, value any) error {
+	data, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encode %s: %w", path, err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("create dir: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return fmt.Errorf("create temp file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("write temp file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("close temp file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("rename temp file: %w", err)
+	}
+	return nil
+}
+
+func parseHistoryFilter(c echo.Context) (historyFilter, error) {
+	filter := historyFilter{
+		Path:      strings.TrimSpace(c.QueryParam("path")),
+		Status:    strings.TrimSpace(c.QueryParam("status")),
+		StartedBy: strings.TrimSpace(c.QueryParam("started_by")),
+		Limit:     defaultHistoryPage,
+		Access:    requestAccess(c),
+	}
+
+	if raw := c.QueryParam("offset"); raw != "" {
+		v, err := strconv.Atoi(raw)
+		if err != nil || v < 0 {
+			return historyFilter{}, fmt.Errorf("invalid offset %q", raw)
+		}
+		filter.Offset = v
+	}
+	if raw := c.QueryParam("limit"); raw != "" {
+		v, err := strconv.Atoi(raw)
+		if err != nil || v <= 0 {
+			return historyFilter{}, fmt.Errorf("invalid limit %q", raw)
+		}
+		filter.Limit = min(v, maxHistoryPage)
+	}
+	return filter, nil
+}
+
+func ListHistory(history *historyStorage) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		filter, err := parseHistoryFilter(c)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, Result[string]{Error: err.Error()})
+		}
+
+		entries, total := history.Query(filter)
+		return c.JSON(http.StatusOK, Result[HistoryEntry]{
+			Results: entries,
+			Total:   total,
+		})
+	}
+}
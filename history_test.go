@@ -0,0 +1,40 @@
+package main
+
+import "testing"
+
+func TestHistoryQueryPath(t *testing.T) {
+	history := &historyStorage{entries: []HistoryEntry{
+		{ID: "1", Path: "r:/data"},
+		{ID: "2", Path: "r:/data/movies"},
+		{ID: "3", Path: "r:/data2"},
+		{ID: "4", Path: "s:/data"},
+	}}
+
+	tests := []struct {
+		path string
+		want []string
+	}{
+		{path: "", want: []string{"4", "3", "2", "1"}},
+		{path: "r:/data", want: []string{"2", "1"}},
+		{path: "r:/data/movies", want: []string{"2"}},
+		{path: "r:/", want: []string{"3", "2", "1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			entries, total := history.Query(historyFilter{Path: tt.path, Limit: maxHistoryPage})
+			var got []string
+			for _, entry := range entries {
+				got = append(got, entry.ID)
+			}
+			if total != len(tt.want) || len(got) != len(tt.want) {
+				t.Fatalf("Query(%q) = %v (total %d), want %v", tt.path, got, total, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("Query(%q) = %v, want %v", tt.path, got, tt.want)
+					break
+				}
+			}
+		})
+	}
+}
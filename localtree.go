@@ -0,0 +1,344 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	s "sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// localStatsDelay batches file events before the directories they touched
+// are counted again, a running sync writes to the same few all the time.
+const localStatsDelay = 2 * time.Second
+
+// localDir is a directory of a remote's local copy.
+type localDir struct {
+	remote string
+	path   string
+}
+
+// localTree keeps the local directories of every remote in memory. It walks
+// each local path once, then follows inotify events as directories are created,
+// renamed or removed, by syncs or by anything else touching the data path. A
+// full rescan every local rescan interval heals events that were missed.
+type localTree struct {
+	logger  *slog.Logger
+	config  Config
+	remotes *remoteSet
+	events  *eventBus
+	watcher *fsnotify.Watcher
+	// roots maps each remote name to the absolute path of its local copy.
+	roots map[string]string
+	trees map[string]map[string]*Dir
+	// dirty holds directories whose files changed since they were last
+	// counted. Only Run touches it.
+	dirty map[localDir]struct{}
+	ready chan struct{}
+	s.Mutex
+}
+
+func newLocalTree(logger *slog.Logger, config Config, remotes *remoteSet, events *eventBus) (*localTree, error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, fmt.Errorf("new watcher: %w", err)
+	}
+
+	lt := &localTree{
+		logger:  logger,
+		config:  config,
+		remotes: remotes,
+		events:  events,
+		watcher: watcher,
+		roots:   map[string]string{},
+		trees:   map[string]map[string]*Dir{},
+		dirty:   map[localDir]struct{}{},
+		ready:   make(chan struct{}),
+	}
+	for _, remote := range remotes.List() {
+		root, err := filepath.Abs(remote.LocalDir(config, "/"))
+		if err != nil {
+			watcher.Close()
+			return nil, fmt.Errorf("abs path: %w", err)
+		}
+		lt.roots[remote.Name] = root
+		lt.trees[remote.Name] = map[string]*Dir{}
+	}
+	return lt, nil
+}
+
+// Run scans every local path and then applies filesystem events until ctx is
+// done.
+func (lt *localTree) Run(ctx context.Context) {
+	defer lt.watcher.Close()
+
+	syncEvents := lt.events.Subscribe(false)
+	defer lt.events.Unsubscribe(syncEvents)
+
+	ticker := time.NewTicker(lt.config.LocalRescanInterval)
+	defer ticker.Stop()
+
+	var flush <-chan time.Time
+
+	lt.RescanAll()
+	close(lt.ready)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			lt.RescanAll()
+		case <-flush:
+			flush = nil
+			for dir := range lt.dirty {
+				lt.restat(dir)
+			}
+			clear(lt.dirty)
+		case event, ok := <-lt.watcher.Events:
+			if !ok {
+				return
+			}
+			lt.apply(event)
+			if len(lt.dirty) > 0 && flush == nil {
+				flush = time.After(localStatsDelay)
+			}
+		case err, ok := <-lt.watcher.Errors:
+			if !ok {
+				return
+			}
+			// An overflowed queue means events were lost, so nothing short of a
+			// walk can tell what the tree looks like now.
+			lt.logger.Warn("local watcher failed, rescanning", slog.String("error", err.Error()))
+			lt.RescanAll()
+		case event := <-syncEvents:
+			// The local path of a remote only gets watched once it exists, which
+			// may well be thanks to the sync that just ended.
+			if (event.Type == eventFinish || event.Type == eventFailure) && event.Sync != nil {
+				if remote, _, err := lt.remotes.Resolve(event.Sync.Path); err == nil && lt.empty(remote) {
+					lt.Rescan(remote)
+				}
+			}
+		}
+	}
+}
+
+func (lt *localTree) RescanAll() {
+	for _, remote := range lt.remotes.List() {
+		lt.Rescan(remote)
+	}
+}
+
+// Rescan walks the local path of remote again and watches every directory in
+// it. On failure the current tree is kept.
+func (lt *localTree) Rescan(remote *Remote) {
+	pathMap, err := buildLocalTree(lt.config, remote)
+	if err != nil {
+		lt.logger.Error("local rescan failed", slog.String("remote", remote.Name), slog.String("error", err.Error()))
+		return
+	}
+
+	root := lt.roots[remote.Name]
+	for path := range pathMap {
+		lt.watch(filepath.Join(root, path))
+	}
+
+	lt.Lock()
+	lt.trees[remote.Name] = pathMap
+	lt.Unlock()
+}
+
+func (lt *localTree) empty(remote *Remote) bool {
+	lt.Lock()
+	defer lt.Unlock()
+	return len(lt.trees[remote.Name]) == 0
+}
+
+// watch adds an inotify watch on dir. Running out of watches is not fatal, the
+// rescan still picks up whatever changes below dir.
+func (lt *localTree) watch(dir string) {
+	if err := lt.watcher.Add(dir); err != nil && !errors.Is(err, os.ErrNotExist) {
+		lt.logger.Warn("watch local dir failed", slog.String("path", dir), slog.String("error", err.Error()))
+	}
+}
+
+// locate finds the remote whose local path holds the absolute path name and
+// returns the remote path of name.
+func (lt *localTree) locate(name string) (string, string, bool) {
+	for remoteName, root := range lt.roots {
+		if name == root {
+			return remoteName, "/", true
+		}
+		if rel, ok := strings.CutPrefix(name, root+"/"); ok {
+			return remoteName, "/" + rel, true
+		}
+	}
+	return "", "", false
+}
+
+func (lt *localTree) apply(event fsnotify.Event) {
+	remoteName, remotePath, ok := lt.locate(event.Name)
+	if !ok {
+		return
+	}
+
+	switch {
+	case event.Has(fsnotify.Create):
+		if info, err := os.Lstat(event.Name); err == nil && info.IsDir() {
+			lt.add(remoteName, remotePath)
+			return
+		}
+	case event.Has(fsnotify.Remove), event.Has(fsnotify.Rename):
+		// A renamed directory shows up again through the Create event of its
+		// new name.
+		lt.forget(remoteName, remotePath)
+	}
+	if remotePath != "/" {
+		lt.dirty[localDir{remote: remoteName, path: filepath.Dir(remotePath)}] = struct{}{}
+	}
+}
+
+// restat counts the files of dir again.
+func (lt *localTree) restat(dir localDir) {
+	entries, err := os.ReadDir(filepath.Join(lt.roots[dir.remote], dir.path))
+	if err != nil {
+		// Gone already, its Remove event takes care of it.
+		return
+	}
+	stats := &DirStats{}
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() {
+			continue
+		}
+		if info, err := entry.Info(); err == nil {
+			stats.add(info)
+		}
+	}
+
+	lt.Lock()
+	if item, ok := lt.trees[dir.remote][dir.path]; ok {
+		item.Stats = stats
+	}
+	lt.Unlock()
+}
+
+// add walks the new directory remotePath, which may already hold files and
+// directories created before its watch was in place.
+func (lt *localTree) add(remoteName, remotePath string) {
+	root := lt.roots[remoteName]
+
+	lt.Lock()
+	pathMap := lt.trees[remoteName]
+	if _, ok := pathMap[filepath.Dir(remotePath)]; !ok {
+		// Below a directory that is not tracked, the next rescan sorts it out.
+		lt.Unlock()
+		return
+	}
+	err := walkLocalTree(root, filepath.Join(root, remotePath), pathMap)
+	var dirs []string
+	collectDirs(pathMap[remotePath], &dirs)
+	lt.Unlock()
+
+	if err != nil {
+		lt.logger.Debug("walk new local dir failed", slog.String("path", remotePath), slog.String("error", err.Error()))
+	}
+	for _, dir := range dirs {
+		lt.watch(filepath.Join(root, dir))
+	}
+}
+
+// forget drops remotePath and everything below it.
+func (lt *localTree) forget(remoteName, remotePath string) {
+	lt.Lock()
+	pathMap := lt.trees[remoteName]
+	item, ok := pathMap[remotePath]
+	if !ok {
+		lt.Unlock()
+		return
+	}
+	var dirs []string
+	collectDirs(item, &dirs)
+	for _, dir := range dirs {
+		delete(pathMap, dir)
+	}
+	if item.Parent != nil {
+		delete(item.Parent.Children, item.Name)
+	}
+	lt.Unlock()
+
+	root := lt.roots[remoteName]
+	for _, dir := range dirs {
+		// The kernel drops the watch of a removed directory by itself, but a
+		// renamed one keeps it.
+		_ = lt.watcher.Remove(filepath.Join(root, dir))
+	}
+}
+
+// Forget drops remotePath of remote right away, without waiting for the
+// events of its removal.
+func (lt *localTree) Forget(remote *Remote, remotePath string) {
+	lt.forget(remote.Name, remotePath)
+}
+
+// Has reports whether remotePath has a local copy.
+func (lt *localTree) Has(remote *Remote, remotePath string) bool {
+	<-lt.ready
+
+	lt.Lock()
+	defer lt.Unlock()
+	_, ok := lt.trees[remote.Name][remotePath]
+	return ok
+}
+
+// Snapshot returns a copy of the local tree of remote that the caller is free
+// to keep and change.
+func (lt *localTree) Snapshot(remote *Remote) map[string]*Dir {
+	<-lt.ready
+
+	lt.Lock()
+	defer lt.Unlock()
+
+	pathMap := make(map[string]*Dir, len(lt.trees[remote.Name]))
+	var clone func(item *Dir, parent *Dir)
+	clone = func(item *Dir, parent *Dir) {
+		copied := &Dir{
+			Path:     item.Path,
+			Name:     item.Name,
+			Children: make(map[string]*Dir, len(item.Children)),
+			Parent:   parent,
+		}
+		if item.Stats != nil {
+			stats := *item.Stats
+			copied.Stats = &stats
+		}
+		if parent != nil {
+			parent.Children[copied.Name] = copied
+		}
+		pathMap[copied.Path] = copied
+		for _, child := range item.Children {
+			clone(child, copied)
+		}
+	}
+	for _, item := range lt.trees[remote.Name] {
+		if item.Parent == nil {
+			clone(item, nil)
+		}
+	}
+	return pathMap
+}
+
+// collectDirs appends the path of item and of all its descendants to dirs.
+func collectDirs(item *Dir, dirs *[]string) {
+	if item == nil {
+		return
+	}
+	*dirs = append(*dirs, item.Path)
+	for _, child := range item.Children {
+		collectDirs(child, dirs)
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	s "sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// backchannelLogoutEvent marks a JWT as a logout token, see OpenID Connect
+// Back-Channel Logout 1.0.
+const backchannelLogoutEvent = "http://schemas.openid.net/event/backchannel-logout"
+
+type logoutClaims struct {
+	ID        string                     `json:"jti"`
+	Subject   string                     `json:"sub"`
+	SessionID string                     `json:"sid"`
+	Events    map[string]json.RawMessage `json:"events"`
+	// Nonce must be missing, it tells ID tokens apart from logout tokens.
+	Nonce *string `json:"nonce"`
+}
+
+// seenLogoutTokens remembers the jti of the logout tokens accepted until they
+// expire, so one that was captured cannot be replayed. Only kept in memory, a
+// restart forgets them.
+type seenLogoutTokens struct {
+	expiry map[string]time.Time
+	s.Mutex
+}
+
+// Add records the token id and reports false when it was seen before.
+func (st *seenLogoutTokens) Add(id string, expiry time.Time) bool {
+	now := time.Now()
+
+	st.Lock()
+	defer st.Unlock()
+
+	if st.expiry == nil {
+		st.expiry = map[string]time.Time{}
+	}
+	for seen, seenExpiry := range st.expiry {
+		if now.After(seenExpiry) {
+			delete(st.expiry, seen)
+		}
+	}
+	if _, ok := st.expiry[id]; ok {
+		return false
+	}
+	st.expiry[id] = expiry
+	return true
+}
+
+// logoutURL sends the browser to the provider to end its session, with
+// idToken as a hint of who is leaving when there is one.
+func (a *OIDCAuth) logoutURL(idToken string) string {
+	logout := *a.endSession
+	query := logout.Query()
+	query.Set("client_id", a.oauth2.ClientID)
+	query.Set("post_logout_redirect_uri", a.postLogoutURL)
+	if idToken != "" {
+		query.Set("id_token_hint", idToken)
+	}
+	logout.RawQuery = query.Encode()
+	return logout.String()
+}
+
+// handleBackchannelLogout is called by the provider, not the browser, when a
+// user logs out elsewhere and ends the matching sessions.
+func (a *OIDCAuth) handleBackchannelLogout(c echo.Context) error {
+	c.Response().Header().Set(echo.HeaderCacheControl, "no-store")
+
+	claims, err := a.verifyLogoutToken(c.Request().Context(), c.FormValue("logout_token"))
+	if err != nil {
+		a.logger.Warn("backchannel logout rejected", slog.String("error", err.Error()))
+		return c.JSON(http.StatusBadRequest, Result[string]{Error: err.Error()})
+	}
+
+	revoked, err := a.sessions.RevokeProviderSession(claims.Subject, claims.SessionID)
+	if err != nil {
+		return err
+	}
+	a.logger.Info("backchannel logout",
+		slog.String("subject", claims.Subject),
+		slog.String("sid", claims.SessionID),
+		slog.Int("sessions", revoked),
+	)
+	return c.NoContent(http.StatusOK)
+}
+
+// verifyLogoutToken checks the signature, issuer, audience and expiry of a
+// logout token like those of an ID token, then what sets it apart.
+func (a *OIDCAuth) verifyLogoutToken(ctx context.Context, raw string) (logoutClaims, error) {
+	if raw == "" {
+		return logoutClaims{}, errors.New("missing logout token")
+	}
+	token, err := a.verifier.Verify(ctx, raw)
+	if err != nil {
+		return logoutClaims{}, fmt.Errorf("verify logout token: %w", err)
+	}
+
+	var claims logoutClaims
+	if err := token.Claims(&claims); err != nil {
+		return logoutClaims{}, fmt.Errorf("decode logout token claims: %w", err)
+	}
+	if _, ok := claims.Events[backchannelLogoutEvent]; !ok {
+		return logoutClaims{}, errors.New("logout token lacks the back-channel logout event")
+	}
+	if claims.Nonce != nil {
+		return logoutClaims{}, errors.New("logout token must not have a nonce")
+	}
+	if claims.Subject == "" && claims.SessionID == "" {
+		return logoutClaims{}, errors.New("logout token names neither a subject nor a session")
+	}
+	if claims.ID == "" {
+		return logoutClaims{}, errors.New("logout token lacks a jti")
+	}
+	if !a.logoutTokens.Add(claims.ID, token.Expiry) {
+		return logoutClaims{}, errors.New("logout token was already used")
+	}
+	return claims, nil
+}
@@ -2,11 +2,9 @@
 package main
 
 import (
-	"bufio"
 	"context"
 	"errors"
 	"fmt"
-	"github.com/docker/go-units"
 	"github.com/heetch/confita"
 	"github.com/heetch/confita/backend/env"
 	"github.com/heetch/confita/backend/flags"
@@ -20,32 +18,71 @@ import (
 	"os/exec"
 	"os/signal"
 	"path/filepath"
-	"sort"
-	"strconv"
 	"strings"
 	s "sync"
-	"syscall"
 	"time"
 )
 
 type Config struct {
-	Host              string `config:"host"`
-	Port              uint32 `config:"port"`
-	LogLevel          string `config:"log_level"`
-	DataPath          string `config:"data_path"`
-	RemoteHost        string `config:"remote_host"`
-	RemotePort        uint32 `config:"remote_port"`
-	RemoteUser        string `config:"remote_user"`
-	RsyncSSHKey       string `config:"rsync_ssh_key"`
-	LsSSHKey          string `config:"ls_ssh_key"`
-	KnownHosts        string `config:"known_hosts"`
-	OIDCIssuerURL     string `config:"oidc_issuer_url"`
-	OIDCClientID      string `config:"oidc_client_id"`
-	OIDCClientSecret  string `config:"oidc_client_secret"`
-	OIDCRedirectURL   string `config:"oidc_redirect_url"`
-	OIDCScopes        string `config:"oidc_scopes"`
-	OIDCAllowedEmails string `config:"oidc_allowed_emails"`
-	SessionSecret     string `config:"session_secret"`
+	Host                  string        `config:"host"`
+	Port                  uint32        `config:"port"`
+	LogLevel              string        `config:"log_level"`
+	DataPath              string        `config:"data_path"`
+	StatePath             string        `config:"state_path"`
+	RemoteHost            string        `config:"remote_host"`
+	RemotePort            uint32        `config:"remote_port"`
+	RemoteUser            string        `config:"remote_user"`
+	RsyncSSHKey           string        `config:"rsync_ssh_key"`
+	LsSSHKey              string        `config:"ls_ssh_key"`
+	KnownHosts            string        `config:"known_hosts"`
+	OIDCIssuerURL         string        `config:"oidc_issuer_url"`
+	OIDCClientID          string        `config:"oidc_client_id"`
+	OIDCClientSecret      string        `config:"oidc_client_secret"`
+	OIDCRedirectURL       string        `config:"oidc_redirect_url"`
+	OIDCScopes            string        `config:"oidc_scopes"`
+	OIDCAllowedEmails     string        `config:"oidc_allowed_emails"`
+	OIDCRolesClaim        string        `config:"oidc_roles_claim"`
+	OIDCEmailClaim        string        `config:"oidc_email_claim"`
+	OIDCNameClaim         string        `config:"oidc_name_claim"`
+	OIDCVerifiedEmailOnly bool          `config:"oidc_require_verified_email"`
+	OIDCAdminGroups       string        `config:"oidc_admin_groups"`
+	OIDCOperatorGroups    string        `config:"oidc_operator_groups"`
+	OIDCViewerGroups      string        `config:"oidc_viewer_groups"`
+	OIDCAdminEmails       string        `config:"oidc_admin_emails"`
+	OIDCOperatorEmails    string        `config:"oidc_operator_emails"`
+	OIDCViewerEmails      string        `config:"oidc_viewer_emails"`
+	OIDCDefaultRole       string        `config:"oidc_default_role"`
+	OIDCOfflineAccess     bool          `config:"oidc_offline_access"`
+	OIDCPostLogoutURL     string        `config:"oidc_post_logout_redirect_url"`
+	ACLFile               string        `config:"acl_file"`
+	TokensFile            string        `config:"tokens_file"`
+	SessionsFile          string        `config:"sessions_file"`
+	SessionSecret         string        `config:"session_secret"`
+	SessionIdleTimeout    time.Duration `config:"session_idle_timeout"`
+	SessionMaxLifetime    time.Duration `config:"session_max_lifetime"`
+	HistoryFile           string        `config:"history_file"`
+	HistoryLimit          int           `config:"history_limit"`
+	MaxConcurrentSyncs    int           `config:"max_concurrent_syncs"`
+	SyncMaxAttempts       int           `config:"sync_max_attempts"`
+	SyncRetryDelay        time.Duration `config:"sync_retry_delay"`
+	SyncRetryMaxDelay     time.Duration `config:"sync_retry_max_delay"`
+	SchedulesFile         string        `config:"schedules_file"`
+	RsyncBwLimit          string        `config:"rsync_bwlimit"`
+	RsyncBwLimitWindows   string        `config:"rsync_bwlimit_windows"`
+	FiltersFile           string        `config:"filters_file"`
+	MirrorMaxDeletions    int           `config:"mirror_max_deletions"`
+	MirrorMaxDeletedBytes string        `config:"mirror_max_deleted_bytes"`
+	TransferBackend       string        `config:"transfer_backend"`
+	RemotesFile           string        `config:"remotes_file"`
+	LsMode                string        `config:"ls_mode"`
+	LsMaxDepth            int           `config:"ls_max_depth"`
+	DirsRefreshInterval   time.Duration `config:"dirs_refresh_interval"`
+	LocalRescanInterval   time.Duration `config:"local_rescan_interval"`
+	DiskReserve           string        `config:"disk_reserve"`
+	DiskCheckInterval     time.Duration `config:"disk_check_interval"`
+	RetentionFile         string        `config:"retention_file"`
+	RetentionAuditFile    string        `config:"retention_audit_file"`
+	RetentionInterval     time.Duration `config:"retention_interval"`
 }
 
 type Dir struct {
@@ -53,35 +90,101 @@ type Dir struct {
 	Name     string
 	Children map[string]*Dir
 	Parent   *Dir
-	Synced   bool
+	// Stats covers the files directly in the directory. It is nil when the
+	// listing did not look inside.
+	Stats       *DirStats
+	Status      string
+	LocalBytes  uint64
+	RemoteBytes uint64
 }
 
 type Sync struct {
-	Path       string
-	Progress   uint
-	Speed      uint
-	Downloaded uint
-	TimeLeft   string
-	Context    context.Context
-	Cancel     context.CancelFunc
+	ID             string
+	Path           string
+	Remote         *Remote
+	RemotePath     string
+	State          string
+	StartedBy      string
+	QueuedAt       time.Time
+	StartedAt      time.Time
+	CancelledBy    string
+	Attempt        int
+	NextRetry      time.Time
+	BwLimit        uint
+	AppliedBwLimit uint
+	FilterProfile  string
+	Filter         []string
+	Mirror         bool
+	Deleted        []string
+	DeletedCount   int
+	Progress       uint
+	Speed          uint
+	Downloaded     uint
+	TimeLeft       string
+	Estimate       uint64
+	Paused         bool
+	Stderr         *tailBuffer
+	Context        context.Context
+	Cancel         context.CancelFunc
+	Restart        context.CancelFunc
+	// publishedPosition is the queue position subscribers last heard of.
+	publishedPosition int
 }
 
 type Result[T any] struct {
-	Results []T    `json:"results,omitempty"`
-	Error   string `json:"error,omitempty"`
+	Results     []T        `json:"results,omitempty"`
+	Total       int        `json:"total,omitempty"`
+	GeneratedAt *time.Time `json:"generated_at,omitempty"`
+	Stale       bool       `json:"stale,omitempty"`
+	Partial     bool       `json:"partial,omitempty"`
+	Error       string     `json:"error,omitempty"`
 }
 
 type DirResult struct {
-	Path   string `json:"path"`
-	Synced bool   `json:"synced"`
+	Remote      string `json:"remote"`
+	Path        string `json:"path"`
+	Synced      bool   `json:"synced"`
+	Status      string `json:"status"`
+	LocalBytes  uint64 `json:"local_bytes"`
+	RemoteBytes uint64 `json:"remote_bytes"`
+	// Actions lists what the user may do on the directory.
+	Actions []string `json:"actions"`
+}
+
+// TreeNode is a directory in the tree view. Children is only filled in down to
+// the requested depth, HasChildren tells whether there is more to load.
+type TreeNode struct {
+	Remote      string     `json:"remote"`
+	Path        string     `json:"path"`
+	Name        string     `json:"name"`
+	Status      string     `json:"status"`
+	LocalBytes  uint64     `json:"local_bytes"`
+	RemoteBytes uint64     `json:"remote_bytes"`
+	HasChildren bool       `json:"has_children"`
+	Actions     []string   `json:"actions"`
+	Children    []TreeNode `json:"children,omitempty"`
 }
 
 type SyncResult struct {
-	Path       string `json:"path"`
-	Progress   uint   `json:"progress"`
-	Speed      uint   `json:"speed"`
-	Downloaded uint   `json:"downloaded"`
-	TimeLeft   string `json:"time_left"`
+	ID             string     `json:"id"`
+	Path           string     `json:"path"`
+	Remote         string     `json:"remote"`
+	State          string     `json:"state"`
+	Position       int        `json:"position,omitempty"`
+	Attempt        int        `json:"attempt,omitempty"`
+	MaxAttempts    int        `json:"max_attempts"`
+	NextRetry      *time.Time `json:"next_retry,omitempty"`
+	BwLimit        uint       `json:"bwlimit,omitempty"`
+	AppliedBwLimit uint       `json:"applied_bwlimit,omitempty"`
+	FilterProfile  string     `json:"filter_profile,omitempty"`
+	Filter         []string   `json:"filter,omitempty"`
+	Mirror         bool       `json:"mirror,omitempty"`
+	Progress       uint       `json:"progress"`
+	Speed          uint       `json:"speed"`
+	Downloaded     uint       `json:"downloaded"`
+	TimeLeft       string     `json:"time_left"`
+	Estimate       uint64     `json:"estimate,omitempty"`
+	Paused         bool       `json:"paused,omitempty"`
 }
 
 type PathRequest struct {
@@ -90,96 +193,125 @@ type PathRequest struct {
 
 type RemoveRequest PathRequest
 
-type SyncRequest PathRequest
+type SyncRequest struct {
+	Path    string   `json:"path"`
+	BwLimit string   `json:"bwlimit"`
+	Profile string   `json:"profile"`
+	Include []string `json:"include"`
+	Exclude []string `json:"exclude"`
+	Mirror  bool     `json:"mirror"`
+}
 
-type CancelSyncRequest SyncRequest
+// syncOptions tune how a single sync runs on top of the global config.
+type syncOptions struct {
+	BwLimit       uint
+	FilterProfile string
+	Filter        []string
+	Mirror        bool
+	Estimate      uint64
+}
+
+type CancelSyncRequest PathRequest
 
 type syncStorage struct {
-	Data map[string]*Sync
+	Data    map[string]*Sync
+	Queue   []*Sync
+	Events  *eventBus
+	Remotes *remoteSet
+	Disk    *diskMonitor
 	s.Mutex
 }
 
-func buildLocalTree(config Config) (map[string]*Dir, error) {
+func buildLocalTree(config Config, remote *Remote) (map[string]*Dir, error) {
 	pathMap := map[string]*Dir{}
 
-	dir, err := filepath.Abs(config.DataPath)
+	dir, err := filepath.Abs(remote.LocalDir(config, "/"))
 	if err != nil {
 		return nil, fmt.Errorf("abs path: %w", err)
 	}
+	if _, err := os.Stat(dir); errors.Is(err, os.ErrNotExist) {
+		// Nothing has been synced from this remote yet.
+		return pathMap, nil
+	}
+
+	if err := walkLocalTree(dir, dir, pathMap); err != nil {
+		return nil, err
+	}
+	return pathMap, nil
+}
 
-	err = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+// walkLocalTree adds the directories from start down to pathMap, keyed by their
+// path relative to root, and counts the files in each. Directories already in
+// pathMap are kept, only their stats are counted again.
+func walkLocalTree(root, start string, pathMap map[string]*Dir) error {
+	err := filepath.WalkDir(start, func(path string, d fs.DirEntry, err error) error {
 		if err != nil {
 			return fmt.Errorf("walk dir item: %w", err)
 		}
-		if d.IsDir() {
-			path = strings.TrimPrefix(path, dir)
-
-			if !strings.HasPrefix(path, "/") {
-				path = "/" + path
-			}
+		path = strings.TrimPrefix(path, root)
+		if !strings.HasPrefix(path, "/") {
+			path = "/" + path
+		}
 
-			parent := pathMap[filepath.Dir(path)]
-			item := Dir{
-				Path:     path,
-				Name:     d.Name(),
-				Children: map[string]*Dir{},
-				Parent:   parent,
-				Synced:   false,
+		if !d.IsDir() {
+			parent, ok := pathMap[filepath.Dir(path)]
+			if !ok || !d.Type().IsRegular() {
+				return nil
 			}
-			if parent != nil {
-				parent.Children[item.Name] = &item
+			info, err := d.Info()
+			if errors.Is(err, os.ErrNotExist) {
+				return nil
+			} else if err != nil {
+				return fmt.Errorf("stat file: %w", err)
 			}
-			pathMap[item.Path] = &item
+			parent.Stats.add(info)
+			return nil
+		}
+
+		if item, ok := pathMap[path]; ok {
+			item.Stats = &DirStats{}
+			return nil
+		}
+
+		parent := pathMap[filepath.Dir(path)]
+		item := Dir{
+			Path:     path,
+			Name:     d.Name(),
+			Children: map[string]*Dir{},
+			Parent:   parent,
+			Stats:    &DirStats{},
+		}
+		if parent != nil {
+			parent.Children[item.Name] = &item
 		}
+		pathMap[item.Path] = &item
 		return nil
 	})
 	if err != nil {
-		return nil, fmt.Errorf("walk dir: %w", err)
+		return fmt.Errorf("walk dir: %w", err)
 	}
-	return pathMap, nil
+	return nil
 }
 
-func buildRemoteTree(logger *slog.Logger, ctx context.Context, config Config, localPathMap map[string]*Dir) (map[string]*Dir, error) {
-	pathMap := map[string]*Dir{}
-
-	cmd := exec.CommandContext(ctx, "ssh", "-T", "-p", fmt.Sprintf("%d", config.RemotePort), "-o", fmt.Sprintf("UserKnownHostsFile=%s", config.KnownHosts), "-o", "StrictHostKeyChecking=yes", "-o", "PasswordAuthentication=no", "-i", config.LsSSHKey, fmt.Sprintf("%s@%s", config.RemoteUser, config.RemoteHost))
-
-	logger.Debug("ls cmd", slog.Any("args", cmd.Args))
-
-	stdout, err := cmd.StdoutPipe()
-	if err != nil {
-		return nil, fmt.Errorf("get stdout pipe: %w", err)
-	}
+// buildRemoteTree lists remote and compares it with its local copy. partial
+// tells whether some directories could not be read.
+func buildRemoteTree(logger *slog.Logger, ctx context.Context, remote *Remote, localPathMap map[string]*Dir) (pathMap map[string]*Dir, partial bool, err error) {
+	pathMap = map[string]*Dir{}
 
-	stderr, err := cmd.StderrPipe()
+	listed, err := remote.lister.List(logger, ctx)
 	if err != nil {
-		return nil, fmt.Errorf("get stdout pipe: %w", err)
+		return nil, false, fmt.Errorf("list dirs: %w", err)
 	}
 
-	go func() {
-		scanner := bufio.NewScanner(stderr)
-		for scanner.Scan() {
-			logger.Info(scanner.Text())
-		}
-	}()
-
-	scanner := bufio.NewScanner(stdout)
-	err = cmd.Start()
-
-	if err != nil {
-		return nil, fmt.Errorf("start command: %w", err)
-	}
-
-	for scanner.Scan() {
-		path := scanner.Text()
-
-		parent := pathMap[filepath.Dir(path)]
+	for _, dir := range listed {
+		partial = partial || dir.Unreadable
+		parent := pathMap[filepath.Dir(dir.Path)]
 		item := Dir{
-			Path:     path,
-			Name:     filepath.Base(path),
+			Path:     dir.Path,
+			Name:     filepath.Base(dir.Path),
 			Children: map[string]*Dir{},
 			Parent:   parent,
-			Synced:   true,
+			Stats:    dir.Stats,
 		}
 		if parent != nil {
 			parent.Children[item.Name] = &item
@@ -187,159 +319,202 @@ func buildRemoteTree(logger *slog.Logger, ctx context.Context, config Config, lo
 		pathMap[item.Path] = &item
 	}
 
-	err = cmd.Wait()
-	if err != nil {
-		return nil, fmt.Errorf("wait command: %w", err)
-	}
+	markStatus(pathMap, localPathMap)
 
-	var setNotSynced func(*Dir)
-	setNotSynced = func(item *Dir) {
-		item.Synced = false
-		if item.Parent != nil {
-			setNotSynced(item.Parent)
-		}
+	return pathMap, partial, nil
+}
+
+// newHistoryEntry describes how currentSync ended. Must be called with the sync
+// storage locked.
+func newHistoryEntry(currentSync *Sync, runErr error) HistoryEntry {
+	entry := HistoryEntry{
+		ID:           currentSync.ID,
+		Path:         currentSync.Path,
+		StartedBy:    currentSync.StartedBy,
+		QueuedAt:     currentSync.QueuedAt,
+		StartedAt:    currentSync.StartedAt,
+		FinishedAt:   time.Now(),
+		Status:       syncStatusFinished,
+		Attempts:     currentSync.Attempt,
+		Downloaded:   currentSync.Downloaded,
+		Progress:     currentSync.Progress,
+		Stderr:       currentSync.Stderr.Lines(),
+		Filter:       currentSync.Filter,
+		Mirror:       currentSync.Mirror,
+		Deleted:      currentSync.Deleted,
+		DeletedCount: currentSync.DeletedCount,
+		CancelledBy:  currentSync.CancelledBy,
 	}
 
-	for path, item := range pathMap {
-		if _, ok := localPathMap[path]; !ok {
-			setNotSynced(item)
+	if runErr != nil {
+		entry.Status = syncStatusFailed
+		entry.Error = runErr.Error()
+		entry.ExitCode = -1
+		var exitErr *exec.ExitError
+		if errors.As(runErr, &exitErr) {
+			entry.ExitCode = exitErr.ExitCode()
 		}
 	}
+	if currentSync.Context.Err() != nil {
+		entry.Status = syncStatusCancelled
+	}
+	return entry
+}
 
-	return pathMap, nil
+func recordSync(logger *slog.Logger, history *historyStorage, entry HistoryEntry) {
+	if err := history.Add(entry); err != nil {
+		logger.Error("record sync history", slog.String("path", entry.Path), slog.String("error", err.Error()))
+	}
 }
 
-func startSync(logger *slog.Logger, ctx context.Context, config Config, runningSyncs *syncStorage, currentSync *Sync) {
+func startSync(logger *slog.Logger, ctx context.Context, config Config, runningSyncs *syncStorage, history *historyStorage, currentSync *Sync) {
+	var runErr error
 	defer func() {
 		runningSyncs.Lock()
-		defer runningSyncs.Unlock()
+		entry := newHistoryEntry(currentSync, runErr)
+		eventType := eventFinish
+		if entry.Status != syncStatusFinished {
+			eventType = eventFailure
+		}
+		runningSyncs.publish(Event{Type: eventType, Sync: runningSyncs.resultPtr(currentSync, config), Result: &entry})
 		delete(runningSyncs.Data, currentSync.Path)
-	}()
+		runningSyncs.publish(Event{Type: eventRemove, Sync: runningSyncs.resultPtr(currentSync, config)})
+		runningSyncs.promote(logger, config, history)
+		runningSyncs.Unlock()
 
-	ctx, cancel := context.WithCancel(ctx)
+		recordSync(logger, history, entry)
+	}()
 
-	syncPath, _ := filepath.Split(filepath.Join(config.DataPath, currentSync.Path))
+	syncPath, _ := filepath.Split(currentSync.Remote.LocalDir(config, currentSync.RemotePath))
 	err := os.MkdirAll(syncPath, 0755)
 	if err != nil {
 		logger.Error("create path failed", slog.String("error", err.Error()))
-		cancel()
+		runErr = fmt.Errorf("create path: %w", err)
 		return
 	}
 
-	cmd := exec.CommandContext(ctx, "rsync", "-a", "--info=progress2", "-e", fmt.Sprintf("ssh -i %s -p %d -o UserKnownHostsFile=%s -o StrictHostKeyChecking=yes -o PasswordAuthentication=no", config.RsyncSSHKey, config.RemotePort, config.KnownHosts), fmt.Sprintf("%s@%s:%s", config.RemoteUser, config.RemoteHost, filepath.Join(currentSync.Path)), syncPath)
+	if currentSync.Mirror {
+		runErr = checkMirror(logger, ctx, config, currentSync)
+		if runErr != nil {
+			logger.Warn("mirror sync aborted", slog.String("path", currentSync.Path), slog.String("error", runErr.Error()))
+			return
+		}
+	}
 
-	logger.Debug("rsync cmd", slog.Any("args", cmd.Args))
+	for attempt := 1; ; attempt++ {
+		if runErr = runningSyncs.waitForSpace(logger, ctx, config, currentSync); runErr != nil {
+			return
+		}
 
-	go func() {
-		<-currentSync.Context.Done()
+		attemptCtx, restart := context.WithCancel(ctx)
+		runningSyncs.Lock()
+		currentSync.Attempt = attempt
+		currentSync.NextRetry = time.Time{}
+		currentSync.AppliedBwLimit = bandwidthLimit(config, currentSync, time.Now())
+		currentSync.Restart = restart
+		bwLimit := currentSync.AppliedBwLimit
+		runningSyncs.publish(Event{Type: eventProgress, Sync: runningSyncs.resultPtr(currentSync, config)})
+		runningSyncs.Unlock()
+
+		runErr = runTransfer(logger, attemptCtx, config, runningSyncs, currentSync, syncPath, bwLimit)
+		restarted := attemptCtx.Err() != nil && ctx.Err() == nil
+		restart()
+		if runErr != nil && restarted {
+			// Changed bandwidth limits and low disk space both stop the
+			// attempt, the next one resumes once it may go on.
+			logger.Info("sync restarted", slog.String("path", currentSync.Path), slog.Int("attempt", attempt))
+			attempt--
+			continue
+		}
+		if runErr == nil || ctx.Err() != nil {
+			return
+		}
+		if attempt >= config.SyncMaxAttempts || !retryableSyncError(runErr) {
+			return
+		}
 
-		if cmd.Process.Pid != -1 {
-			err := cmd.Process.Signal(syscall.SIGTERM)
-			if err != nil {
-				logger.Error("terminate err", slog.String("error", err.Error()))
-			}
-			time.Sleep(time.Second * 5)
-			cancel()
+		delay := retryDelay(config, attempt)
+		runningSyncs.Lock()
+		currentSync.NextRetry = time.Now().Add(delay)
+		runningSyncs.publish(Event{Type: eventProgress, Sync: runningSyncs.resultPtr(currentSync, config)})
+		runningSyncs.Unlock()
+
+		logger.Warn("sync failed, retrying",
+			slog.String("path", currentSync.Path),
+			slog.Int("attempt", attempt),
+			slog.Duration("delay", delay),
+			slog.String("error", runErr.Error()),
+		)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
 		}
-	}()
+	}
+}
 
-	stderr, err := cmd.StderrPipe()
+// sync queues a transfer of the "remote:/path" address and starts it right
+// away when nothing blocks it. It returns the state of the new sync, or
+// errSyncExists when the same path is already queued or running.
+func sync(logger *slog.Logger, ctx context.Context, config Config, runningSyncs *syncStorage, history *historyStorage, path string, startedBy string, options syncOptions) (SyncResult, error) {
+	id, err := randomToken(9)
 	if err != nil {
-		logger.Error("stderr pipe", slog.String("error", err.Error()))
-		return
+		return SyncResult{}, fmt.Errorf("generate sync id: %w", err)
 	}
 
-	go func() {
-		scanner := bufio.NewScanner(stderr)
-		for scanner.Scan() {
-			logger.Info(scanner.Text())
-		}
-	}()
-
-	stdout, err := cmd.StdoutPipe()
+	remote, remotePath, err := runningSyncs.Remotes.Resolve(path)
 	if err != nil {
-		logger.Error("get stdout pipe", slog.String("error", err.Error()))
-		return
+		return SyncResult{}, err
 	}
+	path = remote.Address(remotePath)
 
-	go func() {
-		scanner := bufio.NewScanner(stdout)
-		scanner.Split(bufio.ScanWords)
-		for scanner.Scan() {
-			text := scanner.Text()
-			if strings.HasSuffix(text, "%") {
-				v, err := strconv.Atoi(strings.TrimSuffix(text, "%"))
-				if err != nil {
-					logger.Error("failed parse string", slog.String("value", text), slog.String("error", err.Error()))
-				} else {
-					currentSync.Progress = uint(v)
-				}
-				continue
-			}
-			if strings.HasSuffix(text, "/s") {
-				v, err := units.FromHumanSize(strings.TrimSuffix(text, "/s"))
-				if err != nil {
-					logger.Error("failed parse string", slog.String("value", text), slog.String("error", err.Error()))
-				} else {
-					currentSync.Speed = uint(v)
-				}
-				continue
-			}
-			if strings.Contains(text, ":") {
-				currentSync.TimeLeft = text
-				continue
-			}
-			v, err := strconv.Atoi(strings.ReplaceAll(text, ",", ""))
-			if err != nil {
-				logger.Error("failed parse string", slog.String("value", text), slog.String("error", err.Error()))
-			} else {
-				currentSync.Downloaded = uint(v)
-			}
-		}
-	}()
+	runningSyncs.Lock()
+	defer runningSyncs.Unlock()
 
-	err = cmd.Run()
-	if err != nil {
-		logger.Error("wait command", slog.String("error", err.Error()))
+	if _, ok := runningSyncs.Data[path]; ok {
+		return SyncResult{}, errSyncExists
 	}
-}
 
-func sync(logger *slog.Logger, ctx context.Context, config Config, runningSyncs *syncStorage, path string) bool {
 	ctx, cancel := context.WithCancel(ctx)
 
 	newSync := &Sync{
-		Path:     path,
-		Progress: 0,
-		Speed:    0,
-		Context:  ctx,
-		Cancel:   cancel,
+		ID:            id,
+		Path:          path,
+		Remote:        remote,
+		RemotePath:    remotePath,
+		State:         syncStateQueued,
+		StartedBy:     startedBy,
+		QueuedAt:      time.Now(),
+		Progress:      0,
+		Speed:         0,
+		BwLimit:       options.BwLimit,
+		FilterProfile: options.FilterProfile,
+		Filter:        options.Filter,
+		Mirror:        options.Mirror,
+		Estimate:      options.Estimate,
+		Stderr:        newTailBuffer(stderrTailLines),
+		Context:       ctx,
+		Cancel:        cancel,
 	}
 
-	runningSyncs.Lock()
-	defer runningSyncs.Unlock()
-
-	for _, value := range runningSyncs.Data {
-		if value.Path == path || strings.HasPrefix(value.Path, path) || strings.HasPrefix(path, value.Path) {
-			return false
-		}
-	}
 	runningSyncs.Data[path] = newSync
+	runningSyncs.Queue = append(runningSyncs.Queue, newSync)
+	runningSyncs.promote(logger, config, history)
 
-	go startSync(logger, ctx, config, runningSyncs, newSync)
-
-	return true
+	return runningSyncs.result(newSync, config), nil
 }
 
-func remove(config Config, runningSyncs *syncStorage, path string) (bool, error) {
+func remove(config Config, runningSyncs *syncStorage, remote *Remote, remotePath string) (bool, error) {
 	runningSyncs.Lock()
 	defer runningSyncs.Unlock()
 
 	for _, value := range runningSyncs.Data {
-		if value.Path == path || strings.HasPrefix(value.Path, path) || strings.HasPrefix(path, value.Path) {
+		if pathsOverlap(value.Path, remote.Address(remotePath)) {
 			return false, nil
 		}
 	}
-	err := os.RemoveAll(filepath.Join(config.DataPath, path))
+	err := os.RemoveAll(remote.LocalDir(config, remotePath))
 	if err != nil {
 		return false, fmt.Errorf("remove all: %w", err)
 	}
@@ -347,65 +522,54 @@ func remove(config Config, runningSyncs *syncStorage, path string) (bool, error)
 	return true, nil
 }
 
-func ListDirs(logger *slog.Logger, ctx context.Context, config Config) echo.HandlerFunc {
+func ListSyncs(config Config, runningSyncs *syncStorage) echo.HandlerFunc {
 	return func(c echo.Context) error {
-		localPathMap, err := buildLocalTree(config)
-		if err != nil {
-			return fmt.Errorf("list local: %w", err)
-		}
-		pathMap, err := buildRemoteTree(logger, ctx, config, localPathMap)
-		if err != nil {
-			return fmt.Errorf("list remote: %w", err)
-		}
-
-		keys := make([]string, 0, len(pathMap))
-
-		for k := range pathMap {
-			keys = append(keys, k)
-		}
-		sort.Strings(keys)
-
-		result := Result[DirResult]{
+		result := Result[SyncResult]{
 			Error:   "",
-			Results: make([]DirResult, 0),
+			Results: make([]SyncResult, 0),
 		}
 
-		for _, k := range keys {
-			result.Results = append(result.Results, DirResult{Path: pathMap[k].Path, Synced: pathMap[k].Synced})
-		}
+		runningSyncs.Lock()
+		result.Results = requestAccess(c).syncs(runningSyncs.snapshot(config))
+		runningSyncs.Unlock()
 
 		return c.JSON(http.StatusOK, result)
 	}
 }
 
-func ListSyncs(runningSyncs *syncStorage) echo.HandlerFunc {
-	return func(c echo.Context) error {
-		result := Result[SyncResult]{
-			Error:   "",
-			Results: make([]SyncResult, 0),
-		}
+// errInvalidPath stands for paths that do not exist as well as for those the
+// user cannot see.
+var errInvalidPath = errors.New("invalid path")
+
+// checkSync runs the checks a sync of remotePath goes through before it starts,
+// whoever starts it: access may sync it, it is a directory on remote and what
+// it is expected to download fits on disk. It returns that estimate.
+func checkSync(ctx context.Context, runningSyncs *syncStorage, dirs *dirCache, access *pathAccess, remote *Remote, remotePath string) (uint64, error) {
+	if ok, err := access.authorize(aclActionSync, remote.Address(remotePath)); err != nil {
+		return 0, err
+	} else if !ok {
+		return 0, errInvalidPath
+	}
 
-		runningSyncs.Lock()
-		defer runningSyncs.Unlock()
-		for _, value := range runningSyncs.Data {
-			result.Results = append(result.Results, SyncResult{
-				Path:       value.Path,
-				Progress:   value.Progress,
-				Speed:      value.Speed,
-				Downloaded: value.Downloaded,
-				TimeLeft:   value.TimeLeft,
-			})
-		}
-
-		sort.Slice(result.Results, func(i, j int) bool {
-			return result.Results[i].Path > result.Results[j].Path
-		})
+	if ok, err := dirs.HasRemotePath(ctx, remote, remotePath); err != nil {
+		return 0, fmt.Errorf("list remote: %w", err)
+	} else if !ok {
+		return 0, errInvalidPath
+	}
 
-		return c.JSON(http.StatusOK, result)
+	estimate, err := dirs.Estimate(ctx, remote, remotePath)
+	if err != nil {
+		return 0, fmt.Errorf("estimate size: %w", err)
 	}
+	if err := runningSyncs.checkSpace(remote, estimate); errors.Is(err, errInsufficientSpace) {
+		return 0, err
+	} else if err != nil {
+		return 0, fmt.Errorf("check space: %w", err)
+	}
+	return estimate, nil
 }
 
-func StartSync(logger *slog.Logger, ctx context.Context, config Config, runningSyncs *syncStorage) echo.HandlerFunc {
+func StartSync(logger *slog.Logger, ctx context.Context, config Config, runningSyncs *syncStorage, history *historyStorage, filters *filterStorage, dirs *dirCache) echo.HandlerFunc {
 	return func(c echo.Context) error {
 		request := &SyncRequest{}
 
@@ -414,20 +578,47 @@ func StartSync(logger *slog.Logger, ctx context.Context, config Config, runningS
 			return fmt.Errorf("load request: %w", err)
 		}
 
-		if remotePath, err := buildRemoteTree(logger, ctx, config, map[string]*Dir{}); err != nil {
-			return fmt.Errorf("list remote: %w", err)
-		} else if _, ok := remotePath[request.Path]; !ok {
-			return c.JSON(http.StatusBadRequest, Result[string]{Error: "invalid path"})
+		bwLimit, err := parseBandwidthLimit(request.BwLimit)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, Result[string]{Error: err.Error()})
 		}
 
-		if sync(logger, ctx, config, runningSyncs, request.Path) {
-			return c.JSON(http.StatusOK, Result[string]{})
+		filter, err := filters.Rules(request.Profile, request.Include, request.Exclude)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, Result[string]{Error: err.Error()})
+		}
+
+		remote, remotePath, err := runningSyncs.Remotes.Resolve(request.Path)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, Result[string]{Error: err.Error()})
+		}
+
+		estimate, err := checkSync(ctx, runningSyncs, dirs, requestAccess(c), remote, remotePath)
+		if errors.Is(err, errInvalidPath) {
+			return c.JSON(http.StatusBadRequest, Result[string]{Error: err.Error()})
+		} else if errors.Is(err, errInsufficientSpace) {
+			return c.JSON(http.StatusInsufficientStorage, Result[string]{Error: err.Error()})
+		} else if err != nil {
+			return err
 		}
-		return c.JSON(http.StatusConflict, Result[string]{Error: "sync already started"})
+
+		started, err := sync(logger, ctx, config, runningSyncs, history, request.Path, requestActor(c), syncOptions{
+			BwLimit:       bwLimit,
+			FilterProfile: request.Profile,
+			Filter:        filter,
+			Mirror:        request.Mirror,
+			Estimate:      estimate,
+		})
+		if errors.Is(err, errSyncExists) {
+			return c.JSON(http.StatusConflict, Result[string]{Error: "sync already started"})
+		} else if err != nil {
+			return fmt.Errorf("start sync: %w", err)
+		}
+		return c.JSON(http.StatusOK, Result[SyncResult]{Results: []SyncResult{started}})
 	}
 }
 
-func CancelSync(runningSyncs *syncStorage) echo.HandlerFunc {
+func CancelSync(logger *slog.Logger, config Config, runningSyncs *syncStorage, history *historyStorage) echo.HandlerFunc {
 	return func(c echo.Context) error {
 		request := &CancelSyncRequest{}
 
@@ -436,16 +627,36 @@ func CancelSync(runningSyncs *syncStorage) echo.HandlerFunc {
 			return fmt.Errorf("load request: %w", err)
 		}
 
+		path, err := runningSyncs.Remotes.Canonical(request.Path)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, Result[string]{Error: err.Error()})
+		}
+
+		// Syncs the user cannot see are left alone like ones that do not exist.
+		if ok, err := requestAccess(c).authorize(aclActionCancel, path); err != nil {
+			return err
+		} else if !ok {
+			return c.JSON(http.StatusOK, Result[string]{})
+		}
+
 		runningSyncs.Lock()
-		defer runningSyncs.Unlock()
-		if currentSync, ok := runningSyncs.Data[request.Path]; ok {
+		var dropped *HistoryEntry
+		if currentSync, ok := runningSyncs.Data[path]; ok && currentSync.State == syncStateQueued {
+			dropped = runningSyncs.drop(logger, config, history, path, requestActor(c))
+		} else if ok {
+			currentSync.CancelledBy = requestActor(c)
 			currentSync.Cancel()
 		}
+		runningSyncs.Unlock()
+
+		if dropped != nil {
+			recordSync(logger, history, *dropped)
+		}
 		return c.JSON(http.StatusOK, Result[string]{})
 	}
 }
 
-func Remove(config Config, runningSyncs *syncStorage) echo.HandlerFunc {
+func Remove(config Config, runningSyncs *syncStorage, local *localTree, dirs *dirCache) echo.HandlerFunc {
 	return func(c echo.Context) error {
 		request := &RemoveRequest{}
 
@@ -454,15 +665,22 @@ func Remove(config Config, runningSyncs *syncStorage) echo.HandlerFunc {
 			return fmt.Errorf("load request: %w", err)
 		}
 
-		if remotePath, err := buildLocalTree(config); err != nil {
-			return fmt.Errorf("list local: %w", err)
-		} else if _, ok := remotePath[request.Path]; !ok {
+		remote, remotePath, err := runningSyncs.Remotes.Resolve(request.Path)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, Result[string]{Error: err.Error()})
+		}
+
+		if ok, err := requestAccess(c).authorize(aclActionRemove, remote.Address(remotePath)); err != nil {
+			return err
+		} else if !ok || !local.Has(remote, remotePath) {
 			return c.JSON(http.StatusBadRequest, Result[string]{Error: "invalid path"})
 		}
 
-		if ok, err := remove(config, runningSyncs, request.Path); err != nil {
+		if ok, err := remove(config, runningSyncs, remote, remotePath); err != nil {
 			return fmt.Errorf("remove path: %w", err)
 		} else if ok {
+			local.Forget(remote, remotePath)
+			dirs.Invalidate(remote)
 			return c.JSON(http.StatusOK, Result[string]{})
 		}
 
@@ -490,6 +708,8 @@ func customHTTPErrorHandler(err error, c echo.Context) {
 	var he *echo.HTTPError
 	if errors.As(err, &he) {
 		code = he.Code
+	} else if errors.Is(err, errForbidden) {
+		code = http.StatusForbidden
 	}
 	if err := c.JSON(code, Result[string]{
 		Error: err.Error(),
@@ -503,23 +723,62 @@ func testConfig(config Config) error {
 	if config.DataPath == "" {
 		errs = append(errs, errors.New("data path must be specified"))
 	}
-	if config.RemoteHost == "" {
-		errs = append(errs, errors.New("remote host must be specified"))
+	// With a remotes file every remote is checked when the file is loaded.
+	if config.RemotesFile == "" {
+		if config.RemoteHost == "" {
+			errs = append(errs, errors.New("remote host must be specified"))
+		}
+		if config.RemoteUser == "" {
+			errs = append(errs, errors.New("remote user must be specified"))
+		}
+		if config.RemotePort == 0 {
+			errs = append(errs, errors.New("remote port must be specified"))
+		}
+		if config.RsyncSSHKey == "" {
+			errs = append(errs, errors.New("rsync ssh key file must be specified"))
+		}
+		if config.LsSSHKey == "" {
+			errs = append(errs, errors.New("ls ssh key file must be specified"))
+		}
+		if config.KnownHosts == "" {
+			errs = append(errs, errors.New("known host file must be specified"))
+		}
+	}
+	if config.MaxConcurrentSyncs < 0 {
+		errs = append(errs, errors.New("max concurrent syncs must not be negative"))
+	}
+	if config.SyncMaxAttempts < 1 {
+		errs = append(errs, errors.New("sync max attempts must be at least 1"))
+	}
+	if config.SyncRetryDelay <= 0 || config.SyncRetryMaxDelay < config.SyncRetryDelay {
+		errs = append(errs, errors.New("sync retry delay must be positive and not exceed the max retry delay"))
+	}
+	if _, err := parseBandwidthLimit(config.RsyncBwLimit); err != nil {
+		errs = append(errs, fmt.Errorf("rsync bwlimit: %w", err))
+	}
+	if _, err := parseBandwidthWindows(config.RsyncBwLimitWindows); err != nil {
+		errs = append(errs, fmt.Errorf("rsync bwlimit windows: %w", err))
+	}
+	if config.DirsRefreshInterval <= 0 {
+		errs = append(errs, errors.New("dirs refresh interval must be positive"))
 	}
-	if config.RemoteUser == "" {
-		errs = append(errs, errors.New("remote user must be specified"))
+	if config.LocalRescanInterval <= 0 {
+		errs = append(errs, errors.New("local rescan interval must be positive"))
 	}
-	if config.RemotePort == 0 {
-		errs = append(errs, errors.New("remote port must be specified"))
+	if _, err := parseSize(config.DiskReserve); err != nil {
+		errs = append(errs, fmt.Errorf("disk reserve: %w", err))
 	}
-	if config.RsyncSSHKey == "" {
-		errs = append(errs, errors.New("rsync ssh key file must be specified"))
+	if config.DiskCheckInterval <= 0 {
+		errs = append(errs, errors.New("disk check interval must be positive"))
 	}
-	if config.LsSSHKey == "" {
-		errs = append(errs, errors.New("ls ssh key file must be specified"))
+	if config.RetentionInterval <= 0 {
+		errs = append(errs, errors.New("retention interval must be positive"))
 	}
-	if config.KnownHosts == "" {
-		errs = append(errs, errors.New("known host file must be specified"))
+	if config.MirrorMaxDeletions < 0 {
+		errs = append(errs, errors.New("mirror max deletions must not be negative"))
+	}
+	if _, err := parseSize(config.MirrorMaxDeletedBytes); err != nil {
+		errs = append(errs, fmt.Errorf("mirror max deleted bytes: %w", err))
 	}
 	if oidcEnabled(config) {
 		if config.OIDCIssuerURL == "" {
@@ -528,15 +787,23 @@ func testConfig(config Config) error {
 		if config.OIDCClientID == "" {
 			errs = append(errs, errors.New("oidc client id must be specified when oidc is enabled"))
 		}
-		if config.OIDCClientSecret == "" {
-			errs = append(errs, errors.New("oidc client secret must be specified when oidc is enabled"))
-		}
 		if config.OIDCRedirectURL == "" {
 			errs = append(errs, errors.New("oidc redirect url must be specified when oidc is enabled"))
 		}
 		if config.SessionSecret == "" {
 			errs = append(errs, errors.New("session secret must be specified when oidc is enabled"))
 		}
+		if role := strings.ToLower(strings.TrimSpace(config.OIDCDefaultRole)); role != "" && !validRole(role) {
+			errs = append(errs, fmt.Errorf("oidc default role %q must be viewer, operator or admin", config.OIDCDefaultRole))
+		}
+		if config.SessionMaxLifetime <= 0 {
+			errs = append(errs, errors.New("session max lifetime must be positive"))
+		}
+		if config.SessionIdleTimeout < 0 {
+			errs = append(errs, errors.New("session idle timeout must not be negative"))
+		}
+	} else if config.ACLFile != "" {
+		errs = append(errs, errors.New("acl file needs oidc to be enabled"))
 	}
 
 	return errors.Join(errs...)
@@ -552,9 +819,25 @@ func main() {
 	)
 
 	config := Config{
-		Host:     "",
-		Port:     8080,
-		LogLevel: "Info",
+		Host:                "",
+		Port:                8080,
+		LogLevel:            "Info",
+		HistoryLimit:        defaultHistoryLimit,
+		SyncMaxAttempts:     3,
+		SyncRetryDelay:      30 * time.Second,
+		SyncRetryMaxDelay:   10 * time.Minute,
+		MirrorMaxDeletions:  1000,
+		LsMode:              listModeSFTP,
+		LsMaxDepth:          defaultListMaxDepth,
+		DirsRefreshInterval: 5 * time.Minute,
+		LocalRescanInterval: 30 * time.Minute,
+		DiskReserve:         "1g",
+		DiskCheckInterval:   30 * time.Second,
+		RetentionInterval:   time.Hour,
+		OIDCRolesClaim:      defaultRolesClaim,
+		OIDCEmailClaim:      defaultEmailClaim,
+		OIDCNameClaim:       defaultNameClaim,
+		SessionMaxLifetime:  defaultSessionTTL,
 	}
 
 	err := loader.Load(context.Background(), &config)
@@ -563,7 +846,8 @@ func main() {
 	}
 
 	runningSyncs := &syncStorage{
-		Data: map[string]*Sync{},
+		Data:   map[string]*Sync{},
+		Events: newEventBus(),
 	}
 
 	var level slog.Level
@@ -582,6 +866,69 @@ func main() {
 		os.Exit(1)
 	}
 
+	runningSyncs.Remotes, err = loadRemotes(config)
+	if err != nil {
+		logger.Error("remotes init failed", slog.String("error", strings.ReplaceAll(err.Error(), "\n", " | ")))
+		os.Exit(1)
+	}
+
+	config.StatePath, err = prepareStatePath(config, runningSyncs.Remotes)
+	if err != nil {
+		logger.Error("state path init failed", slog.String("error", err.Error()))
+		os.Exit(1)
+	}
+
+	if config.HistoryFile == "" {
+		config.HistoryFile = filepath.Join(config.StatePath, ".syncer_history.json")
+	}
+	history, err := newHistoryStorage(config.HistoryFile, config.HistoryLimit)
+	if err != nil {
+		logger.Error("history init failed", slog.String("error", err.Error()))
+		os.Exit(1)
+	}
+
+	if config.SchedulesFile == "" {
+		config.SchedulesFile = filepath.Join(config.StatePath, ".syncer_schedules.json")
+	}
+	schedules, err := newScheduleStorage(config.SchedulesFile)
+	if err != nil {
+		logger.Error("schedules init failed", slog.String("error", err.Error()))
+		os.Exit(1)
+	}
+
+	runningSyncs.Disk = newDiskMonitor(logger, config, runningSyncs.Remotes)
+
+	local, err := newLocalTree(logger, config, runningSyncs.Remotes, runningSyncs.Events)
+	if err != nil {
+		logger.Error("local tree init failed", slog.String("error", err.Error()))
+		os.Exit(1)
+	}
+	dirs := newDirCache(logger, config, runningSyncs.Remotes, runningSyncs.Events, local)
+
+	if config.FiltersFile == "" {
+		config.FiltersFile = filepath.Join(config.StatePath, ".syncer_filters.json")
+	}
+	filters, err := newFilterStorage(config.FiltersFile)
+	if err != nil {
+		logger.Error("filters init failed", slog.String("error", err.Error()))
+		os.Exit(1)
+	}
+
+	policy, err := loadRetentionPolicy(config, runningSyncs.Remotes)
+	if err != nil {
+		logger.Error("retention init failed", slog.String("error", strings.ReplaceAll(err.Error(), "\n", " | ")))
+		os.Exit(1)
+	}
+	if config.RetentionAuditFile == "" {
+		config.RetentionAuditFile = filepath.Join(config.StatePath, ".syncer_retention_audit.json")
+	}
+	audit, err := newRetentionAudit(config.RetentionAuditFile)
+	if err != nil {
+		logger.Error("retention audit init failed", slog.String("error", err.Error()))
+		os.Exit(1)
+	}
+	retention := newRetention(logger, config, policy, runningSyncs, history, local, dirs, audit)
+
 	e := echo.New()
 	e.Use(middleware.RequestLoggerWithConfig(middleware.RequestLoggerConfig{
 		LogStatus:   true,
@@ -589,17 +936,21 @@ func main() {
 		LogError:    true,
 		HandleError: false, // forwards error to the global error handler, so it can decide appropriate status code
 		LogValuesFunc: func(c echo.Context, v middleware.RequestLoggerValues) error {
+			attrs := []slog.Attr{
+				slog.String("uri", v.URI),
+				slog.Int("status", v.Status),
+			}
+			if user, ok := c.Get(sessionContextKey).(User); ok {
+				attrs = append(attrs, slog.String("user", requestActor(c)))
+				if user.Token != "" {
+					attrs = append(attrs, slog.String("token", user.Token))
+				}
+			}
 			if v.Error == nil {
-				logger.LogAttrs(context.Background(), slog.LevelInfo, "REQUEST",
-					slog.String("uri", v.URI),
-					slog.Int("status", v.Status),
-				)
+				logger.LogAttrs(context.Background(), slog.LevelInfo, "REQUEST", attrs...)
 			} else {
-				logger.LogAttrs(context.Background(), slog.LevelError, "REQUEST_ERROR",
-					slog.String("uri", v.URI),
-					slog.Int("status", v.Status),
-					slog.String("err", v.Error.Error()),
-				)
+				attrs = append(attrs, slog.String("err", v.Error.Error()))
+				logger.LogAttrs(context.Background(), slog.LevelError, "REQUEST_ERROR", attrs...)
 			}
 			return nil
 		},
@@ -621,8 +972,35 @@ func main() {
 	}))
 
 	var auth *OIDCAuth
+	var acl *accessControl
 	if oidcEnabled(config) {
-		auth, err = NewOIDCAuth(context.Background(), logger, config)
+		acl, err = newAccessControl(logger, config, runningSyncs.Remotes)
+		if err != nil {
+			logger.Error("acl init failed", slog.String("error", err.Error()))
+			os.Exit(1)
+		}
+		go acl.Run(quit)
+
+		if config.TokensFile == "" {
+			config.TokensFile = filepath.Join(config.StatePath, ".syncer_tokens.json")
+		}
+		tokens, err := newTokenStorage(config.TokensFile)
+		if err != nil {
+			logger.Error("tokens init failed", slog.String("error", err.Error()))
+			os.Exit(1)
+		}
+
+		if config.SessionsFile == "" {
+			config.SessionsFile = filepath.Join(config.StatePath, ".syncer_sessions.json")
+		}
+		sessions, err := newSessionStorage(config.SessionsFile, config.SessionIdleTimeout)
+		if err != nil {
+			logger.Error("sessions init failed", slog.String("error", err.Error()))
+			os.Exit(1)
+		}
+		go sessions.Run(quit, logger)
+
+		auth, err = NewOIDCAuth(context.Background(), logger, config, acl, tokens, sessions)
 		if err != nil {
 			logger.Error("oidc init failed", slog.String("error", err.Error()))
 			os.Exit(1)
@@ -631,13 +1009,45 @@ func main() {
 		e.Use(auth.Middleware())
 	}
 
+	// Reading is open to every role. Changing syncs, schedules and filters
+	// takes an operator, as do dry runs and relisting remotes, which cost the
+	// remote real work. Removing local copies takes an admin.
+	operator := requireRole(roleOperator)
 	e.StaticFS("/", frontendFiles)
 	e.GET("/api/user", UserHandler(auth))
-	e.GET("/api/dirs", ListDirs(logger, quit, config))
-	e.GET("/api/syncs", ListSyncs(runningSyncs))
-	e.POST("/api/sync", StartSync(logger, quit, config, runningSyncs))
-	e.POST("/api/cancel", CancelSync(runningSyncs))
-	e.POST("/api/remove", Remove(config, runningSyncs))
+	e.GET("/api/status", StatusHandler(runningSyncs))
+	e.GET("/api/remotes", ListRemotes(runningSyncs.Remotes))
+	e.GET("/api/dirs", ListDirs(quit, dirs))
+	e.POST("/api/dirs/refresh", RefreshDirs(quit, dirs), operator)
+	e.GET("/api/tree", ListTree(quit, dirs))
+	e.GET("/api/syncs", ListSyncs(config, runningSyncs))
+	e.GET("/api/events", StreamEvents(quit, config, runningSyncs))
+	e.GET("/api/history", ListHistory(history))
+	e.POST("/api/sync", StartSync(logger, quit, config, runningSyncs, history, filters, dirs), operator)
+	e.POST("/api/sync/preview", PreviewSync(logger, config, runningSyncs, filters, dirs), operator)
+	e.POST("/api/sync/bwlimit", SetSyncBandwidth(logger, config, runningSyncs), operator)
+	e.POST("/api/cancel", CancelSync(logger, config, runningSyncs, history), operator)
+	e.POST("/api/queue/move", MoveQueued(logger, config, runningSyncs, history), operator)
+	e.POST("/api/queue/drop", DropQueued(logger, config, runningSyncs, history), operator)
+	e.GET("/api/schedules", ListSchedules(runningSyncs, history, schedules))
+	e.POST("/api/schedules", CreateSchedule(quit, runningSyncs.Remotes, dirs, schedules), operator)
+	e.PUT("/api/schedules/:id", UpdateSchedule(quit, runningSyncs.Remotes, dirs, schedules), operator)
+	e.DELETE("/api/schedules/:id", DeleteSchedule(schedules), operator)
+	e.GET("/api/filters", ListFilters(filters))
+	e.PUT("/api/filters/:name", SaveFilter(filters), operator)
+	e.DELETE("/api/filters/:name", DeleteFilter(filters), operator)
+	e.POST("/api/remove", Remove(config, runningSyncs, local, dirs), requireRole(roleAdmin))
+	e.GET("/api/retention", ListRetention(retention))
+	e.GET("/api/retention/audit", ListRetentionAudit(audit))
+
+	go runningSyncs.Disk.Run(quit, runningSyncs)
+	go local.Run(quit)
+	go dirs.Run(quit)
+	go retention.Run(quit)
+	go runScheduler(logger, quit, config, runningSyncs, history, dirs, acl, schedules)
+	if config.RsyncBwLimitWindows != "" {
+		go runBandwidthScheduler(logger, quit, config, runningSyncs)
+	}
 
 	srv := http.Server{
 		Addr:    fmt.Sprintf("%s:%d", config.Host, config.Port),
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/docker/go-units"
+)
+
+// maxRecordedDeletions caps how many deleted paths a job keeps, so a mirror
+// that removes a whole tree does not bloat the history file.
+const maxRecordedDeletions = 1000
+
+var errMirrorThreshold = errors.New("mirror deletion threshold exceeded")
+
+// checkMirror dry runs a mirror sync and refuses to go ahead when it would
+// delete more than the configured thresholds allow.
+func checkMirror(logger *slog.Logger, ctx context.Context, config Config, currentSync *Sync) error {
+	preview, err := previewSync(logger, ctx, config, currentSync.Remote, currentSync.RemotePath, currentSync.Filter, true)
+	if err != nil {
+		return fmt.Errorf("mirror dry run: %w", err)
+	}
+
+	// Validated on startup.
+	maxBytes, _ := parseSize(config.MirrorMaxDeletedBytes)
+	if config.MirrorMaxDeletions > 0 && preview.Deleted > config.MirrorMaxDeletions {
+		return fmt.Errorf("%w: %d deletions, limit is %d", errMirrorThreshold, preview.Deleted, config.MirrorMaxDeletions)
+	}
+	if maxBytes > 0 && preview.DeletedBytes > maxBytes {
+		return fmt.Errorf("%w: %s would be deleted, limit is %s", errMirrorThreshold, units.BytesSize(float64(preview.DeletedBytes)), units.BytesSize(float64(maxBytes)))
+	}
+
+	logger.Info("mirror dry run passed",
+		slog.String("path", currentSync.Path),
+		slog.Int("deletions", preview.Deleted),
+		slog.Uint64("deleted_bytes", preview.DeletedBytes),
+	)
+	return nil
+}
+
+// parseSize reads a size such as "500m" or "2g". An empty value is 0.
+func parseSize(value string) (uint64, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, nil
+	}
+	v, err := units.RAMInBytes(value)
+	if err != nil || v < 0 {
+		return 0, fmt.Errorf("invalid size %q", value)
+	}
+	return uint64(v), nil
+}
+
+// addDeletions records paths a mirror sync removed. Must be called with the
+// sync storage locked.
+func addDeletions(currentSync *Sync, deleted []string) {
+	currentSync.DeletedCount += len(deleted)
+	room := maxRecordedDeletions - len(currentSync.Deleted)
+	if room > 0 {
+		currentSync.Deleted = append(currentSync.Deleted, deleted[:min(room, len(deleted))]...)
+	}
+}
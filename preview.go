@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	previewChangeNew     = "new"
+	previewChangeChanged = "changed"
+	previewChangeDeleted = "deleted"
+
+	maxPreviewItems = 1000
+)
+
+type PreviewItem struct {
+	Path   string `json:"path"`
+	Change string `json:"change"`
+	Dir    bool   `json:"dir,omitempty"`
+	Size   uint64 `json:"size"`
+}
+
+type SyncPreview struct {
+	Path       string `json:"path"`
+	New        int    `json:"new"`
+	Changed    int    `json:"changed"`
+	Deleted    int    `json:"deleted"`
+	TotalBytes uint64 `json:"total_bytes"`
+	// DeletedBytes covers every deletion, also those past the listed items.
+	DeletedBytes uint64        `json:"deleted_bytes"`
+	Items        []PreviewItem `json:"items"`
+	Truncated    bool          `json:"truncated,omitempty"`
+}
+
+func newSyncPreview(path string) SyncPreview {
+	return SyncPreview{
+		Path:  path,
+		Items: make([]PreviewItem, 0),
+	}
+}
+
+// add counts item and lists it while there is room.
+func (p *SyncPreview) add(item PreviewItem) {
+	switch item.Change {
+	case previewChangeNew:
+		p.New++
+	case previewChangeChanged:
+		p.Changed++
+	case previewChangeDeleted:
+		p.Deleted++
+	}
+	if item.Change == previewChangeDeleted {
+		p.DeletedBytes += item.Size
+	} else {
+		p.TotalBytes += item.Size
+	}
+	if len(p.Items) < maxPreviewItems {
+		p.Items = append(p.Items, item)
+	} else {
+		p.Truncated = true
+	}
+}
+
+// previewSync asks the remote's transfer backend what a sync of remotePath
+// would change. With mirror set it also reports the local files a mirror sync
+// would delete.
+func previewSync(logger *slog.Logger, ctx context.Context, config Config, remote *Remote, remotePath string, filter []string, mirror bool) (SyncPreview, error) {
+	// Pointing a transfer at a missing parent would fail, and the preview must
+	// not create it, so compare against an empty directory instead.
+	syncPath, _ := filepath.Split(remote.LocalDir(config, remotePath))
+	if _, err := os.Stat(syncPath); errors.Is(err, os.ErrNotExist) {
+		empty, err := os.MkdirTemp("", "syncer-preview-")
+		if err != nil {
+			return SyncPreview{}, fmt.Errorf("create empty dir: %w", err)
+		}
+		defer os.RemoveAll(empty)
+		syncPath = empty + "/"
+	} else if err != nil {
+		return SyncPreview{}, fmt.Errorf("stat path: %w", err)
+	}
+
+	preview, err := remote.backend.Preview(logger, ctx, TransferOptions{
+		RemotePath: remote.HostPath(remotePath),
+		LocalDir:   syncPath,
+		Filter:     filter,
+		Mirror:     mirror,
+	})
+	if err != nil {
+		return SyncPreview{}, err
+	}
+	preview.Path = remote.Address(remotePath)
+	return preview, nil
+}
+
+func PreviewSync(logger *slog.Logger, config Config, runningSyncs *syncStorage, filters *filterStorage, dirs *dirCache) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		request := &SyncRequest{}
+
+		err := c.Bind(request)
+		if err != nil {
+			return fmt.Errorf("load request: %w", err)
+		}
+
+		filter, err := filters.Rules(request.Profile, request.Include, request.Exclude)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, Result[string]{Error: err.Error()})
+		}
+
+		remote, remotePath, err := runningSyncs.Remotes.Resolve(request.Path)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, Result[string]{Error: err.Error()})
+		}
+
+		// A dry run works on the remote and shows what would be deleted, so
+		// it takes the same grant as the sync itself.
+		if ok, err := requestAccess(c).authorize(aclActionSync, remote.Address(remotePath)); err != nil {
+			return err
+		} else if !ok {
+			return c.JSON(http.StatusBadRequest, Result[string]{Error: "invalid path"})
+		}
+
+		ctx := c.Request().Context()
+		if ok, err := dirs.HasRemotePath(ctx, remote, remotePath); err != nil {
+			return fmt.Errorf("list remote: %w", err)
+		} else if !ok {
+			return c.JSON(http.StatusBadRequest, Result[string]{Error: "invalid path"})
+		}
+
+		preview, err := previewSync(logger, ctx, config, remote, remotePath, filter, request.Mirror)
+		if err != nil {
+			return fmt.Errorf("preview sync: %w", err)
+		}
+		return c.JSON(http.StatusOK, Result[SyncPreview]{Results: []SyncPreview{preview}})
+	}
+}
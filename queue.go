@@ -0,0 +1,240 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	syncStateQueued  = "queued"
+	syncStateRunning = "running"
+)
+
+var (
+	errSyncExists    = errors.New("sync already queued or running")
+	errSyncNotQueued = errors.New("sync is not queued")
+	errSyncNotFound  = errors.New("sync not found")
+)
+
+type MoveQueuedRequest struct {
+	Path     string `json:"path"`
+	Position int    `json:"position"`
+}
+
+type DropQueuedRequest PathRequest
+
+// pathsOverlap reports whether one of the addresses a and b is the other or
+// below it. Siblings sharing a prefix such as /data and /data2 do not overlap.
+func pathsOverlap(a, b string) bool {
+	return pathWithin(a, b) || pathWithin(b, a)
+}
+
+func (st *syncStorage) runningCount() int {
+	count := 0
+	for _, value := range st.Data {
+		if value.State == syncStateRunning {
+			count++
+		}
+	}
+	return count
+}
+
+// promote starts queued syncs in queue order while there is a free slot. A
+// queued sync is held back when it overlaps a running sync or an earlier
+// queued one, so overlapping requests always run in the order they were made.
+// Must be called with the storage locked.
+func (st *syncStorage) promote(logger *slog.Logger, config Config, history *historyStorage) {
+	running := st.runningCount()
+	remaining := make([]*Sync, 0, len(st.Queue))
+
+	for i, item := range st.Queue {
+		if config.MaxConcurrentSyncs > 0 && running >= config.MaxConcurrentSyncs {
+			remaining = append(remaining, st.Queue[i:]...)
+			break
+		}
+		if st.blocked(item, remaining) {
+			remaining = append(remaining, item)
+			continue
+		}
+
+		item.State = syncStateRunning
+		item.StartedAt = time.Now()
+		item.publishedPosition = 0
+		running++
+		logger.Info("sync started", slog.String("path", item.Path), slog.String("id", item.ID))
+		st.publish(Event{Type: eventStart, Sync: st.resultPtr(item, config)})
+		go startSync(logger, item.Context, config, st, history, item)
+	}
+
+	st.Queue = remaining
+	for i, item := range st.Queue {
+		if item.publishedPosition == i+1 {
+			continue
+		}
+		item.publishedPosition = i + 1
+		st.publish(Event{Type: eventQueued, Sync: st.resultPtr(item, config)})
+	}
+}
+
+func (st *syncStorage) blocked(item *Sync, queuedBefore []*Sync) bool {
+	for _, value := range st.Data {
+		if value.State == syncStateRunning && pathsOverlap(value.Path, item.Path) {
+			return true
+		}
+	}
+	for _, value := range queuedBefore {
+		if pathsOverlap(value.Path, item.Path) {
+			return true
+		}
+	}
+	return false
+}
+
+func (st *syncStorage) queuePosition(path string) int {
+	for i, item := range st.Queue {
+		if item.Path == path {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// move places a queued sync at the given 1-based position, clamping it to the
+// queue bounds. Must be called with the storage locked.
+func (st *syncStorage) move(path string, position int) error {
+	index := st.queuePosition(path) - 1
+	if index < 0 {
+		return errSyncNotQueued
+	}
+
+	item := st.Queue[index]
+	st.Queue = slices.Delete(st.Queue, index, index+1)
+	position = max(1, min(position, len(st.Queue)+1))
+	st.Queue = slices.Insert(st.Queue, position-1, item)
+	return nil
+}
+
+// drop removes a queued sync without ever starting it and returns its history
+// entry, or nil when path is not queued. Must be called with the storage
+// locked.
+func (st *syncStorage) drop(logger *slog.Logger, config Config, history *historyStorage, path string, droppedBy string) *HistoryEntry {
+	index := st.queuePosition(path) - 1
+	if index < 0 {
+		return nil
+	}
+
+	item := st.Queue[index]
+	st.Queue = slices.Delete(st.Queue, index, index+1)
+	delete(st.Data, path)
+	item.CancelledBy = droppedBy
+	item.Cancel()
+
+	entry := newHistoryEntry(item, nil)
+	st.publish(Event{Type: eventRemove, Sync: st.resultPtr(item, config)})
+	st.promote(logger, config, history)
+	return &entry
+}
+
+func (st *syncStorage) resultPtr(item *Sync, config Config) *SyncResult {
+	result := st.result(item, config)
+	return &result
+}
+
+func (st *syncStorage) result(item *Sync, config Config) SyncResult {
+	result := SyncResult{
+		ID:             item.ID,
+		Path:           item.Path,
+		Remote:         item.Remote.Name,
+		State:          item.State,
+		Position:       st.queuePosition(item.Path),
+		Attempt:        item.Attempt,
+		MaxAttempts:    config.SyncMaxAttempts,
+		Progress:       item.Progress,
+		Speed:          item.Speed,
+		Downloaded:     item.Downloaded,
+		TimeLeft:       item.TimeLeft,
+		BwLimit:        item.BwLimit,
+		AppliedBwLimit: item.AppliedBwLimit,
+		FilterProfile:  item.FilterProfile,
+		Filter:         item.Filter,
+		Mirror:         item.Mirror,
+		Estimate:       item.Estimate,
+		Paused:         item.Paused,
+	}
+	if !item.NextRetry.IsZero() {
+		nextRetry := item.NextRetry
+		result.NextRetry = &nextRetry
+	}
+	return result
+}
+
+func MoveQueued(logger *slog.Logger, config Config, runningSyncs *syncStorage, history *historyStorage) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		request := &MoveQueuedRequest{}
+
+		err := c.Bind(request)
+		if err != nil {
+			return fmt.Errorf("load request: %w", err)
+		}
+
+		path, err := runningSyncs.Remotes.Canonical(request.Path)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, Result[string]{Error: err.Error()})
+		}
+
+		if ok, err := requestAccess(c).authorize(aclActionSync, path); err != nil {
+			return err
+		} else if !ok {
+			return c.JSON(http.StatusBadRequest, Result[string]{Error: errSyncNotQueued.Error()})
+		}
+
+		runningSyncs.Lock()
+		defer runningSyncs.Unlock()
+
+		if err := runningSyncs.move(path, request.Position); err != nil {
+			return c.JSON(http.StatusBadRequest, Result[string]{Error: err.Error()})
+		}
+		runningSyncs.promote(logger, config, history)
+
+		return c.JSON(http.StatusOK, Result[string]{})
+	}
+}
+
+func DropQueued(logger *slog.Logger, config Config, runningSyncs *syncStorage, history *historyStorage) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		request := &DropQueuedRequest{}
+
+		err := c.Bind(request)
+		if err != nil {
+			return fmt.Errorf("load request: %w", err)
+		}
+
+		path, err := runningSyncs.Remotes.Canonical(request.Path)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, Result[string]{Error: err.Error()})
+		}
+
+		if ok, err := requestAccess(c).authorize(aclActionCancel, path); err != nil {
+			return err
+		} else if !ok {
+			return c.JSON(http.StatusBadRequest, Result[string]{Error: errSyncNotQueued.Error()})
+		}
+
+		runningSyncs.Lock()
+		entry := runningSyncs.drop(logger, config, history, path, requestActor(c))
+		runningSyncs.Unlock()
+
+		if entry == nil {
+			return c.JSON(http.StatusBadRequest, Result[string]{Error: errSyncNotQueued.Error()})
+		}
+
+		recordSync(logger, history, *entry)
+		return c.JSON(http.StatusOK, Result[string]{})
+	}
+}
@@ -0,0 +1,26 @@
+package main
+
+import "testing"
+
+func TestPathsOverlap(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b string
+		want bool
+	}{
+		{name: "same path", a: "r:/data", b: "r:/data", want: true},
+		{name: "parent", a: "r:/data", b: "r:/data/movies", want: true},
+		{name: "child", a: "r:/data/movies", b: "r:/data", want: true},
+		{name: "shared prefix", a: "r:/data", b: "r:/data2", want: false},
+		{name: "siblings", a: "r:/data/movies", b: "r:/data/music", want: false},
+		{name: "remote root", a: "r:/", b: "r:/data", want: true},
+		{name: "other remote", a: "r:/data", b: "s:/data", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := pathsOverlap(tt.a, tt.b); got != tt.want {
+				t.Errorf("pathsOverlap(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.want)
+			}
+		})
+	}
+}
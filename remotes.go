@@ -0,0 +1,245 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+// defaultRemoteName names the remote built from the remote_* settings when no
+// remotes file is configured.
+const defaultRemoteName = "default"
+
+var (
+	errUnknownRemote = errors.New("unknown remote")
+	remoteNameRe     = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+)
+
+// Remote is a host syncs pull from. Paths on a remote are addressed as
+// "name:/path", where /path is relative to BasePath on the host and is copied
+// to the same path under LocalPath, which itself is relative to the data path.
+// ListMaxDepth limits how deep an SFTP listing goes, a negative depth lists the
+// whole tree.
+type Remote struct {
+	Name            string `json:"name"`
+	Host            string `json:"host"`
+	Port            uint32 `json:"port"`
+	User            string `json:"user"`
+	RsyncSSHKey     string `json:"rsync_ssh_key"`
+	LsSSHKey        string `json:"ls_ssh_key"`
+	KnownHosts      string `json:"known_hosts"`
+	BasePath        string `json:"base_path"`
+	LocalPath       string `json:"local_path"`
+	TransferBackend string `json:"transfer_backend"`
+	ListMode        string `json:"ls_mode"`
+	ListMaxDepth    int    `json:"ls_max_depth"`
+
+	backend TransferBackend
+	lister  *remoteLister
+}
+
+type RemoteResult struct {
+	Name string `json:"name"`
+	Host string `json:"host"`
+}
+
+type remoteSet struct {
+	list   []*Remote
+	byName map[string]*Remote
+}
+
+// loadRemotes reads the remotes file, or falls back to a single remote made
+// from the remote_* settings.
+func loadRemotes(config Config) (*remoteSet, error) {
+	var list []*Remote
+	if config.RemotesFile == "" {
+		list = []*Remote{{
+			Name:            defaultRemoteName,
+			Host:            config.RemoteHost,
+			Port:            config.RemotePort,
+			User:            config.RemoteUser,
+			RsyncSSHKey:     config.RsyncSSHKey,
+			LsSSHKey:        config.LsSSHKey,
+			KnownHosts:      config.KnownHosts,
+			TransferBackend: config.TransferBackend,
+			ListMode:        config.LsMode,
+			ListMaxDepth:    config.LsMaxDepth,
+		}}
+	} else {
+		if err := readJSONFile(config.RemotesFile, &list); err != nil {
+			return nil, fmt.Errorf("load remotes: %w", err)
+		}
+		if len(list) == 0 {
+			return nil, errors.New("remotes file lists no remotes")
+		}
+	}
+
+	remotes := &remoteSet{
+		list:   list,
+		byName: make(map[string]*Remote, len(list)),
+	}
+	var errs []error
+	for _, remote := range list {
+		if remote.Port == 0 {
+			remote.Port = 22
+		}
+		if remote.TransferBackend == "" {
+			remote.TransferBackend = config.TransferBackend
+		}
+		if remote.ListMode == "" {
+			remote.ListMode = config.LsMode
+		}
+		if remote.ListMaxDepth == 0 {
+			remote.ListMaxDepth = config.LsMaxDepth
+		}
+		remote.BasePath = strings.TrimSuffix(remote.BasePath, "/")
+		remote.LocalPath = filepath.Clean("/" + remote.LocalPath)
+
+		if err := remote.validate(len(list) > 1); err != nil {
+			errs = append(errs, fmt.Errorf("remote %q: %w", remote.Name, err))
+			continue
+		}
+		if _, ok := remotes.byName[remote.Name]; ok {
+			errs = append(errs, fmt.Errorf("remote %q is listed twice", remote.Name))
+			continue
+		}
+		for _, other := range remotes.byName {
+			if pathsOverlap(remote.LocalPath+"/", other.LocalPath+"/") {
+				errs = append(errs, fmt.Errorf("remotes %q and %q share local path %s", other.Name, remote.Name, remote.LocalPath))
+			}
+		}
+
+		backend, err := newTransferBackend(remote)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("remote %q: %w", remote.Name, err))
+			continue
+		}
+		remote.backend = backend
+		remote.lister = &remoteLister{remote: remote}
+		remotes.byName[remote.Name] = remote
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	return remotes, nil
+}
+
+func (r *Remote) validate(shared bool) error {
+	var errs []error
+	if !remoteNameRe.MatchString(r.Name) {
+		errs = append(errs, errors.New("name must only contain letters, digits, '-' and '_'"))
+	}
+	if r.Host == "" {
+		errs = append(errs, errors.New("host must be specified"))
+	}
+	if r.User == "" {
+		errs = append(errs, errors.New("user must be specified"))
+	}
+	if r.RsyncSSHKey == "" {
+		errs = append(errs, errors.New("rsync ssh key file must be specified"))
+	}
+	if r.LsSSHKey == "" {
+		errs = append(errs, errors.New("ls ssh key file must be specified"))
+	}
+	if r.KnownHosts == "" {
+		errs = append(errs, errors.New("known host file must be specified"))
+	}
+	if r.ListMode != listModeSFTP && r.ListMode != listModeCommand {
+		errs = append(errs, fmt.Errorf("ls mode must be %q or %q", listModeSFTP, listModeCommand))
+	}
+	if shared && r.LocalPath == "/" {
+		errs = append(errs, errors.New("local path must be specified when there are several remotes"))
+	}
+	return errors.Join(errs...)
+}
+
+// Holding returns the remote whose local path holds path, nil when there is
+// none.
+func (rs *remoteSet) Holding(config Config, path string) *Remote {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil
+	}
+	for _, remote := range rs.list {
+		root, err := filepath.Abs(remote.LocalDir(config, "/"))
+		if err != nil {
+			continue
+		}
+		if abs == root || strings.HasPrefix(abs, root+"/") {
+			return remote
+		}
+	}
+	return nil
+}
+
+func (rs *remoteSet) List() []*Remote {
+	return rs.list
+}
+
+// Resolve splits a "remote:/path" address. A bare path is accepted as long as
+// there is only one remote.
+func (rs *remoteSet) Resolve(address string) (*Remote, string, error) {
+	name, remotePath, ok := strings.Cut(address, ":")
+	if !ok || !remoteNameRe.MatchString(name) || !strings.HasPrefix(remotePath, "/") {
+		if len(rs.list) != 1 {
+			return nil, "", fmt.Errorf("%w: address %q must look like remote:/path", errUnknownRemote, address)
+		}
+		return rs.list[0], address, nil
+	}
+
+	remote, ok := rs.byName[name]
+	if !ok {
+		return nil, "", fmt.Errorf("%w: %s", errUnknownRemote, name)
+	}
+	return remote, remotePath, nil
+}
+
+// Canonical returns address in the remote:/path form syncs are keyed by and
+// ACL grants are matched against.
+func (rs *remoteSet) Canonical(address string) (string, error) {
+	remote, remotePath, err := rs.Resolve(address)
+	if err != nil {
+		return "", err
+	}
+	return remote.Address(remotePath), nil
+}
+
+func (r *Remote) Address(remotePath string) string {
+	return r.Name + ":" + remotePath
+}
+
+// HostPath is where remotePath lives on the remote host.
+func (r *Remote) HostPath(remotePath string) string {
+	if r.BasePath == "" {
+		return remotePath
+	}
+	return path.Join(r.BasePath, remotePath)
+}
+
+// LocalDir is the local copy of remotePath.
+func (r *Remote) LocalDir(config Config, remotePath string) string {
+	return filepath.Join(config.DataPath, r.LocalPath, remotePath)
+}
+
+func ListRemotes(remotes *remoteSet) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		result := Result[RemoteResult]{
+			Error:   "",
+			Results: make([]RemoteResult, 0, len(remotes.List())),
+		}
+		access := requestAccess(c)
+		for _, remote := range remotes.List() {
+			if !access.Visible(remote.Address("/")) {
+				continue
+			}
+			result.Results = append(result.Results, RemoteResult{Name: remote.Name, Host: remote.Host})
+		}
+		return c.JSON(http.StatusOK, result)
+	}
+}
@@ -0,0 +1,441 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	s "sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	retentionReasonKeepRecent = "keep_recent"
+	retentionReasonMaxAge     = "max_age"
+	retentionReasonQuota      = "quota"
+
+	defaultRetentionAuditLimit = 1000
+)
+
+// RetentionPolicy is read from the retention file. Each rule manages the
+// subdirectories of one local directory: KeepRecent keeps that many of the
+// most recently synced ones, MaxAgeDays evicts those not synced for that long,
+// and LRU offers them for eviction by last access whenever the data directory
+// as a whole grows past MaxTotalSize.
+type RetentionPolicy struct {
+	MaxTotalSize string          `json:"max_total_size"`
+	Rules        []RetentionRule `json:"rules"`
+}
+
+type RetentionRule struct {
+	Path       string `json:"path"`
+	KeepRecent int    `json:"keep_recent,omitempty"`
+	MaxAgeDays int    `json:"max_age_days,omitempty"`
+	LRU        bool   `json:"lru,omitempty"`
+}
+
+type RetentionEviction struct {
+	Path       string    `json:"path"`
+	Rule       string    `json:"rule"`
+	Reason     string    `json:"reason"`
+	Size       uint64    `json:"size"`
+	LastSynced time.Time `json:"last_synced"`
+	LastAccess time.Time `json:"last_access"`
+	// Blocked evictions overlap a queued or running sync and are left alone
+	// until it is over.
+	Blocked bool `json:"blocked,omitempty"`
+
+	remote     *Remote
+	remotePath string
+}
+
+type RetentionReport struct {
+	// PlannedAt is when the local copies were last looked at, zero until the
+	// first plan is done.
+	PlannedAt    time.Time           `json:"planned_at"`
+	TotalSize    uint64              `json:"total_size"`
+	MaxTotalSize uint64              `json:"max_total_size,omitempty"`
+	Evictions    []RetentionEviction `json:"evictions"`
+}
+
+type RetentionAuditEntry struct {
+	Time       time.Time `json:"time"`
+	Path       string    `json:"path"`
+	Rule       string    `json:"rule"`
+	Reason     string    `json:"reason"`
+	Size       uint64    `json:"size"`
+	LastSynced time.Time `json:"last_synced"`
+	LastAccess time.Time `json:"last_access"`
+}
+
+// retentionAudit records every directory retention removed.
+type retentionAudit struct {
+	path    string
+	entries []RetentionAuditEntry
+	s.Mutex
+}
+
+func newRetentionAudit(path string) (*retentionAudit, error) {
+	audit := &retentionAudit{
+		path:    path,
+		entries: []RetentionAuditEntry{},
+	}
+	if err := readJSONFile(path, &audit.entries); err != nil {
+		return nil, fmt.Errorf("load retention audit: %w", err)
+	}
+	return audit, nil
+}
+
+func (a *retentionAudit) Add(entry RetentionAuditEntry) error {
+	a.Lock()
+	defer a.Unlock()
+
+	a.entries = append(a.entries, entry)
+	if len(a.entries) > defaultRetentionAuditLimit {
+		a.entries = a.entries[len(a.entries)-defaultRetentionAuditLimit:]
+	}
+	return writeJSONFile(a.path, a.entries)
+}
+
+// List returns the entries newest first.
+func (a *retentionAudit) List() []RetentionAuditEntry {
+	a.Lock()
+	defer a.Unlock()
+
+	entries := make([]RetentionAuditEntry, 0, len(a.entries))
+	for i := len(a.entries) - 1; i >= 0; i-- {
+		entries = append(entries, a.entries[i])
+	}
+	return entries
+}
+
+type retention struct {
+	logger       *slog.Logger
+	config       Config
+	policy       RetentionPolicy
+	maxTotalSize uint64
+	runningSyncs *syncStorage
+	history      *historyStorage
+	local        *localTree
+	dirs         *dirCache
+	audit        *retentionAudit
+	// apply keeps a slow eviction run from overlapping the next one.
+	apply s.Mutex
+	// report is the plan of the last run. Planning walks the local copies,
+	// so requests get this one instead of a fresh plan.
+	report RetentionReport
+	s.Mutex
+}
+
+// loadRetentionPolicy reads the retention file. Without one nothing is ever
+// evicted.
+func loadRetentionPolicy(config Config, remotes *remoteSet) (RetentionPolicy, error) {
+	var policy RetentionPolicy
+	if config.RetentionFile == "" {
+		return policy, nil
+	}
+	if err := readJSONFile(config.RetentionFile, &policy); err != nil {
+		return RetentionPolicy{}, fmt.Errorf("load retention policy: %w", err)
+	}
+
+	var errs []error
+	if _, err := parseSize(policy.MaxTotalSize); err != nil {
+		errs = append(errs, fmt.Errorf("max total size: %w", err))
+	}
+	for i, rule := range policy.Rules {
+		remote, remotePath, err := remotes.Resolve(rule.Path)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("rule %d: %w", i+1, err))
+			continue
+		}
+		policy.Rules[i].Path = remote.Address(remotePath)
+		if rule.KeepRecent < 0 || rule.MaxAgeDays < 0 {
+			errs = append(errs, fmt.Errorf("rule %s: keep recent and max age days must not be negative", rule.Path))
+		}
+		if rule.KeepRecent == 0 && rule.MaxAgeDays == 0 && !rule.LRU {
+			errs = append(errs, fmt.Errorf("rule %s does not evict anything", rule.Path))
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return RetentionPolicy{}, err
+	}
+	return policy, nil
+}
+
+func newRetention(logger *slog.Logger, config Config, policy RetentionPolicy, runningSyncs *syncStorage, history *historyStorage, local *localTree, dirs *dirCache, audit *retentionAudit) *retention {
+	// Validated on startup.
+	maxTotalSize, _ := parseSize(policy.MaxTotalSize)
+	return &retention{
+		logger:       logger,
+		config:       config,
+		policy:       policy,
+		maxTotalSize: maxTotalSize,
+		runningSyncs: runningSyncs,
+		history:      history,
+		local:        local,
+		dirs:         dirs,
+		audit:        audit,
+		report: RetentionReport{
+			MaxTotalSize: maxTotalSize,
+			Evictions:    make([]RetentionEviction, 0),
+		},
+	}
+}
+
+// Run plans right away and then evicts whatever the policy says should go
+// every retention interval until ctx is done.
+func (r *retention) Run(ctx context.Context) {
+	r.store(r.Plan())
+
+	ticker := time.NewTicker(r.config.RetentionInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			r.Apply()
+		}
+	}
+}
+
+// Plan works out what the policy would evict right now without touching
+// anything.
+func (r *retention) Plan() RetentionReport {
+	now := time.Now()
+	report := RetentionReport{
+		PlannedAt:    now,
+		MaxTotalSize: r.maxTotalSize,
+		Evictions:    make([]RetentionEviction, 0),
+	}
+
+	snapshots := map[string]map[string]*Dir{}
+	for _, remote := range r.runningSyncs.Remotes.List() {
+		snapshots[remote.Name] = r.local.Snapshot(remote)
+		if root, ok := snapshots[remote.Name]["/"]; ok {
+			report.TotalSize += localBytes(root)
+		}
+	}
+
+	evicted := map[string]bool{}
+	var lru []RetentionEviction
+	for _, rule := range r.policy.Rules {
+		// Validated on startup.
+		remote, remotePath, _ := r.runningSyncs.Remotes.Resolve(rule.Path)
+		parent, ok := snapshots[remote.Name][remotePath]
+		if !ok {
+			continue
+		}
+
+		candidates := make([]RetentionEviction, 0, len(parent.Children))
+		for _, child := range parent.Children {
+			candidate := RetentionEviction{
+				Path:       remote.Address(child.Path),
+				Rule:       rule.Path,
+				Size:       localBytes(child),
+				remote:     remote,
+				remotePath: child.Path,
+			}
+			candidate.LastSynced = r.lastSynced(candidate.Path, remote.LocalDir(r.config, child.Path))
+			if rule.LRU {
+				candidate.LastAccess = lastAccess(remote.LocalDir(r.config, child.Path))
+			}
+			candidates = append(candidates, candidate)
+		}
+		sort.Slice(candidates, func(i, j int) bool {
+			return candidates[i].LastSynced.After(candidates[j].LastSynced)
+		})
+
+		for i, candidate := range candidates {
+			switch {
+			case evicted[candidate.Path]:
+				continue
+			case rule.KeepRecent > 0 && i >= rule.KeepRecent:
+				candidate.Reason = retentionReasonKeepRecent
+			case rule.MaxAgeDays > 0 && now.Sub(candidate.LastSynced) > time.Duration(rule.MaxAgeDays)*24*time.Hour:
+				candidate.Reason = retentionReasonMaxAge
+			default:
+				if rule.LRU {
+					lru = append(lru, candidate)
+				}
+				continue
+			}
+			evicted[candidate.Path] = true
+			report.Evictions = append(report.Evictions, candidate)
+		}
+	}
+
+	remaining := report.TotalSize
+	for _, eviction := range report.Evictions {
+		remaining -= min(eviction.Size, remaining)
+	}
+	if r.maxTotalSize > 0 && remaining > r.maxTotalSize {
+		sort.Slice(lru, func(i, j int) bool {
+			return lru[i].LastAccess.Before(lru[j].LastAccess)
+		})
+		for _, candidate := range lru {
+			if remaining <= r.maxTotalSize {
+				break
+			}
+			if evicted[candidate.Path] {
+				continue
+			}
+			candidate.Reason = retentionReasonQuota
+			evicted[candidate.Path] = true
+			report.Evictions = append(report.Evictions, candidate)
+			remaining -= min(candidate.Size, remaining)
+		}
+	}
+
+	r.block(report.Evictions)
+	return report
+}
+
+// block marks the evictions that overlap a queued or running sync.
+func (r *retention) block(evictions []RetentionEviction) {
+	r.runningSyncs.Lock()
+	defer r.runningSyncs.Unlock()
+
+	for i := range evictions {
+		evictions[i].Blocked = false
+		for path := range r.runningSyncs.Data {
+			if pathsOverlap(path, evictions[i].Path) {
+				evictions[i].Blocked = true
+			}
+		}
+	}
+}
+
+// Report returns the plan of the last run, with the evictions marked blocked
+// that overlap a sync queued or running now.
+func (r *retention) Report() RetentionReport {
+	r.Lock()
+	report := r.report
+	report.Evictions = slices.Clone(r.report.Evictions)
+	r.Unlock()
+
+	r.block(report.Evictions)
+	return report
+}
+
+func (r *retention) store(report RetentionReport) {
+	r.Lock()
+	defer r.Unlock()
+	r.report = report
+}
+
+// Apply evicts what Plan reports, skipping directories a sync is busy with.
+// What is left of the plan afterwards is kept for Report.
+func (r *retention) Apply() {
+	r.apply.Lock()
+	defer r.apply.Unlock()
+
+	report := r.Plan()
+	remaining := make([]RetentionEviction, 0, len(report.Evictions))
+	for _, eviction := range report.Evictions {
+		if eviction.Blocked {
+			remaining = append(remaining, eviction)
+			continue
+		}
+
+		ok, err := remove(r.config, r.runningSyncs, eviction.remote, eviction.remotePath)
+		if err != nil {
+			r.logger.Error("retention eviction failed", slog.String("path", eviction.Path), slog.String("error", err.Error()))
+			remaining = append(remaining, eviction)
+			continue
+		}
+		if !ok {
+			remaining = append(remaining, eviction)
+			continue
+		}
+		report.TotalSize -= min(eviction.Size, report.TotalSize)
+		r.local.Forget(eviction.remote, eviction.remotePath)
+		r.dirs.Invalidate(eviction.remote)
+
+		r.logger.Info("retention evicted",
+			slog.String("path", eviction.Path),
+			slog.String("reason", eviction.Reason),
+			slog.Uint64("size", eviction.Size),
+		)
+		err = r.audit.Add(RetentionAuditEntry{
+			Time:       time.Now(),
+			Path:       eviction.Path,
+			Rule:       eviction.Rule,
+			Reason:     eviction.Reason,
+			Size:       eviction.Size,
+			LastSynced: eviction.LastSynced,
+			LastAccess: eviction.LastAccess,
+		})
+		if err != nil {
+			r.logger.Error("record retention audit", slog.String("path", eviction.Path), slog.String("error", err.Error()))
+		}
+	}
+	report.Evictions = remaining
+	r.store(report)
+}
+
+// lastSynced is when a sync covering path last finished, or the modification
+// time of its local copy when the history does not go back that far.
+func (r *retention) lastSynced(path, localDir string) time.Time {
+	if finished, ok := r.history.LastFinished(path); ok {
+		return finished
+	}
+	if info, err := os.Stat(localDir); err == nil {
+		return info.ModTime()
+	}
+	return time.Time{}
+}
+
+// lastAccess is the newest access time of anything in dir.
+func lastAccess(dir string) time.Time {
+	var newest time.Time
+	_ = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if info, err := d.Info(); err == nil {
+			if accessed := accessTime(info); accessed.After(newest) {
+				newest = accessed
+			}
+		}
+		return nil
+	})
+	return newest
+}
+
+func ListRetention(retention *retention) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		access := requestAccess(c)
+		report := retention.Report()
+		evictions := make([]RetentionEviction, 0, len(report.Evictions))
+		for _, eviction := range report.Evictions {
+			if access.Allows(aclActionView, eviction.Path) {
+				evictions = append(evictions, eviction)
+			}
+		}
+		report.Evictions = evictions
+		return c.JSON(http.StatusOK, Result[RetentionReport]{Results: []RetentionReport{report}})
+	}
+}
+
+func ListRetentionAudit(audit *retentionAudit) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		access := requestAccess(c)
+		entries := make([]RetentionAuditEntry, 0)
+		for _, entry := range audit.List() {
+			if access.Allows(aclActionView, entry.Path) {
+				entries = append(entries, entry)
+			}
+		}
+		return c.JSON(http.StatusOK, Result[RetentionAuditEntry]{Results: entries, Total: len(entries)})
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"errors"
+	"math/rand/v2"
+	"os/exec"
+	"time"
+)
+
+// retryableRsyncCodes lists rsync exit codes caused by an interrupted
+// connection or a remote side that went away mid transfer. Anything else, such
+// as usage errors or missing files, will fail the same way on the next try.
+var retryableRsyncCodes = map[int]string{
+	10:  "error in socket I/O",
+	12:  "error in rsync protocol data stream",
+	23:  "partial transfer due to error",
+	24:  "partial transfer due to vanished source files",
+	30:  "timeout in data send/receive",
+	35:  "timeout waiting for daemon connection",
+	255: "ssh connection failed",
+}
+
+// errTransferInterrupted marks transfer errors caused by a dropped connection
+// rather than by the request itself, so that the sync is retried.
+var errTransferInterrupted = errors.New("transfer interrupted")
+
+func retryableSyncError(err error) bool {
+	if errors.Is(err, errTransferInterrupted) {
+		return true
+	}
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		return false
+	}
+	_, ok := retryableRsyncCodes[exitErr.ExitCode()]
+	return ok
+}
+
+// retryDelay doubles the configured delay for every failed attempt, caps it at
+// the max delay and spreads retries over the upper half of that window so
+// several failed syncs do not hammer the remote at the same moment.
+func retryDelay(config Config, attempt int) time.Duration {
+	delay := config.SyncRetryDelay
+	for i := 1; i < attempt && delay < config.SyncRetryMaxDelay; i++ {
+		delay *= 2
+	}
+	delay = min(delay, config.SyncRetryMaxDelay)
+
+	half := delay / 2
+	return half + rand.N(half+1)
+}
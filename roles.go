@@ -0,0 +1,170 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	roleViewer   = "viewer"
+	roleOperator = "operator"
+	roleAdmin    = "admin"
+
+	defaultRolesClaim = "groups"
+	defaultEmailClaim = "email"
+	defaultNameClaim  = "name"
+)
+
+var errForbidden = errors.New("forbidden")
+
+// roleRank orders the roles, each one may do everything the roles below it
+// may.
+var roleRank = map[string]int{
+	roleViewer:   1,
+	roleOperator: 2,
+	roleAdmin:    3,
+}
+
+// roleMapping works out the role of a user from the group claim of their ID
+// token or from explicit email lists. The highest match wins, a user matching
+// nothing gets the default role.
+type roleMapping struct {
+	claim       string
+	groups      map[string]string
+	emails      map[string]string
+	defaultRole string
+}
+
+func newRoleMapping(config Config) roleMapping {
+	mapping := roleMapping{
+		claim:  strings.TrimSpace(config.OIDCRolesClaim),
+		groups: map[string]string{},
+		emails: map[string]string{},
+	}
+	// A group or email listed for several roles keeps the highest.
+	for role, raw := range map[string][2]string{
+		roleViewer:   {config.OIDCViewerGroups, config.OIDCViewerEmails},
+		roleOperator: {config.OIDCOperatorGroups, config.OIDCOperatorEmails},
+		roleAdmin:    {config.OIDCAdminGroups, config.OIDCAdminEmails},
+	} {
+		for _, group := range splitValues(raw[0], false) {
+			mapping.groups[group] = higherRole(mapping.groups[group], role)
+		}
+		for _, email := range splitValues(raw[1], true) {
+			mapping.emails[email] = higherRole(mapping.emails[email], role)
+		}
+	}
+
+	mapping.defaultRole = strings.ToLower(strings.TrimSpace(config.OIDCDefaultRole))
+	if mapping.defaultRole == "" {
+		// Without any mapping everyone allowed in keeps full access, as before
+		// roles existed.
+		mapping.defaultRole = roleAdmin
+		if len(mapping.groups) > 0 || len(mapping.emails) > 0 {
+			mapping.defaultRole = roleViewer
+		}
+	}
+	return mapping
+}
+
+func validRole(role string) bool {
+	_, ok := roleRank[role]
+	return ok
+}
+
+func higherRole(a, b string) string {
+	if roleRank[b] > roleRank[a] {
+		return b
+	}
+	return a
+}
+
+// Resolve returns the role of the user with email and the given ID token
+// claims.
+func (m roleMapping) Resolve(email string, claims map[string]any) string {
+	role := m.emails[strings.ToLower(strings.TrimSpace(email))]
+	for _, group := range claimValues(lookupClaim(claims, m.claim)) {
+		role = higherRole(role, m.groups[group])
+	}
+	if role == "" {
+		return m.defaultRole
+	}
+	return role
+}
+
+// Current works out the role of user again from their email and the groups
+// kept since they last logged in, so mapping changes also apply to sessions,
+// API tokens and schedules without logging in again.
+func (m roleMapping) Current(user User) string {
+	groups := make([]any, 0, len(user.Groups))
+	for _, group := range user.Groups {
+		groups = append(groups, group)
+	}
+	return m.Resolve(user.Email, map[string]any{m.claim: groups})
+}
+
+// lookupClaim finds the claim at path, where dots step into nested objects as
+// in realm_access.roles. Claims with dots in their own name, as namespaced
+// claims tend to have, are found too.
+func lookupClaim(claims map[string]any, path string) any {
+	if value, ok := claims[path]; ok {
+		return value
+	}
+	for i := len(path) - 1; i > 0; i-- {
+		if path[i] != '.' {
+			continue
+		}
+		if nested, ok := claims[path[:i]].(map[string]any); ok {
+			if value := lookupClaim(nested, path[i+1:]); value != nil {
+				return value
+			}
+		}
+	}
+	return nil
+}
+
+// claimBool reads a boolean claim, some providers send it as a string.
+func claimBool(value any) bool {
+	switch value := value.(type) {
+	case bool:
+		return value
+	case string:
+		return strings.EqualFold(value, "true")
+	}
+	return false
+}
+
+// claimValues reads a claim holding either a list of strings or a single
+// space or comma separated string.
+func claimValues(value any) []string {
+	switch value := value.(type) {
+	case string:
+		return splitValues(value, false)
+	case []any:
+		values := make([]string, 0, len(value))
+		for _, item := range value {
+			if item, ok := item.(string); ok {
+				values = append(values, item)
+			}
+		}
+		return values
+	}
+	return nil
+}
+
+// requireRole rejects requests of users below role. Without authentication
+// there is no user and everything is allowed.
+func requireRole(role string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			user, ok := c.Get(sessionContextKey).(User)
+			if ok && roleRank[user.Role] < roleRank[role] {
+				return fmt.Errorf("%w: %s role required", errForbidden, role)
+			}
+			return next(c)
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestLookupClaim(t *testing.T) {
+	claims := map[string]any{
+		"email": "a@example.com",
+		"realm_access": map[string]any{
+			"roles": []any{"admin"},
+			"nested": map[string]any{
+				"deep": "value",
+			},
+		},
+		"https://example.com/groups": []any{"ops"},
+		"resource.access":            map[string]any{"roles": "dotted"},
+	}
+	tests := []struct {
+		path string
+		want any
+	}{
+		{path: "email", want: "a@example.com"},
+		{path: "realm_access.roles", want: []any{"admin"}},
+		{path: "realm_access.nested.deep", want: "value"},
+		{path: "https://example.com/groups", want: []any{"ops"}},
+		{path: "resource.access.roles", want: "dotted"},
+		{path: "missing", want: nil},
+		{path: "realm_access.missing", want: nil},
+		{path: "email.domain", want: nil},
+		{path: "", want: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			if got := lookupClaim(claims, tt.path); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("lookupClaim(%q) = %v, want %v", tt.path, got, tt.want)
+			}
+		})
+	}
+}
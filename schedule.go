@@ -0,0 +1,439 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	s "sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/robfig/cron/v3"
+)
+
+var errScheduleNotFound = errors.New("schedule not found")
+
+type Schedule struct {
+	ID        string    `json:"id"`
+	Path      string    `json:"path"`
+	Cron      string    `json:"cron"`
+	Enabled   bool      `json:"enabled"`
+	Mirror    bool      `json:"mirror,omitempty"`
+	CreatedBy string    `json:"created_by,omitempty"`
+	UpdatedAt time.Time `json:"updated_at"`
+	LastRun   time.Time `json:"last_run"`
+	LastJobID string    `json:"last_job_id,omitempty"`
+	LastError string    `json:"last_error,omitempty"`
+	// Owner is who last saved the schedule, its runs are checked against their
+	// access. Left out of responses.
+	Owner *User `json:"owner,omitempty"`
+}
+
+type ScheduleRequest struct {
+	Path    string `json:"path"`
+	Cron    string `json:"cron"`
+	Enabled *bool  `json:"enabled"`
+	Mirror  *bool  `json:"mirror"`
+}
+
+type ScheduleResult struct {
+	Schedule
+	NextRun    *time.Time `json:"next_run,omitempty"`
+	LastResult string     `json:"last_result,omitempty"`
+}
+
+type scheduleStorage struct {
+	path string
+	data []*Schedule
+	wake chan struct{}
+	s.Mutex
+}
+
+func newScheduleStorage(path string) (*scheduleStorage, error) {
+	schedules := &scheduleStorage{
+		path: path,
+		data: []*Schedule{},
+		wake: make(chan struct{}, 1),
+	}
+	if err := readJSONFile(path, &schedules.data); err != nil {
+		return nil, fmt.Errorf("load schedules: %w", err)
+	}
+	for _, schedule := range schedules.data {
+		if _, err := cron.ParseStandard(schedule.Cron); err != nil {
+			return nil, fmt.Errorf("schedule %s: %w", schedule.ID, err)
+		}
+	}
+	return schedules, nil
+}
+
+// save persists the schedules and wakes the scheduler so it can pick up the
+// new next run times. Must be called with the storage locked.
+func (st *scheduleStorage) save() error {
+	select {
+	case st.wake <- struct{}{}:
+	default:
+	}
+	return writeJSONFile(st.path, st.data)
+}
+
+func (st *scheduleStorage) find(id string) (*Schedule, int) {
+	for i, schedule := range st.data {
+		if schedule.ID == id {
+			return schedule, i
+		}
+	}
+	return nil, -1
+}
+
+// nextRun returns when the schedule is due next. Runs missed while the server
+// was down are caught up once, right after startup.
+func nextRun(schedule *Schedule) (time.Time, bool) {
+	if !schedule.Enabled {
+		return time.Time{}, false
+	}
+	spec, err := cron.ParseStandard(schedule.Cron)
+	if err != nil {
+		return time.Time{}, false
+	}
+	base := schedule.UpdatedAt
+	if schedule.LastRun.After(base) {
+		base = schedule.LastRun
+	}
+	return spec.Next(base), true
+}
+
+func runScheduler(logger *slog.Logger, ctx context.Context, config Config, runningSyncs *syncStorage, history *historyStorage, dirs *dirCache, acl *accessControl, schedules *scheduleStorage) {
+	for {
+		now := time.Now()
+
+		// Due schedules are marked as run and copied, their syncs are started
+		// with the storage unlocked since checking them lists the remote.
+		schedules.Lock()
+		var due []Schedule
+		for _, schedule := range schedules.data {
+			if next, ok := nextRun(schedule); ok && !next.After(now) {
+				schedule.LastRun = now
+				due = append(due, *schedule)
+			}
+		}
+		schedules.Unlock()
+
+		for i := range due {
+			runSchedule(logger, ctx, config, runningSyncs, history, dirs, acl, &due[i])
+		}
+
+		schedules.Lock()
+		for _, run := range due {
+			// The schedule may have been deleted while its sync started.
+			if schedule, _ := schedules.find(run.ID); schedule != nil {
+				schedule.LastJobID = run.LastJobID
+				schedule.LastError = run.LastError
+			}
+		}
+		if len(due) > 0 {
+			// Written directly rather than through save, which would wake this
+			// loop again for nothing.
+			if err := writeJSONFile(schedules.path, schedules.data); err != nil {
+				logger.Error("save schedules", slog.String("error", err.Error()))
+			}
+		}
+		wait := time.Hour
+		for _, schedule := range schedules.data {
+			if next, ok := nextRun(schedule); ok {
+				wait = min(wait, time.Until(next))
+			}
+		}
+		schedules.Unlock()
+
+		timer := time.NewTimer(max(wait, time.Second))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-schedules.wake:
+			timer.Stop()
+		case <-timer.C:
+		}
+	}
+}
+
+// runSchedule starts the sync of a due schedule and records the outcome on
+// schedule, a copy the caller writes back.
+func runSchedule(logger *slog.Logger, ctx context.Context, config Config, runningSyncs *syncStorage, history *historyStorage, dirs *dirCache, acl *accessControl, schedule *Schedule) {
+	schedule.LastError = ""
+
+	started, err := startSchedule(logger, ctx, config, runningSyncs, history, dirs, acl, schedule)
+	if err != nil {
+		logger.Warn("scheduled sync skipped", slog.String("id", schedule.ID), slog.String("path", schedule.Path), slog.String("error", err.Error()))
+		schedule.LastError = err.Error()
+		return
+	}
+
+	logger.Info("scheduled sync", slog.String("id", schedule.ID), slog.String("path", schedule.Path), slog.String("sync", started.ID))
+	schedule.LastJobID = started.ID
+}
+
+// startSchedule starts the sync of schedule after the checks StartSync runs
+// for manual ones, with the role and access its owner has now.
+func startSchedule(logger *slog.Logger, ctx context.Context, config Config, runningSyncs *syncStorage, history *historyStorage, dirs *dirCache, acl *accessControl, schedule *Schedule) (SyncResult, error) {
+	remote, remotePath, err := runningSyncs.Remotes.Resolve(schedule.Path)
+	if err != nil {
+		return SyncResult{}, err
+	}
+
+	var access *pathAccess
+	if oidcEnabled(config) {
+		owner := schedule.owner()
+		owner.Role = newRoleMapping(config).Current(owner)
+		if roleRank[owner.Role] < roleRank[roleOperator] {
+			name := owner.Email
+			if name == "" {
+				name = owner.Subject
+			}
+			return SyncResult{}, fmt.Errorf("%w: owner %s no longer has the %s role", errForbidden, name, roleOperator)
+		}
+		access = acl.For(owner)
+	}
+	estimate, err := checkSync(ctx, runningSyncs, dirs, access, remote, remotePath)
+	if err != nil {
+		return SyncResult{}, err
+	}
+	return sync(logger, ctx, config, runningSyncs, history, schedule.Path, "schedule:"+schedule.ID, syncOptions{
+		Mirror:   schedule.Mirror,
+		Estimate: estimate,
+	})
+}
+
+// owner returns the owner of the schedule. Schedules saved before owners were
+// kept only have the name of their creator, which ACL rules can still match.
+func (schedule *Schedule) owner() User {
+	if schedule.Owner != nil {
+		return *schedule.Owner
+	}
+	return User{Subject: schedule.CreatedBy, Email: schedule.CreatedBy}
+}
+
+// response is the schedule as returned by the API.
+func (schedule Schedule) response() Schedule {
+	schedule.Owner = nil
+	return schedule
+}
+
+func scheduleResult(runningSyncs *syncStorage, history *historyStorage, schedule *Schedule) ScheduleResult {
+	result := ScheduleResult{Schedule: schedule.response()}
+	if next, ok := nextRun(schedule); ok {
+		result.NextRun = &next
+	}
+
+	switch {
+	case schedule.LastError != "":
+		result.LastResult = schedule.LastError
+	case schedule.LastJobID != "":
+		runningSyncs.Lock()
+		for _, value := range runningSyncs.Data {
+			if value.ID == schedule.LastJobID {
+				result.LastResult = value.State
+			}
+		}
+		runningSyncs.Unlock()
+
+		if result.LastResult == "" {
+			if entry, ok := history.Get(schedule.LastJobID); ok {
+				result.LastResult = entry.Status
+			}
+		}
+	}
+	return result
+}
+
+func validateSchedule(ctx context.Context, remotes *remoteSet, dirs *dirCache, request *ScheduleRequest) (string, error) {
+	request.Path = strings.TrimSpace(request.Path)
+	request.Cron = strings.TrimSpace(request.Cron)
+
+	if _, err := cron.ParseStandard(request.Cron); err != nil {
+		return fmt.Sprintf("invalid cron expression: %s", err.Error()), nil
+	}
+
+	remote, remotePath, err := remotes.Resolve(request.Path)
+	if err != nil {
+		return err.Error(), nil
+	}
+	request.Path = remote.Address(remotePath)
+
+	if ok, err := dirs.HasRemotePath(ctx, remote, remotePath); err != nil {
+		return "", fmt.Errorf("list remote: %w", err)
+	} else if !ok {
+		return "invalid path", nil
+	}
+	return "", nil
+}
+
+func ListSchedules(runningSyncs *syncStorage, history *historyStorage, schedules *scheduleStorage) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		access := requestAccess(c)
+		schedules.Lock()
+		items := make([]Schedule, 0, len(schedules.data))
+		for _, schedule := range schedules.data {
+			if access.Allows(aclActionView, schedule.Path) {
+				items = append(items, *schedule)
+			}
+		}
+		schedules.Unlock()
+
+		result := Result[ScheduleResult]{
+			Error:   "",
+			Results: make([]ScheduleResult, 0, len(items)),
+		}
+		for _, item := range items {
+			result.Results = append(result.Results, scheduleResult(runningSyncs, history, &item))
+		}
+
+		return c.JSON(http.StatusOK, result)
+	}
+}
+
+func CreateSchedule(ctx context.Context, remotes *remoteSet, dirs *dirCache, schedules *scheduleStorage) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		request := &ScheduleRequest{}
+
+		err := c.Bind(request)
+		if err != nil {
+			return fmt.Errorf("load request: %w", err)
+		}
+
+		if message, err := validateSchedule(ctx, remotes, dirs, request); err != nil {
+			return err
+		} else if message != "" {
+			return c.JSON(http.StatusBadRequest, Result[string]{Error: message})
+		}
+		if ok, err := requestAccess(c).authorize(aclActionSync, request.Path); err != nil {
+			return err
+		} else if !ok {
+			return c.JSON(http.StatusBadRequest, Result[string]{Error: "invalid path"})
+		}
+
+		id, err := randomToken(9)
+		if err != nil {
+			return fmt.Errorf("generate schedule id: %w", err)
+		}
+
+		schedule := &Schedule{
+			ID:        id,
+			Path:      request.Path,
+			Cron:      request.Cron,
+			Enabled:   request.Enabled == nil || *request.Enabled,
+			Mirror:    request.Mirror != nil && *request.Mirror,
+			CreatedBy: requestActor(c),
+			Owner:     requestUser(c),
+			UpdatedAt: time.Now(),
+		}
+
+		schedules.Lock()
+		defer schedules.Unlock()
+
+		schedules.data = append(schedules.data, schedule)
+		if err := schedules.save(); err != nil {
+			return fmt.Errorf("save schedules: %w", err)
+		}
+
+		return c.JSON(http.StatusOK, Result[Schedule]{Results: []Schedule{schedule.response()}})
+	}
+}
+
+func UpdateSchedule(ctx context.Context, remotes *remoteSet, dirs *dirCache, schedules *scheduleStorage) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		request := &ScheduleRequest{}
+
+		err := c.Bind(request)
+		if err != nil {
+			return fmt.Errorf("load request: %w", err)
+		}
+
+		access := requestAccess(c)
+		schedules.Lock()
+		current, _ := schedules.find(c.Param("id"))
+		if current != nil && !access.Allows(aclActionView, current.Path) {
+			current = nil
+		}
+		if current != nil {
+			if request.Path == "" {
+				request.Path = current.Path
+			}
+			if request.Cron == "" {
+				request.Cron = current.Cron
+			}
+		}
+		schedules.Unlock()
+
+		if current == nil {
+			return c.JSON(http.StatusNotFound, Result[string]{Error: errScheduleNotFound.Error()})
+		}
+		if _, err := access.authorize(aclActionSync, current.Path); err != nil {
+			return err
+		}
+
+		if message, err := validateSchedule(ctx, remotes, dirs, request); err != nil {
+			return err
+		} else if message != "" {
+			return c.JSON(http.StatusBadRequest, Result[string]{Error: message})
+		}
+		if ok, err := access.authorize(aclActionSync, request.Path); err != nil {
+			return err
+		} else if !ok {
+			return c.JSON(http.StatusBadRequest, Result[string]{Error: "invalid path"})
+		}
+
+		schedules.Lock()
+		defer schedules.Unlock()
+
+		schedule, _ := schedules.find(c.Param("id"))
+		if schedule == nil {
+			return c.JSON(http.StatusNotFound, Result[string]{Error: errScheduleNotFound.Error()})
+		}
+
+		schedule.Path = request.Path
+		schedule.Cron = request.Cron
+		if request.Enabled != nil {
+			schedule.Enabled = *request.Enabled
+		}
+		if request.Mirror != nil {
+			schedule.Mirror = *request.Mirror
+		}
+		schedule.Owner = requestUser(c)
+		schedule.UpdatedAt = time.Now()
+
+		if err := schedules.save(); err != nil {
+			return fmt.Errorf("save schedules: %w", err)
+		}
+
+		return c.JSON(http.StatusOK, Result[Schedule]{Results: []Schedule{schedule.response()}})
+	}
+}
+
+func DeleteSchedule(schedules *scheduleStorage) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		schedules.Lock()
+		defer schedules.Unlock()
+
+		schedule, index := schedules.find(c.Param("id"))
+		if index < 0 {
+			return c.JSON(http.StatusNotFound, Result[string]{Error: errScheduleNotFound.Error()})
+		}
+		if ok, err := requestAccess(c).authorize(aclActionSync, schedule.Path); err != nil {
+			return err
+		} else if !ok {
+			return c.JSON(http.StatusNotFound, Result[string]{Error: errScheduleNotFound.Error()})
+		}
+
+		schedules.data = slices.Delete(schedules.data, index, index+1)
+		if err := schedules.save(); err != nil {
+			return fmt.Errorf("save schedules: %w", err)
+		}
+
+		return c.JSON(http.StatusOK, Result[string]{})
+	}
+}
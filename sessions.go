@@ -0,0 +1,357 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	s "sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	sessionIDContextKey = "auth.session"
+
+	// sessionSaveInterval is how often expired sessions are dropped and last
+	// seen times written to disk.
+	sessionSaveInterval = time.Minute
+)
+
+var errSessionNotFound = errors.New("session not found")
+
+// Session is a browser login. The cookie only carries its id, so it can be
+// revoked on the server before it expires.
+type Session struct {
+	ID         string    `json:"id"`
+	User       User      `json:"user"`
+	IP         string    `json:"ip"`
+	UserAgent  string    `json:"user_agent,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+	// ExpiresAt ends the session however active it is.
+	ExpiresAt time.Time `json:"expires_at"`
+	// RefreshToken is the encrypted OIDC refresh token, empty when the
+	// provider gave none.
+	RefreshToken string `json:"refresh_token,omitempty"`
+	// TokenExpiry is when the ID token runs out and gets refreshed.
+	TokenExpiry time.Time `json:"token_expiry"`
+	// IDToken is the raw ID token, handed back to the provider on logout.
+	IDToken string `json:"id_token,omitempty"`
+	// ProviderSession is the sid claim back-channel logouts refer to.
+	ProviderSession string `json:"provider_session,omitempty"`
+}
+
+type SessionResult struct {
+	ID         string    `json:"id"`
+	User       string    `json:"user"`
+	Subject    string    `json:"subject"`
+	Role       string    `json:"role"`
+	IP         string    `json:"ip"`
+	UserAgent  string    `json:"user_agent,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	// Current marks the session the request was made with.
+	Current bool `json:"current"`
+}
+
+// sessionStorage keeps the browser sessions in a JSON file. Last seen times
+// and addresses are tracked in memory and written every sessionSaveInterval.
+// Sessions not seen for idleTimeout end early, unless it is zero.
+type sessionStorage struct {
+	path        string
+	idleTimeout time.Duration
+	sessions    map[string]*Session
+	dirty       bool
+	s.Mutex
+}
+
+func newSessionStorage(path string, idleTimeout time.Duration) (*sessionStorage, error) {
+	st := &sessionStorage{
+		path:        path,
+		idleTimeout: idleTimeout,
+		sessions:    map[string]*Session{},
+	}
+	if err := readJSONFile(path, &st.sessions); err != nil {
+		return nil, fmt.Errorf("load sessions: %w", err)
+	}
+	return st, nil
+}
+
+func (session *Session) result() SessionResult {
+	name := session.User.Email
+	if name == "" {
+		name = session.User.Subject
+	}
+	return SessionResult{
+		ID:         session.ID,
+		User:       name,
+		Subject:    session.User.Subject,
+		Role:       session.User.Role,
+		IP:         session.IP,
+		UserAgent:  session.UserAgent,
+		CreatedAt:  session.CreatedAt,
+		LastSeenAt: session.LastSeenAt,
+		ExpiresAt:  session.ExpiresAt,
+	}
+}
+
+func (st *sessionStorage) expired(session *Session, now time.Time) bool {
+	if now.After(session.ExpiresAt) {
+		return true
+	}
+	return st.idleTimeout > 0 && now.Sub(session.LastSeenAt) > st.idleTimeout
+}
+
+// Create stores session under a new id and returns it.
+func (st *sessionStorage) Create(session Session) (Session, error) {
+	id, err := randomToken(32)
+	if err != nil {
+		return Session{}, fmt.Errorf("generate session id: %w", err)
+	}
+
+	now := time.Now()
+	session.ID = id
+	session.CreatedAt = now
+	session.LastSeenAt = now
+
+	st.Lock()
+	defer st.Unlock()
+
+	st.sessions[id] = &session
+	if err := writeJSONFile(st.path, st.sessions); err != nil {
+		delete(st.sessions, id)
+		return Session{}, fmt.Errorf("save sessions: %w", err)
+	}
+	st.dirty = false
+	return session, nil
+}
+
+// Get returns the session id if it has not expired.
+func (st *sessionStorage) Get(id string) (Session, error) {
+	st.Lock()
+	defer st.Unlock()
+
+	session, ok := st.sessions[id]
+	if !ok || st.expired(session, time.Now()) {
+		return Session{}, errSessionNotFound
+	}
+	return *session, nil
+}
+
+// Update replaces the user and tokens of the session id with those of
+// session.
+func (st *sessionStorage) Update(session Session) error {
+	st.Lock()
+	defer st.Unlock()
+
+	current, ok := st.sessions[session.ID]
+	if !ok {
+		return errSessionNotFound
+	}
+	previous := *current
+	current.User = session.User
+	current.RefreshToken = session.RefreshToken
+	current.TokenExpiry = session.TokenExpiry
+	current.IDToken = session.IDToken
+	if err := writeJSONFile(st.path, st.sessions); err != nil {
+		*current = previous
+		return fmt.Errorf("save sessions: %w", err)
+	}
+	st.dirty = false
+	return nil
+}
+
+// Touch returns the session id if it has not expired and records that it was
+// just seen from ip.
+func (st *sessionStorage) Touch(id, ip string) (Session, error) {
+	now := time.Now()
+
+	st.Lock()
+	defer st.Unlock()
+
+	session, ok := st.sessions[id]
+	if !ok || st.expired(session, now) {
+		return Session{}, errSessionNotFound
+	}
+	session.LastSeenAt = now
+	session.IP = ip
+	st.dirty = true
+	return *session, nil
+}
+
+// List returns the sessions that have not expired, most recently seen first.
+func (st *sessionStorage) List() []SessionResult {
+	now := time.Now()
+
+	st.Lock()
+	defer st.Unlock()
+
+	results := make([]SessionResult, 0, len(st.sessions))
+	for _, session := range st.sessions {
+		if !st.expired(session, now) {
+			results = append(results, session.result())
+		}
+	}
+	slices.SortFunc(results, func(a, b SessionResult) int {
+		return b.LastSeenAt.Compare(a.LastSeenAt)
+	})
+	return results
+}
+
+// Revoke ends the session id.
+func (st *sessionStorage) Revoke(id string) (Session, error) {
+	st.Lock()
+	defer st.Unlock()
+
+	session, ok := st.sessions[id]
+	if !ok {
+		return Session{}, errSessionNotFound
+	}
+	delete(st.sessions, id)
+	if err := writeJSONFile(st.path, st.sessions); err != nil {
+		st.sessions[id] = session
+		return Session{}, fmt.Errorf("save sessions: %w", err)
+	}
+	st.dirty = false
+	return *session, nil
+}
+
+// RevokeUser ends every session of the user with subject and returns how many
+// there were.
+func (st *sessionStorage) RevokeUser(subject string) (int, error) {
+	return st.revokeWhere(func(session *Session) bool {
+		return session.User.Subject == subject
+	})
+}
+
+// RevokeProviderSession ends the sessions a back-channel logout is about: the
+// ones of the provider session sid when given, otherwise all of subject.
+func (st *sessionStorage) RevokeProviderSession(subject, sid string) (int, error) {
+	return st.revokeWhere(func(session *Session) bool {
+		if sid != "" {
+			return session.ProviderSession == sid && (subject == "" || session.User.Subject == subject)
+		}
+		return session.User.Subject == subject
+	})
+}
+
+func (st *sessionStorage) revokeWhere(match func(session *Session) bool) (int, error) {
+	st.Lock()
+	defer st.Unlock()
+
+	revoked := map[string]*Session{}
+	for id, session := range st.sessions {
+		if match(session) {
+			revoked[id] = session
+			delete(st.sessions, id)
+		}
+	}
+	if len(revoked) == 0 {
+		return 0, nil
+	}
+	if err := writeJSONFile(st.path, st.sessions); err != nil {
+		for id, session := range revoked {
+			st.sessions[id] = session
+		}
+		return 0, fmt.Errorf("save sessions: %w", err)
+	}
+	st.dirty = false
+	return len(revoked), nil
+}
+
+// Run drops expired sessions and saves last seen times until ctx is done.
+func (st *sessionStorage) Run(ctx context.Context, logger *slog.Logger) {
+	ticker := time.NewTicker(sessionSaveInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			if err := st.save(); err != nil {
+				logger.Error("save sessions failed", slog.String("error", err.Error()))
+			}
+			return
+		case <-ticker.C:
+			if err := st.save(); err != nil {
+				logger.Error("save sessions failed", slog.String("error", err.Error()))
+			}
+		}
+	}
+}
+
+func (st *sessionStorage) save() error {
+	now := time.Now()
+
+	st.Lock()
+	defer st.Unlock()
+
+	for id, session := range st.sessions {
+		if st.expired(session, now) {
+			delete(st.sessions, id)
+			st.dirty = true
+		}
+	}
+	if !st.dirty {
+		return nil
+	}
+	if err := writeJSONFile(st.path, st.sessions); err != nil {
+		return err
+	}
+	st.dirty = false
+	return nil
+}
+
+func ListSessions(sessions *sessionStorage) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		current, _ := c.Get(sessionIDContextKey).(string)
+		results := sessions.List()
+		for i := range results {
+			results[i].Current = results[i].ID == current
+		}
+		return c.JSON(http.StatusOK, Result[SessionResult]{Results: results, Total: len(results)})
+	}
+}
+
+func RevokeSession(logger *slog.Logger, sessions *sessionStorage) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		session, err := sessions.Revoke(c.Param("id"))
+		if errors.Is(err, errSessionNotFound) {
+			return c.JSON(http.StatusNotFound, Result[string]{Error: err.Error()})
+		} else if err != nil {
+			return err
+		}
+		logger.Info("session revoked",
+			slog.String("user", session.result().User),
+			slog.String("ip", session.IP),
+			slog.String("by", requestActor(c)),
+		)
+		return c.JSON(http.StatusOK, Result[string]{})
+	}
+}
+
+// RevokeUserSessions logs the user with the subject query parameter out
+// everywhere.
+func RevokeUserSessions(logger *slog.Logger, sessions *sessionStorage) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		subject := strings.TrimSpace(c.QueryParam("subject"))
+		if subject == "" {
+			return c.JSON(http.StatusBadRequest, Result[string]{Error: "subject must be specified"})
+		}
+		revoked, err := sessions.RevokeUser(subject)
+		if err != nil {
+			return err
+		}
+		logger.Info("user sessions revoked",
+			slog.String("subject", subject),
+			slog.Int("sessions", revoked),
+			slog.String("by", requestActor(c)),
+		)
+		return c.JSON(http.StatusOK, Result[string]{Total: revoked})
+	}
+}
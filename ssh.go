@@ -0,0 +1,303 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	s "sync"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+const (
+	sshDialTimeout = 30 * time.Second
+
+	listModeSFTP    = "sftp"
+	listModeCommand = "command"
+
+	defaultListMaxDepth = 10
+)
+
+// dialSSH connects to remote with keyFile, checking the host key against the
+// remote's known hosts file. Network and handshake failures are wrapped in
+// errTransferInterrupted, a rejected host key is not.
+func dialSSH(ctx context.Context, remote *Remote, keyFile string) (*ssh.Client, error) {
+	key, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("read ssh key: %w", err)
+	}
+	signer, err := ssh.ParsePrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("parse ssh key: %w", err)
+	}
+	hostKeys, err := knownhosts.New(remote.KnownHosts)
+	if err != nil {
+		return nil, fmt.Errorf("read known hosts: %w", err)
+	}
+
+	addr := net.JoinHostPort(remote.Host, strconv.Itoa(int(remote.Port)))
+	dialer := net.Dialer{Timeout: sshDialTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("%w: dial: %w", errTransferInterrupted, err)
+	}
+
+	conn.SetDeadline(time.Now().Add(sshDialTimeout))
+	sshConn, chans, reqs, err := ssh.NewClientConn(conn, addr, &ssh.ClientConfig{
+		User:            remote.User,
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+		HostKeyCallback: hostKeys,
+	})
+	if err != nil {
+		conn.Close()
+		var keyErr *knownhosts.KeyError
+		if errors.As(err, &keyErr) {
+			return nil, fmt.Errorf("ssh handshake: %w", err)
+		}
+		return nil, fmt.Errorf("%w: ssh handshake: %w", errTransferInterrupted, err)
+	}
+	conn.SetDeadline(time.Time{})
+
+	return ssh.NewClient(sshConn, chans, reqs), nil
+}
+
+// listedDir is a directory found by a remote listing. Stats is nil when the
+// listing did not look inside.
+type listedDir struct {
+	Path  string
+	Stats *DirStats
+	// Unreadable directories are listed without stats or children.
+	Unreadable bool
+}
+
+// remoteLister lists the directories of a remote over a single pooled ssh
+// connection made with the ls key. The connection is dialed on first use and
+// dropped when it dies or a listing is cancelled half way.
+type remoteLister struct {
+	remote *Remote
+	client *ssh.Client
+	sftp   *sftp.Client
+	s.Mutex
+}
+
+func (l *remoteLister) connect(ctx context.Context) (*ssh.Client, *sftp.Client, error) {
+	l.Lock()
+	defer l.Unlock()
+
+	if l.client != nil {
+		return l.client, l.sftp, nil
+	}
+
+	client, err := dialSSH(ctx, l.remote, l.remote.LsSSHKey)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var sftpClient *sftp.Client
+	if l.remote.ListMode == listModeSFTP {
+		sftpClient, err = sftp.NewClient(client)
+		if err != nil {
+			client.Close()
+			return nil, nil, fmt.Errorf("start sftp: %w", err)
+		}
+	}
+
+	l.client, l.sftp = client, sftpClient
+	go func() {
+		client.Wait()
+		l.drop(client)
+	}()
+	return client, sftpClient, nil
+}
+
+// drop forgets client if it is still the pooled connection and closes it.
+func (l *remoteLister) drop(client *ssh.Client) {
+	l.Lock()
+	if l.client == client {
+		if l.sftp != nil {
+			l.sftp.Close()
+		}
+		l.client, l.sftp = nil, nil
+	}
+	l.Unlock()
+	client.Close()
+}
+
+func (l *remoteLister) Close() {
+	l.Lock()
+	client := l.client
+	l.Unlock()
+	if client != nil {
+		l.drop(client)
+	}
+}
+
+// List returns the directories of the remote relative to its base path,
+// parents before children.
+func (l *remoteLister) List(logger *slog.Logger, ctx context.Context) ([]listedDir, error) {
+	client, sftpClient, err := l.connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// A listing that is cancelled half way leaves the connection in an unknown
+	// state, so do not hand it out again.
+	stop := context.AfterFunc(ctx, func() {
+		l.drop(client)
+	})
+	defer stop()
+
+	if l.remote.ListMode == listModeCommand {
+		return l.listCommand(logger, client)
+	}
+	return l.listSFTP(logger, sftpClient)
+}
+
+// unreadableDirError reports whether err stands for a directory that is off
+// limits or gone, as opposed to a failing connection.
+func unreadableDirError(err error) bool {
+	return errors.Is(err, fs.ErrPermission) || errors.Is(err, fs.ErrNotExist)
+}
+
+// listSFTP walks the base path breadth first down to the configured depth,
+// counting the files of every directory it reads. The deepest directories are
+// listed without stats, and so are those that cannot be read or vanished
+// meanwhile, which are skipped rather than failing the whole listing.
+func (l *remoteLister) listSFTP(logger *slog.Logger, client *sftp.Client) ([]listedDir, error) {
+	root := l.remote.BasePath
+	if root == "" {
+		root = "/"
+	}
+
+	var dirs []*listedDir
+	level := []*listedDir{{Path: "/"}}
+	for depth := 1; len(level) > 0 && (l.remote.ListMaxDepth <= 0 || depth <= l.remote.ListMaxDepth); depth++ {
+		var next []*listedDir
+		for _, dir := range level {
+			entries, err := client.ReadDir(path.Join(root, dir.Path))
+			if err != nil && (dir.Path == "/" || !unreadableDirError(err)) {
+				return nil, fmt.Errorf("read dir %s: %w", dir.Path, err)
+			} else if err != nil {
+				logger.Warn("skip unreadable remote dir", slog.String("remote", l.remote.Name), slog.String("path", dir.Path), slog.String("error", err.Error()))
+				dir.Unreadable = true
+				continue
+			}
+			dir.Stats = &DirStats{}
+			for _, entry := range entries {
+				if entry.Mode().IsRegular() {
+					dir.Stats.add(entry)
+				}
+				if !entry.IsDir() {
+					continue
+				}
+				child := &listedDir{Path: path.Join(dir.Path, entry.Name())}
+				dirs = append(dirs, child)
+				next = append(next, child)
+			}
+		}
+		level = next
+	}
+
+	listed := make([]listedDir, 0, len(dirs))
+	for _, dir := range dirs {
+		listed = append(listed, *dir)
+	}
+	return listed, nil
+}
+
+// listCommand reads the directory paths printed by a forced command set up for
+// the ls key on the server. A line may follow the path with tab separated file
+// count, total bytes and newest modification time in unix seconds for the files
+// directly in it, which is then compared like an SFTP listing.
+func (l *remoteLister) listCommand(logger *slog.Logger, client *ssh.Client) ([]listedDir, error) {
+	session, err := client.NewSession()
+	if err != nil {
+		return nil, fmt.Errorf("new session: %w", err)
+	}
+	defer session.Close()
+
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		return nil, fmt.Errorf("get stdout pipe: %w", err)
+	}
+
+	stderr, err := session.StderrPipe()
+	if err != nil {
+		return nil, fmt.Errorf("get stderr pipe: %w", err)
+	}
+
+	go func() {
+		scanner := bufio.NewScanner(stderr)
+		for scanner.Scan() {
+			logger.Info(scanner.Text())
+		}
+	}()
+
+	if err := session.Shell(); err != nil {
+		return nil, fmt.Errorf("start command: %w", err)
+	}
+
+	var dirs []listedDir
+	scanner := bufio.NewScanner(stdout)
+	for scanner.Scan() {
+		path, stats, err := parseListLine(scanner.Text())
+		if err != nil {
+			logger.Warn("skip listing line", slog.String("line", scanner.Text()), slog.String("error", err.Error()))
+			continue
+		}
+		if l.remote.BasePath != "" {
+			// The listing prints host paths, keep the ones below the base path.
+			rel, ok := strings.CutPrefix(path, l.remote.BasePath)
+			if !ok || (rel != "" && !strings.HasPrefix(rel, "/")) {
+				continue
+			}
+			path = "/" + strings.TrimPrefix(rel, "/")
+		}
+		dirs = append(dirs, listedDir{Path: path, Stats: stats})
+	}
+
+	if err := session.Wait(); err != nil {
+		return nil, fmt.Errorf("wait command: %w", err)
+	}
+	return dirs, nil
+}
+
+func parseListLine(line string) (string, *DirStats, error) {
+	fields := strings.Split(line, "\t")
+	if len(fields) == 1 {
+		return line, nil, nil
+	}
+	if len(fields) != 4 {
+		return "", nil, fmt.Errorf("want 1 or 4 fields, got %d", len(fields))
+	}
+
+	files, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return "", nil, fmt.Errorf("parse file count: %w", err)
+	}
+	bytes, err := strconv.ParseUint(fields[2], 10, 64)
+	if err != nil {
+		return "", nil, fmt.Errorf("parse bytes: %w", err)
+	}
+	modified, err := strconv.ParseInt(fields[3], 10, 64)
+	if err != nil {
+		return "", nil, fmt.Errorf("parse modification time: %w", err)
+	}
+
+	stats := &DirStats{Files: files, Bytes: bytes}
+	if files > 0 {
+		stats.Modified = time.Unix(modified, 0)
+	}
+	return fields[0], stats, nil
+}
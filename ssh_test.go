@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"testing"
+	"time"
+)
+
+func TestParseListLine(t *testing.T) {
+	tests := []struct {
+		name      string
+		line      string
+		wantPath  string
+		wantStats *DirStats
+		wantErr   bool
+	}{
+		{
+			name:     "path only",
+			line:     "/data/photos",
+			wantPath: "/data/photos",
+		},
+		{
+			name:      "with stats",
+			line:      "/data/photos\t3\t1024\t1700000000",
+			wantPath:  "/data/photos",
+			wantStats: &DirStats{Files: 3, Bytes: 1024, Modified: time.Unix(1700000000, 0)},
+		},
+		{
+			name:      "empty dir has no modification time",
+			line:      "/data/empty\t0\t0\t1700000000",
+			wantPath:  "/data/empty",
+			wantStats: &DirStats{},
+		},
+		{
+			name:     "path with spaces",
+			line:     "/data/my photos\t1\t2\t3",
+			wantPath: "/data/my photos",
+			wantStats: &DirStats{
+				Files: 1, Bytes: 2, Modified: time.Unix(3, 0),
+			},
+		},
+		{
+			name:    "missing fields",
+			line:    "/data\t1\t2",
+			wantErr: true,
+		},
+		{
+			name:    "bad file count",
+			line:    "/data\tx\t2\t3",
+			wantErr: true,
+		},
+		{
+			name:    "negative bytes",
+			line:    "/data\t1\t-2\t3",
+			wantErr: true,
+		},
+		{
+			name:    "bad modification time",
+			line:    "/data\t1\t2\tyesterday",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path, stats, err := parseListLine(tt.line)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseListLine(%q) error = %v, want error %v", tt.line, err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if path != tt.wantPath {
+				t.Errorf("path = %q, want %q", path, tt.wantPath)
+			}
+			switch {
+			case stats == nil && tt.wantStats == nil:
+			case stats == nil || tt.wantStats == nil:
+				t.Errorf("stats = %+v, want %+v", stats, tt.wantStats)
+			case stats.Files != tt.wantStats.Files || stats.Bytes != tt.wantStats.Bytes || !stats.Modified.Equal(tt.wantStats.Modified):
+				t.Errorf("stats = %+v, want %+v", *stats, *tt.wantStats)
+			}
+		})
+	}
+}
+
+func TestUnreadableDirError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "permission denied", err: fs.ErrPermission, want: true},
+		{name: "vanished", err: fs.ErrNotExist, want: true},
+		{name: "wrapped", err: fmt.Errorf("open dir: %w", fs.ErrPermission), want: true},
+		{name: "connection lost", err: io.ErrUnexpectedEOF, want: false},
+		{name: "other", err: fmt.Errorf("i/o error"), want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := unreadableDirError(tt.err); got != tt.want {
+				t.Errorf("unreadableDirError(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// stateDirName is the directory in the user config dir that state files go to
+// when the data path is a local copy itself.
+const stateDirName = "syncer"
+
+// prepareStatePath works out where history, schedules, sessions and the other
+// state files are kept and creates it. It must not be inside the local path of
+// any remote, where syncs, the local tree and its stats would pick them up.
+// Unless set, that is the data path, or the user config dir when a remote
+// syncs right into the data path.
+func prepareStatePath(config Config, remotes *remoteSet) (string, error) {
+	statePath := config.StatePath
+	if statePath == "" {
+		statePath = config.DataPath
+		if remotes.Holding(config, statePath) != nil {
+			dir, err := os.UserConfigDir()
+			if err != nil {
+				return "", fmt.Errorf("state path must be specified: %w", err)
+			}
+			statePath = filepath.Join(dir, stateDirName)
+		}
+	}
+	if remote := remotes.Holding(config, statePath); remote != nil {
+		return "", fmt.Errorf("state path %s must not be inside the local path of remote %q", statePath, remote.Name)
+	}
+	if err := os.MkdirAll(statePath, 0755); err != nil {
+		return "", fmt.Errorf("create state path: %w", err)
+	}
+	return statePath, nil
+}
@@ -0,0 +1,341 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	s "sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	tokenScopeRead    = "read"
+	tokenScopeOperate = "operate"
+
+	tokenSecretPrefix = "syncer_"
+	maxTokenName      = 64
+)
+
+var (
+	errTokenNotFound = errors.New("token not found")
+	errInvalidToken  = errors.New("invalid or expired token")
+)
+
+// tokenScopeRoles caps the role a token acts with, whatever its owner may do.
+var tokenScopeRoles = map[string]string{
+	tokenScopeRead:    roleViewer,
+	tokenScopeOperate: roleOperator,
+}
+
+// APIToken lets scripts call the API as their owner. Only a hash of the
+// secret is kept, the secret itself is shown once when the token is created.
+// The owner is updated whenever they log in, and their role is worked out
+// again every time the token is used.
+type APIToken struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	Owner      User       `json:"owner"`
+	Scope      string     `json:"scope"`
+	Hash       string     `json:"hash"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+}
+
+type TokenRequest struct {
+	Name          string `json:"name"`
+	Scope         string `json:"scope"`
+	ExpiresInDays int    `json:"expires_in_days,omitempty"`
+}
+
+type TokenResult struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	Owner      string     `json:"owner"`
+	Scope      string     `json:"scope"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	Expired    bool       `json:"expired"`
+	// Token is the secret, only returned when the token is created.
+	Token string `json:"token,omitempty"`
+}
+
+// tokenStorage keeps the API tokens in a JSON file. When a token was last used
+// is only tracked in memory and written along with the next change.
+type tokenStorage struct {
+	path   string
+	tokens []*APIToken
+	s.Mutex
+}
+
+func newTokenStorage(path string) (*tokenStorage, error) {
+	st := &tokenStorage{
+		path:   path,
+		tokens: []*APIToken{},
+	}
+	if err := readJSONFile(path, &st.tokens); err != nil {
+		return nil, fmt.Errorf("load tokens: %w", err)
+	}
+	return st, nil
+}
+
+func hashToken(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+func (t *APIToken) expired(now time.Time) bool {
+	return t.ExpiresAt != nil && now.After(*t.ExpiresAt)
+}
+
+func (t *APIToken) result(now time.Time) TokenResult {
+	owner := t.Owner.Email
+	if owner == "" {
+		owner = t.Owner.Subject
+	}
+	return TokenResult{
+		ID:         t.ID,
+		Name:       t.Name,
+		Owner:      owner,
+		Scope:      t.Scope,
+		CreatedAt:  t.CreatedAt,
+		ExpiresAt:  t.ExpiresAt,
+		LastUsedAt: t.LastUsedAt,
+		Expired:    t.expired(now),
+	}
+}
+
+// Create adds a token for owner and returns it along with its secret.
+func (st *tokenStorage) Create(owner User, request TokenRequest) (TokenResult, error) {
+	id, err := randomToken(9)
+	if err != nil {
+		return TokenResult{}, fmt.Errorf("generate token id: %w", err)
+	}
+	secret, err := randomToken(32)
+	if err != nil {
+		return TokenResult{}, fmt.Errorf("generate token secret: %w", err)
+	}
+	secret = tokenSecretPrefix + secret
+
+	now := time.Now()
+	token := &APIToken{
+		ID:        id,
+		Name:      request.Name,
+		Owner:     owner,
+		Scope:     request.Scope,
+		Hash:      hashToken(secret),
+		CreatedAt: now,
+	}
+	if request.ExpiresInDays > 0 {
+		expiresAt := now.AddDate(0, 0, request.ExpiresInDays)
+		token.ExpiresAt = &expiresAt
+	}
+
+	st.Lock()
+	defer st.Unlock()
+
+	st.tokens = append(st.tokens, token)
+	if err := writeJSONFile(st.path, st.tokens); err != nil {
+		st.tokens = st.tokens[:len(st.tokens)-1]
+		return TokenResult{}, fmt.Errorf("save tokens: %w", err)
+	}
+
+	result := token.result(now)
+	result.Token = secret
+	return result, nil
+}
+
+// Authenticate finds the token with secret.
+func (st *tokenStorage) Authenticate(secret string) (APIToken, error) {
+	hash := hashToken(secret)
+	now := time.Now()
+
+	st.Lock()
+	defer st.Unlock()
+
+	for _, token := range st.tokens {
+		if subtle.ConstantTimeCompare([]byte(token.Hash), []byte(hash)) != 1 {
+			continue
+		}
+		if token.expired(now) {
+			return APIToken{}, errInvalidToken
+		}
+		token.LastUsedAt = &now
+		return *token, nil
+	}
+	return APIToken{}, errInvalidToken
+}
+
+// UpdateOwner replaces the owner of the tokens of user, whose email or groups
+// may have changed at the provider since the tokens were created.
+func (st *tokenStorage) UpdateOwner(user User) error {
+	st.Lock()
+	defer st.Unlock()
+
+	previous := map[string]User{}
+	for _, token := range st.tokens {
+		if token.Owner.Subject == user.Subject && !sameUser(token.Owner, user) {
+			previous[token.ID] = token.Owner
+			token.Owner = user
+		}
+	}
+	if len(previous) == 0 {
+		return nil
+	}
+	if err := writeJSONFile(st.path, st.tokens); err != nil {
+		for _, token := range st.tokens {
+			if owner, ok := previous[token.ID]; ok {
+				token.Owner = owner
+			}
+		}
+		return fmt.Errorf("save tokens: %w", err)
+	}
+	return nil
+}
+
+func sameUser(a, b User) bool {
+	return a.Subject == b.Subject && a.Email == b.Email && a.Name == b.Name &&
+		a.EmailVerified == b.EmailVerified && a.Role == b.Role && slices.Equal(a.Groups, b.Groups)
+}
+
+// List returns the tokens of user, or every token for admins.
+func (st *tokenStorage) List(user User) []TokenResult {
+	now := time.Now()
+
+	st.Lock()
+	defer st.Unlock()
+
+	results := make([]TokenResult, 0)
+	for _, token := range st.tokens {
+		if user.Role == roleAdmin || token.Owner.Subject == user.Subject {
+			results = append(results, token.result(now))
+		}
+	}
+	return results
+}
+
+// Revoke deletes the token id. Users can only revoke their own tokens, admins
+// any of them.
+func (st *tokenStorage) Revoke(user User, id string) (TokenResult, error) {
+	st.Lock()
+	defer st.Unlock()
+
+	index := slices.IndexFunc(st.tokens, func(token *APIToken) bool {
+		return token.ID == id && (user.Role == roleAdmin || token.Owner.Subject == user.Subject)
+	})
+	if index < 0 {
+		return TokenResult{}, errTokenNotFound
+	}
+
+	token := st.tokens[index]
+	st.tokens = slices.Delete(st.tokens, index, index+1)
+	if err := writeJSONFile(st.path, st.tokens); err != nil {
+		st.tokens = slices.Insert(st.tokens, index, token)
+		return TokenResult{}, fmt.Errorf("save tokens: %w", err)
+	}
+	return token.result(time.Now()), nil
+}
+
+// tokenUser is who a request made with token acts as: its owner with the role
+// roles gives them now, limited to the scope of the token.
+func tokenUser(token APIToken, roles roleMapping) User {
+	user := token.Owner
+	user.Token = token.ID
+	user.Role = roles.Current(user)
+	if scopeRole := tokenScopeRoles[token.Scope]; roleRank[scopeRole] < roleRank[user.Role] {
+		user.Role = scopeRole
+	}
+	return user
+}
+
+// sessionUser returns the user of a request made with a browser session.
+// Tokens cannot be used to manage tokens, or a leaked one could mint more.
+func sessionUser(c echo.Context) (User, error) {
+	user, ok := c.Get(sessionContextKey).(User)
+	if !ok {
+		return User{}, echo.ErrUnauthorized
+	}
+	if user.Token != "" {
+		return User{}, fmt.Errorf("%w: api tokens cannot manage tokens", errForbidden)
+	}
+	return user, nil
+}
+
+func ListTokens(tokens *tokenStorage) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		user, err := sessionUser(c)
+		if err != nil {
+			return err
+		}
+		results := tokens.List(user)
+		return c.JSON(http.StatusOK, Result[TokenResult]{Results: results, Total: len(results)})
+	}
+}
+
+func CreateToken(logger *slog.Logger, tokens *tokenStorage) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		user, err := sessionUser(c)
+		if err != nil {
+			return err
+		}
+
+		request := &TokenRequest{}
+		if err := c.Bind(request); err != nil {
+			return fmt.Errorf("load request: %w", err)
+		}
+		request.Name = strings.TrimSpace(request.Name)
+		if request.Name == "" || len(request.Name) > maxTokenName {
+			return c.JSON(http.StatusBadRequest, Result[string]{Error: fmt.Sprintf("token name must be 1 to %d characters", maxTokenName)})
+		}
+		if _, ok := tokenScopeRoles[request.Scope]; !ok {
+			return c.JSON(http.StatusBadRequest, Result[string]{Error: fmt.Sprintf("token scope must be %s or %s", tokenScopeRead, tokenScopeOperate)})
+		}
+		if request.ExpiresInDays < 0 {
+			return c.JSON(http.StatusBadRequest, Result[string]{Error: "token expiry must not be negative"})
+		}
+
+		result, err := tokens.Create(user, *request)
+		if err != nil {
+			return err
+		}
+		logger.Info("api token created",
+			slog.String("id", result.ID),
+			slog.String("name", result.Name),
+			slog.String("scope", result.Scope),
+			slog.String("owner", result.Owner),
+		)
+		return c.JSON(http.StatusOK, Result[TokenResult]{Results: []TokenResult{result}})
+	}
+}
+
+func RevokeToken(logger *slog.Logger, tokens *tokenStorage) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		user, err := sessionUser(c)
+		if err != nil {
+			return err
+		}
+
+		result, err := tokens.Revoke(user, c.Param("id"))
+		if errors.Is(err, errTokenNotFound) {
+			return c.JSON(http.StatusNotFound, Result[string]{Error: err.Error()})
+		} else if err != nil {
+			return err
+		}
+		logger.Info("api token revoked",
+			slog.String("id", result.ID),
+			slog.String("name", result.Name),
+			slog.String("owner", result.Owner),
+			slog.String("by", requestActor(c)),
+		)
+		return c.JSON(http.StatusOK, Result[string]{})
+	}
+}
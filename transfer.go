@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+const (
+	transferBackendRsync = "rsync"
+	transferBackendSFTP  = "sftp"
+	transferBackendFake  = "fake"
+)
+
+// TransferOptions describe a single attempt at copying a remote path. Like
+// rsync, a transfer creates the last element of RemotePath inside LocalDir.
+type TransferOptions struct {
+	RemotePath string
+	LocalDir   string
+	BwLimit    uint
+	Filter     []string
+	Mirror     bool
+	// Output receives diagnostic lines, such as rsync's stderr.
+	Output func(line string)
+}
+
+type TransferProgress struct {
+	Progress   uint
+	Speed      uint
+	Downloaded uint
+	TimeLeft   string
+}
+
+type TransferResult struct {
+	Deleted []string
+}
+
+// Transfer is a copy started by a TransferBackend.
+type Transfer interface {
+	// Progress delivers updates while the transfer runs and is closed once it
+	// ends.
+	Progress() <-chan TransferProgress
+	// Cancel asks the transfer to stop. Wait still reports how it ended.
+	Cancel()
+	// Wait blocks until the transfer has ended.
+	Wait() (TransferResult, error)
+}
+
+type TransferBackend interface {
+	Start(logger *slog.Logger, options TransferOptions) (Transfer, error)
+	// Preview reports what Start would change without changing anything.
+	Preview(logger *slog.Logger, ctx context.Context, options TransferOptions) (SyncPreview, error)
+}
+
+func newTransferBackend(remote *Remote) (TransferBackend, error) {
+	switch remote.TransferBackend {
+	case "", transferBackendRsync:
+		return &rsyncBackend{remote: remote}, nil
+	case transferBackendSFTP:
+		return &sftpBackend{remote: remote}, nil
+	case transferBackendFake:
+		return &fakeBackend{}, nil
+	}
+	return nil, fmt.Errorf("unknown transfer backend %q", remote.TransferBackend)
+}
+
+// runTransfer performs a single transfer attempt limited to bwLimit KiB/s, or
+// unlimited when it is 0. Cancelling ctx cancels the transfer.
+func runTransfer(logger *slog.Logger, ctx context.Context, config Config, runningSyncs *syncStorage, currentSync *Sync, syncPath string, bwLimit uint) error {
+	transfer, err := currentSync.Remote.backend.Start(logger, TransferOptions{
+		RemotePath: currentSync.Remote.HostPath(currentSync.RemotePath),
+		LocalDir:   syncPath,
+		BwLimit:    bwLimit,
+		Filter:     currentSync.Filter,
+		Mirror:     currentSync.Mirror,
+		Output: func(line string) {
+			logger.Info(line)
+			currentSync.Stderr.Add(line)
+		},
+	})
+	if err != nil {
+		logger.Error("start transfer", slog.String("path", currentSync.Path), slog.String("error", err.Error()))
+		return fmt.Errorf("start transfer: %w", err)
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+
+	go func() {
+		select {
+		case <-done:
+		case <-ctx.Done():
+			transfer.Cancel()
+		}
+	}()
+
+	var published time.Time
+	for progress := range transfer.Progress() {
+		runningSyncs.Lock()
+		currentSync.Progress = progress.Progress
+		currentSync.Speed = progress.Speed
+		currentSync.Downloaded = progress.Downloaded
+		currentSync.TimeLeft = progress.TimeLeft
+		if time.Since(published) >= progressEventInterval {
+			runningSyncs.publish(Event{Type: eventProgress, Sync: runningSyncs.resultPtr(currentSync, config)})
+			published = time.Now()
+		}
+		runningSyncs.Unlock()
+	}
+
+	result, err := transfer.Wait()
+	if len(result.Deleted) > 0 {
+		runningSyncs.Lock()
+		addDeletions(currentSync, result.Deleted)
+		runningSyncs.Unlock()
+	}
+	if err != nil {
+		logger.Error("transfer failed", slog.String("path", currentSync.Path), slog.String("error", err.Error()))
+		return err
+	}
+	return nil
+}
+
+// formatTimeLeft renders an estimate the way rsync's progress output does.
+func formatTimeLeft(d time.Duration) string {
+	seconds := int(d.Round(time.Second).Seconds())
+	return fmt.Sprintf("%d:%02d:%02d", seconds/3600, seconds/60%60, seconds%60)
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"path"
+	s "sync"
+	"time"
+)
+
+const (
+	fakeTransferSize     = 100 * 1024 * 1024
+	fakeTransferDuration = 10 * time.Second
+)
+
+// fakeBackend pretends to copy fakeTransferSize bytes without touching the
+// network or the disk, so the queue, retries, events and history can be tried
+// out without a remote. It honours the bandwidth limit and cancellation.
+type fakeBackend struct {
+	// duration replaces fakeTransferDuration when set.
+	duration time.Duration
+	// failures is how many transfers still drop halfway through with
+	// errTransferInterrupted before they start to succeed.
+	failures int
+	s.Mutex
+}
+
+type fakeTransfer struct {
+	cancel   context.CancelFunc
+	progress chan TransferProgress
+	done     chan struct{}
+	err      error
+}
+
+func (b *fakeBackend) Start(logger *slog.Logger, options TransferOptions) (Transfer, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	transfer := &fakeTransfer{
+		cancel:   cancel,
+		progress: make(chan TransferProgress),
+		done:     make(chan struct{}),
+	}
+
+	b.Lock()
+	duration := fakeTransferDuration
+	if b.duration > 0 {
+		duration = b.duration
+	}
+	fail := b.failures > 0
+	if fail {
+		b.failures--
+	}
+	b.Unlock()
+
+	if options.BwLimit > 0 {
+		duration = max(duration, time.Duration(fakeTransferSize/(options.BwLimit*1024))*time.Second)
+	}
+	speed := uint(fakeTransferSize / duration.Seconds())
+
+	logger.Debug("fake transfer", slog.String("path", options.RemotePath), slog.Duration("duration", duration))
+
+	go func() {
+		defer close(transfer.done)
+		defer cancel()
+		defer close(transfer.progress)
+
+		started := time.Now()
+		ticker := time.NewTicker(progressEventInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				transfer.err = ctx.Err()
+				return
+			case now := <-ticker.C:
+				elapsed := min(now.Sub(started), duration)
+				progress := TransferProgress{
+					Progress:   uint(elapsed * 100 / duration),
+					Speed:      speed,
+					Downloaded: uint(float64(fakeTransferSize) * elapsed.Seconds() / duration.Seconds()),
+					TimeLeft:   formatTimeLeft(duration - elapsed),
+				}
+				select {
+				case transfer.progress <- progress:
+				case <-ctx.Done():
+				}
+				if fail && elapsed >= duration/2 {
+					transfer.err = fmt.Errorf("fake transfer: %w", errTransferInterrupted)
+					return
+				}
+				if elapsed == duration {
+					return
+				}
+			}
+		}
+	}()
+
+	return transfer, nil
+}
+
+func (t *fakeTransfer) Progress() <-chan TransferProgress {
+	return t.progress
+}
+
+func (t *fakeTransfer) Cancel() {
+	t.cancel()
+}
+
+func (t *fakeTransfer) Wait() (TransferResult, error) {
+	<-t.done
+	return TransferResult{}, t.err
+}
+
+// Preview reports the single file the fake transfer pretends to copy.
+func (b *fakeBackend) Preview(logger *slog.Logger, ctx context.Context, options TransferOptions) (SyncPreview, error) {
+	preview := newSyncPreview(options.RemotePath)
+	preview.add(PreviewItem{
+		Path:   path.Base(options.RemotePath) + "/fake.bin",
+		Change: previewChangeNew,
+		Size:   fakeTransferSize,
+	})
+	return preview, nil
+}
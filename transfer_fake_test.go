@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+)
+
+const fakeTestTimeout = 10 * time.Second
+
+// newFakeSyncStorage returns a sync storage with a single remote named fake
+// that transfers with backend.
+func newFakeSyncStorage(t *testing.T, backend *fakeBackend) (Config, *syncStorage, *historyStorage) {
+	t.Helper()
+
+	config := Config{
+		DataPath:          t.TempDir(),
+		SyncMaxAttempts:   3,
+		SyncRetryDelay:    10 * time.Millisecond,
+		SyncRetryMaxDelay: 20 * time.Millisecond,
+	}
+	remote := &Remote{Name: "fake", LocalPath: "fake", TransferBackend: transferBackendFake, backend: backend}
+	remotes := &remoteSet{list: []*Remote{remote}, byName: map[string]*Remote{remote.Name: remote}}
+
+	history, err := newHistoryStorage(t.TempDir()+"/history.json", 10)
+	if err != nil {
+		t.Fatalf("newHistoryStorage() error = %v", err)
+	}
+	runningSyncs := &syncStorage{
+		Data:    map[string]*Sync{},
+		Events:  newEventBus(),
+		Remotes: remotes,
+		Disk:    newDiskMonitor(slog.New(slog.NewTextHandler(io.Discard, nil)), config, remotes),
+	}
+	return config, runningSyncs, history
+}
+
+// waitForEvent returns the first event of type eventType on events.
+func waitForEvent(t *testing.T, events chan Event, eventType string) Event {
+	t.Helper()
+
+	timeout := time.After(fakeTestTimeout)
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				t.Fatalf("event stream closed waiting for %s", eventType)
+			}
+			if event.Type == eventType {
+				return event
+			}
+		case <-timeout:
+			t.Fatalf("timed out waiting for %s", eventType)
+		}
+	}
+}
+
+func TestFakeSyncLifecycle(t *testing.T) {
+	tests := []struct {
+		name      string
+		failures  int
+		cancel    bool
+		event     string
+		status    string
+		attempts  int
+		cancelled string
+	}{
+		{name: "finish", event: eventFinish, status: syncStatusFinished, attempts: 1},
+		{name: "retry", failures: 1, event: eventFinish, status: syncStatusFinished, attempts: 2},
+		{name: "give up", failures: 3, event: eventFailure, status: syncStatusFailed, attempts: 3},
+		{name: "cancel", cancel: true, event: eventFailure, status: syncStatusCancelled, attempts: 1, cancelled: "tester"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+			duration := 100 * time.Millisecond
+			if tt.cancel {
+				duration = time.Minute
+			}
+			config, runningSyncs, history := newFakeSyncStorage(t, &fakeBackend{duration: duration, failures: tt.failures})
+
+			events := runningSyncs.Events.Subscribe(true)
+			defer runningSyncs.Events.Unsubscribe(events)
+
+			result, err := sync(logger, context.Background(), config, runningSyncs, history, "fake:/data", "tester", syncOptions{})
+			if err != nil {
+				t.Fatalf("sync() error = %v", err)
+			}
+			if start := waitForEvent(t, events, eventStart); start.Sync == nil || start.Sync.ID != result.ID {
+				t.Fatalf("start event = %+v, want sync %s", start.Sync, result.ID)
+			}
+
+			if tt.cancel {
+				runningSyncs.Lock()
+				currentSync := runningSyncs.Data["fake:/data"]
+				currentSync.CancelledBy = "tester"
+				currentSync.Cancel()
+				runningSyncs.Unlock()
+			}
+
+			entry := waitForEvent(t, events, tt.event).Result
+			if entry == nil {
+				t.Fatalf("%s event has no result", tt.event)
+			}
+			if entry.ID != result.ID || entry.Path != "fake:/data" {
+				t.Errorf("result = %s %s, want %s fake:/data", entry.ID, entry.Path, result.ID)
+			}
+			if entry.Status != tt.status {
+				t.Errorf("status = %q, want %q (error %q)", entry.Status, tt.status, entry.Error)
+			}
+			if entry.Attempts != tt.attempts {
+				t.Errorf("attempts = %d, want %d", entry.Attempts, tt.attempts)
+			}
+			if entry.CancelledBy != tt.cancelled {
+				t.Errorf("cancelled by = %q, want %q", entry.CancelledBy, tt.cancelled)
+			}
+			if tt.status == syncStatusFinished && entry.Progress != 100 {
+				t.Errorf("progress = %d, want 100", entry.Progress)
+			}
+
+			waitForEvent(t, events, eventRemove)
+			runningSyncs.Lock()
+			if _, ok := runningSyncs.Data["fake:/data"]; ok {
+				t.Errorf("sync still listed after %s", tt.event)
+			}
+			runningSyncs.Unlock()
+
+			// The history is written once the sync is gone from the list.
+			deadline := time.Now().Add(fakeTestTimeout)
+			for {
+				if recorded, ok := history.Get(result.ID); ok {
+					if recorded.Status != tt.status {
+						t.Errorf("history status = %q, want %q", recorded.Status, tt.status)
+					}
+					break
+				}
+				if time.Now().After(deadline) {
+					t.Fatalf("sync %s not recorded in history", result.ID)
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+		})
+	}
+}
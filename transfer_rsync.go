@@ -0,0 +1,349 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	s "sync"
+	"syscall"
+	"time"
+
+	"github.com/docker/go-units"
+)
+
+// itemizeLine matches the "%i %l %n" output format: the 11 character change
+// summary (or "*deleting"), the file size and the path.
+var itemizeLine = regexp.MustCompile(`^(\*deleting|[<>ch.][fdLDS][.+ ?a-zA-Z]{9})\s+(\d+) (.+)$`)
+
+// rsyncBackend runs the rsync binary over ssh.
+type rsyncBackend struct {
+	remote *Remote
+}
+
+type rsyncTransfer struct {
+	cmd      *exec.Cmd
+	kill     context.CancelFunc
+	progress chan TransferProgress
+	done     chan struct{}
+	cancel   s.Once
+	logger   *slog.Logger
+	result   TransferResult
+	err      error
+}
+
+// rsyncCommand builds an rsync run that pulls remotePath into dest over ssh,
+// using the remote's key and known hosts.
+func rsyncCommand(ctx context.Context, remote *Remote, remotePath string, dest string, options ...string) *exec.Cmd {
+	args := append(options,
+		"-e", fmt.Sprintf("ssh -i %s -p %d -o UserKnownHostsFile=%s -o StrictHostKeyChecking=yes -o PasswordAuthentication=no", remote.RsyncSSHKey, remote.Port, remote.KnownHosts),
+		fmt.Sprintf("%s@%s:%s", remote.User, remote.Host, filepath.Join(remotePath)),
+		dest,
+	)
+	return exec.CommandContext(ctx, "rsync", args...)
+}
+
+func (b *rsyncBackend) Start(logger *slog.Logger, options TransferOptions) (Transfer, error) {
+	cmdCtx, kill := context.WithCancel(context.Background())
+
+	args := []string{"-a", "--partial", "--info=progress2"}
+	if options.BwLimit > 0 {
+		args = append(args, fmt.Sprintf("--bwlimit=%d", options.BwLimit))
+	}
+	args = append(args, filterArgs(options.Filter)...)
+
+	var logFile string
+	if options.Mirror {
+		file, err := os.CreateTemp("", "syncer-rsync-*.log")
+		if err != nil {
+			kill()
+			return nil, fmt.Errorf("create rsync log: %w", err)
+		}
+		file.Close()
+		logFile = file.Name()
+		args = append(args, "--delete-after", "--log-file="+logFile, "--log-file-format=%i %n")
+	}
+
+	cmd := rsyncCommand(cmdCtx, b.remote, options.RemotePath, options.LocalDir, args...)
+
+	logger.Debug("rsync cmd", slog.Any("args", cmd.Args))
+
+	transfer := &rsyncTransfer{
+		cmd:      cmd,
+		kill:     kill,
+		progress: make(chan TransferProgress),
+		done:     make(chan struct{}),
+		logger:   logger,
+	}
+	cleanup := func() {
+		kill()
+		if logFile != "" {
+			os.Remove(logFile)
+		}
+	}
+
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		cleanup()
+		return nil, fmt.Errorf("stderr pipe: %w", err)
+	}
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		cleanup()
+		return nil, fmt.Errorf("stdout pipe: %w", err)
+	}
+
+	err = cmd.Start()
+	if err != nil {
+		cleanup()
+		return nil, fmt.Errorf("start command: %w", err)
+	}
+
+	var output s.WaitGroup
+	output.Add(2)
+
+	go func() {
+		defer output.Done()
+		scanner := bufio.NewScanner(stderr)
+		for scanner.Scan() {
+			options.Output(scanner.Text())
+		}
+	}()
+
+	go func() {
+		defer output.Done()
+		var progress TransferProgress
+		scanner := bufio.NewScanner(stdout)
+		scanner.Split(bufio.ScanWords)
+		for scanner.Scan() {
+			parseProgress(logger, &progress, scanner.Text())
+			transfer.progress <- progress
+		}
+	}()
+
+	go func() {
+		defer close(transfer.done)
+		defer cleanup()
+
+		output.Wait()
+		transfer.err = cmd.Wait()
+		close(transfer.progress)
+
+		if logFile != "" {
+			deleted, err := readDeletions(logFile)
+			if err != nil {
+				logger.Error("read deletions", slog.String("path", options.RemotePath), slog.String("error", err.Error()))
+			}
+			transfer.result.Deleted = deleted
+		}
+	}()
+
+	return transfer, nil
+}
+
+func (t *rsyncTransfer) Progress() <-chan TransferProgress {
+	return t.progress
+}
+
+// Cancel asks rsync to stop with SIGTERM and only kills it if it is still
+// running after a grace period.
+func (t *rsyncTransfer) Cancel() {
+	t.cancel.Do(func() {
+		err := t.cmd.Process.Signal(syscall.SIGTERM)
+		if err != nil {
+			t.logger.Error("terminate err", slog.String("error", err.Error()))
+		}
+		go func() {
+			select {
+			case <-t.done:
+			case <-time.After(time.Second * 5):
+				t.kill()
+			}
+		}()
+	})
+}
+
+func (t *rsyncTransfer) Wait() (TransferResult, error) {
+	<-t.done
+	return t.result, t.err
+}
+
+// parseProgress applies one word of rsync's --info=progress2 output to
+// progress.
+func parseProgress(logger *slog.Logger, progress *TransferProgress, text string) {
+	if strings.HasSuffix(text, "%") {
+		v, err := strconv.Atoi(strings.TrimSuffix(text, "%"))
+		if err != nil {
+			logger.Error("failed parse string", slog.String("value", text), slog.String("error", err.Error()))
+		} else {
+			progress.Progress = uint(v)
+		}
+		return
+	}
+	if strings.HasSuffix(text, "/s") {
+		v, err := units.FromHumanSize(strings.TrimSuffix(text, "/s"))
+		if err != nil {
+			logger.Error("failed parse string", slog.String("value", text), slog.String("error", err.Error()))
+		} else {
+			progress.Speed = uint(v)
+		}
+		return
+	}
+	if strings.Contains(text, ":") {
+		progress.TimeLeft = text
+		return
+	}
+	v, err := strconv.Atoi(strings.ReplaceAll(text, ",", ""))
+	if err != nil {
+		logger.Error("failed parse string", slog.String("value", text), slog.String("error", err.Error()))
+	} else {
+		progress.Downloaded = uint(v)
+	}
+}
+
+// readDeletions returns the paths rsync logged as deleted to logFile.
+func readDeletions(logFile string) ([]string, error) {
+	file, err := os.Open(logFile)
+	if err != nil {
+		return nil, fmt.Errorf("open rsync log: %w", err)
+	}
+	defer file.Close()
+
+	var deleted []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		_, path, ok := strings.Cut(scanner.Text(), "*deleting")
+		if !ok {
+			continue
+		}
+		deleted = append(deleted, strings.TrimSpace(path))
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read rsync log: %w", err)
+	}
+	return deleted, nil
+}
+
+func (b *rsyncBackend) Preview(logger *slog.Logger, ctx context.Context, options TransferOptions) (SyncPreview, error) {
+	preview := newSyncPreview(options.RemotePath)
+
+	args := append([]string{"-a", "--dry-run", "--itemize-changes", "--out-format=%i %l %n", "--stats"}, filterArgs(options.Filter)...)
+	if options.Mirror {
+		args = append(args, "--delete-after")
+	}
+	cmd := rsyncCommand(ctx, b.remote, options.RemotePath, options.LocalDir, args...)
+
+	logger.Debug("rsync preview cmd", slog.Any("args", cmd.Args))
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return SyncPreview{}, fmt.Errorf("stdout pipe: %w", err)
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return SyncPreview{}, fmt.Errorf("stderr pipe: %w", err)
+	}
+
+	if err := cmd.Start(); err != nil {
+		return SyncPreview{}, fmt.Errorf("start command: %w", err)
+	}
+
+	errLines := newTailBuffer(stderrTailLines)
+	errDone := make(chan struct{})
+	go func() {
+		defer close(errDone)
+		scanner := bufio.NewScanner(stderr)
+		for scanner.Scan() {
+			logger.Info(scanner.Text())
+			errLines.Add(scanner.Text())
+		}
+	}()
+
+	var statsBytes uint64
+	statsFound := false
+	scanner := bufio.NewScanner(stdout)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if v, ok := parseStatsSize(line, "Total transferred file size"); ok {
+			statsBytes, statsFound = v, true
+			continue
+		}
+
+		item, ok := parsePreviewLine(line)
+		if !ok {
+			continue
+		}
+		if item.Change == previewChangeDeleted && !item.Dir {
+			// rsync does not report sizes for deletions, so look at the local copy.
+			if info, err := os.Lstat(filepath.Join(options.LocalDir, item.Path)); err == nil {
+				item.Size = uint64(info.Size())
+			}
+		}
+		preview.add(item)
+	}
+
+	<-errDone
+	if err := cmd.Wait(); err != nil {
+		return SyncPreview{}, fmt.Errorf("rsync dry run: %w: %s", err, strings.Join(errLines.Lines(), " | "))
+	}
+
+	if statsFound {
+		preview.TotalBytes = statsBytes
+	}
+	return preview, nil
+}
+
+// parsePreviewLine reads one line of rsync's itemized dry-run output. Lines
+// for items that would not be transferred, such as directories that only get
+// new timestamps, are skipped.
+func parsePreviewLine(line string) (PreviewItem, bool) {
+	match := itemizeLine.FindStringSubmatch(line)
+	if match == nil {
+		return PreviewItem{}, false
+	}
+
+	flags := match[1]
+	size, _ := strconv.ParseUint(match[2], 10, 64)
+	item := PreviewItem{
+		Path: strings.TrimSuffix(match[3], "/"),
+		Dir:  strings.HasSuffix(match[3], "/"),
+		Size: size,
+	}
+
+	switch {
+	case flags == "*deleting":
+		item.Change = previewChangeDeleted
+	case flags[0] == '.':
+		return PreviewItem{}, false
+	case strings.Trim(flags[2:], "+") == "":
+		item.Change = previewChangeNew
+	default:
+		item.Change = previewChangeChanged
+	}
+	if item.Change != previewChangeDeleted && flags[1] == 'd' {
+		item.Dir = true
+	}
+	if item.Dir {
+		item.Size = 0
+	}
+	return item, true
+}
+
+// parseStatsSize reads a byte count from a --stats line such as
+// "Total transferred file size: 1,234 bytes".
+func parseStatsSize(line string, name string) (uint64, bool) {
+	value, ok := strings.CutPrefix(line, name+":")
+	if !ok {
+		return 0, false
+	}
+	value = strings.TrimSuffix(strings.TrimSpace(value), " bytes")
+	v, err := strconv.ParseUint(strings.ReplaceAll(value, ",", ""), 10, 64)
+	return v, err == nil
+}
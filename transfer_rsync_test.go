@@ -0,0 +1,69 @@
+package main
+
+import "testing"
+
+func TestParsePreviewLine(t *testing.T) {
+	tests := []struct {
+		name string
+		line string
+		want PreviewItem
+		ok   bool
+	}{
+		{
+			name: "new file",
+			line: ">f+++++++++ 1234 dir/file.txt",
+			want: PreviewItem{Path: "dir/file.txt", Change: previewChangeNew, Size: 1234},
+			ok:   true,
+		},
+		{
+			name: "changed file",
+			line: ">f.st...... 99 file.txt",
+			want: PreviewItem{Path: "file.txt", Change: previewChangeChanged, Size: 99},
+			ok:   true,
+		},
+		{
+			name: "new dir has no size",
+			line: "cd+++++++++ 4096 dir/sub/",
+			want: PreviewItem{Path: "dir/sub", Change: previewChangeNew, Dir: true},
+			ok:   true,
+		},
+		{
+			name: "deleted file",
+			line: "*deleting   0 old.txt",
+			want: PreviewItem{Path: "old.txt", Change: previewChangeDeleted},
+			ok:   true,
+		},
+		{
+			name: "deleted dir",
+			line: "*deleting   0 old/",
+			want: PreviewItem{Path: "old", Change: previewChangeDeleted, Dir: true},
+			ok:   true,
+		},
+		{
+			name: "name with spaces",
+			line: ">f+++++++++ 5 my dir/my file",
+			want: PreviewItem{Path: "my dir/my file", Change: previewChangeNew, Size: 5},
+			ok:   true,
+		},
+		{
+			name: "timestamp only",
+			line: ".d..t...... 4096 dir/",
+		},
+		{
+			name: "stats line",
+			line: "Number of files: 3",
+		},
+		{
+			name: "empty",
+			line: "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := parsePreviewLine(tt.line)
+			if ok != tt.ok || got != tt.want {
+				t.Errorf("parsePreviewLine(%q) = %+v, %v, want %+v, %v", tt.line, got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
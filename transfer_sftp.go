@@ -0,0 +1,613 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/pkg/sftp"
+)
+
+const sftpChunkSize = 32 * 1024
+
+// sftpBackend copies files with a pure Go SFTP client, so the container does
+// not need the rsync binary. It uses the rsync ssh key and mimics rsync -a: a
+// file is copied when its size or modification time differ, interrupted
+// copies are resumed from a hidden .partial file and filter rules follow a
+// subset of rsync's pattern syntax.
+type sftpBackend struct {
+	remote *Remote
+}
+
+type sftpTransfer struct {
+	cancel   context.CancelFunc
+	progress chan TransferProgress
+	done     chan struct{}
+	result   TransferResult
+	err      error
+}
+
+// sftpEntry is a remote item that needs to be copied, or a local item a mirror
+// sync deletes. Name is relative to the transfer root, like rsync reports it.
+type sftpEntry struct {
+	name   string
+	remote string
+	change string
+	dir    bool
+	link   string
+	mode   fs.FileMode
+	size   int64
+	mtime  time.Time
+	// replace is set when the local item is of a different type and has to go
+	// before the remote one is copied.
+	replace bool
+}
+
+type sftpPlan struct {
+	copy   []sftpEntry
+	dirs   []sftpEntry
+	delete []sftpEntry
+	total  int64
+}
+
+type sftpRule struct {
+	include bool
+	dirOnly bool
+	pattern *regexp.Regexp
+}
+
+// sftpMeter counts copied bytes, holds the copy back to the bandwidth limit and
+// reports progress.
+type sftpMeter struct {
+	progress chan<- TransferProgress
+	limit    uint
+	total    int64
+	done     int64
+	started  time.Time
+	sent     time.Time
+	sentDone int64
+	speed    uint
+}
+
+// remoteError marks err as an interrupted transfer worth retrying, unless the
+// remote refused the request outright, e.g. for a missing file.
+func remoteError(err error) error {
+	var status *sftp.StatusError
+	if errors.As(err, &status) || errors.Is(err, fs.ErrNotExist) || errors.Is(err, fs.ErrPermission) {
+		return err
+	}
+	return fmt.Errorf("%w: %w", errTransferInterrupted, err)
+}
+
+// connect opens an SFTP session that is torn down as soon as ctx is done, which
+// also unblocks any read in progress.
+func (b *sftpBackend) connect(ctx context.Context) (*sftp.Client, func(), error) {
+	sshClient, err := dialSSH(ctx, b.remote, b.remote.RsyncSSHKey)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	client, err := sftp.NewClient(sshClient)
+	if err != nil {
+		sshClient.Close()
+		return nil, nil, fmt.Errorf("%w: start sftp: %w", errTransferInterrupted, err)
+	}
+
+	stop := context.AfterFunc(ctx, func() {
+		sshClient.Close()
+	})
+	return client, func() {
+		stop()
+		client.Close()
+		sshClient.Close()
+	}, nil
+}
+
+// compileSFTPRules turns "+ pattern" and "- pattern" filter rules into
+// regular expressions. Like rsync, "*" and "?" stop at slashes, "**" does not,
+// a leading slash anchors the pattern at the transfer root, a pattern with a
+// slash elsewhere matches the tail of the path, a pattern without one matches
+// the name and a trailing slash only matches directories.
+func compileSFTPRules(rules []string) ([]sftpRule, error) {
+	compiled := make([]sftpRule, 0, len(rules))
+	for _, rule := range rules {
+		kind, pattern, ok := strings.Cut(rule, " ")
+		if !ok || (kind != "+" && kind != "-") {
+			return nil, fmt.Errorf("unsupported filter rule %q", rule)
+		}
+
+		compiledRule := sftpRule{include: kind == "+"}
+		if strings.HasSuffix(pattern, "/") {
+			compiledRule.dirOnly = true
+			pattern = strings.TrimRight(pattern, "/")
+		}
+
+		prefix := "(^|/)"
+		if strings.HasPrefix(pattern, "/") {
+			prefix = "^"
+			pattern = strings.TrimLeft(pattern, "/")
+		}
+
+		re, err := regexp.Compile(prefix + globRegexp(pattern) + "$")
+		if err != nil {
+			return nil, fmt.Errorf("filter rule %q: %w", rule, err)
+		}
+		compiledRule.pattern = re
+		compiled = append(compiled, compiledRule)
+	}
+	return compiled, nil
+}
+
+func globRegexp(pattern string) string {
+	var b strings.Builder
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; c {
+		case '*':
+			if i+1 < len(pattern) && pattern[i+1] == '*' {
+				for i+1 < len(pattern) && pattern[i+1] == '*' {
+					i++
+				}
+				b.WriteString(".*")
+			} else {
+				b.WriteString("[^/]*")
+			}
+		case '?':
+			b.WriteString("[^/]")
+		case '[':
+			end := strings.IndexByte(pattern[i+1:], ']')
+			if end < 0 {
+				b.WriteString(`\[`)
+				continue
+			}
+			class := pattern[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			b.WriteString("[" + strings.ReplaceAll(class, `\`, `\\`) + "]")
+			i += end + 1
+		case '\\':
+			if i+1 < len(pattern) {
+				i++
+				b.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+			}
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	return b.String()
+}
+
+// sftpIncluded applies the first matching rule to name. Names no rule matches
+// are included.
+func sftpIncluded(rules []sftpRule, name string, dir bool) bool {
+	for _, rule := range rules {
+		if rule.dirOnly && !dir {
+			continue
+		}
+		if rule.pattern.MatchString(name) {
+			return rule.include
+		}
+	}
+	return true
+}
+
+// plan compares the remote tree with the local copy the same way rsync's quick
+// check does.
+func (b *sftpBackend) plan(client *sftp.Client, options TransferOptions, rules []sftpRule) (sftpPlan, error) {
+	var plan sftpPlan
+
+	root := path.Clean(options.RemotePath)
+	base := path.Base(root)
+	seen := map[string]struct{}{}
+
+	walker := client.Walk(root)
+	for walker.Step() {
+		if err := walker.Err(); err != nil {
+			return sftpPlan{}, fmt.Errorf("walk remote %s: %w", walker.Path(), remoteError(err))
+		}
+
+		info := walker.Stat()
+		name := base + strings.TrimPrefix(walker.Path(), root)
+		if name != base && !sftpIncluded(rules, name, info.IsDir()) {
+			if info.IsDir() {
+				walker.SkipDir()
+			}
+			continue
+		}
+		if !info.IsDir() && !info.Mode().IsRegular() && info.Mode()&fs.ModeSymlink == 0 {
+			// Like rsync -a without --devices and --specials.
+			continue
+		}
+		seen[name] = struct{}{}
+
+		entry := sftpEntry{
+			name:   name,
+			remote: walker.Path(),
+			dir:    info.IsDir(),
+			mode:   info.Mode(),
+			size:   info.Size(),
+			mtime:  info.ModTime(),
+		}
+		if entry.dir {
+			entry.size = 0
+			plan.dirs = append(plan.dirs, entry)
+		}
+		if entry.mode&fs.ModeSymlink != 0 {
+			link, err := client.ReadLink(walker.Path())
+			if err != nil {
+				return sftpPlan{}, fmt.Errorf("read remote link %s: %w", walker.Path(), remoteError(err))
+			}
+			entry.link = link
+			entry.size = 0
+		}
+
+		local, err := os.Lstat(filepath.Join(options.LocalDir, filepath.FromSlash(name)))
+		switch {
+		case errors.Is(err, fs.ErrNotExist):
+			entry.change = previewChangeNew
+		case err != nil:
+			return sftpPlan{}, fmt.Errorf("stat local: %w", err)
+		case local.Mode().Type() != entry.mode.Type():
+			entry.change = previewChangeChanged
+			entry.replace = true
+		case entry.dir:
+			continue
+		case entry.link != "":
+			target, err := os.Readlink(filepath.Join(options.LocalDir, filepath.FromSlash(name)))
+			if err == nil && target == entry.link {
+				continue
+			}
+			entry.change = previewChangeChanged
+			entry.replace = true
+		case local.Size() == entry.size && local.ModTime().Unix() == entry.mtime.Unix():
+			continue
+		default:
+			entry.change = previewChangeChanged
+		}
+
+		plan.copy = append(plan.copy, entry)
+		plan.total += entry.size
+	}
+
+	if options.Mirror {
+		localRoot := filepath.Join(options.LocalDir, base)
+		err := filepath.WalkDir(localRoot, func(localPath string, d fs.DirEntry, err error) error {
+			if errors.Is(err, fs.ErrNotExist) && localPath == localRoot {
+				return filepath.SkipAll
+			}
+			if err != nil {
+				return err
+			}
+
+			rel, err := filepath.Rel(options.LocalDir, localPath)
+			if err != nil {
+				return err
+			}
+			name := filepath.ToSlash(rel)
+			if _, ok := seen[name]; ok || name == base {
+				return nil
+			}
+			// Excluded files are protected from deletion, as in rsync without
+			// --delete-excluded.
+			if !sftpIncluded(rules, name, d.IsDir()) {
+				if d.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			entry := sftpEntry{
+				name:   name,
+				change: previewChangeDeleted,
+				dir:    d.IsDir(),
+			}
+			if info.Mode().IsRegular() {
+				entry.size = info.Size()
+			}
+			// Directories are walked into, so that every file counts towards
+			// the mirror limits as it does with rsync.
+			plan.delete = append(plan.delete, entry)
+			return nil
+		})
+		if err != nil {
+			return sftpPlan{}, fmt.Errorf("walk local: %w", err)
+		}
+	}
+
+	return plan, nil
+}
+
+func (b *sftpBackend) Start(logger *slog.Logger, options TransferOptions) (Transfer, error) {
+	rules, err := compileSFTPRules(options.Filter)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	transfer := &sftpTransfer{
+		cancel:   cancel,
+		progress: make(chan TransferProgress),
+		done:     make(chan struct{}),
+	}
+
+	go func() {
+		defer close(transfer.done)
+		defer cancel()
+
+		transfer.result, transfer.err = b.run(logger, ctx, options, rules, transfer.progress)
+		close(transfer.progress)
+
+		if ctx.Err() != nil {
+			transfer.err = ctx.Err()
+		} else if transfer.err != nil {
+			options.Output(transfer.err.Error())
+		}
+	}()
+
+	return transfer, nil
+}
+
+func (b *sftpBackend) run(logger *slog.Logger, ctx context.Context, options TransferOptions, rules []sftpRule, progress chan<- TransferProgress) (TransferResult, error) {
+	client, closeClient, err := b.connect(ctx)
+	if err != nil {
+		return TransferResult{}, err
+	}
+	defer closeClient()
+
+	plan, err := b.plan(client, options, rules)
+	if err != nil {
+		return TransferResult{}, err
+	}
+
+	logger.Debug("sftp plan",
+		slog.String("path", options.RemotePath),
+		slog.Int("copy", len(plan.copy)),
+		slog.Int("delete", len(plan.delete)),
+		slog.Int64("bytes", plan.total),
+	)
+
+	meter := &sftpMeter{
+		progress: progress,
+		limit:    options.BwLimit,
+		total:    plan.total,
+		started:  time.Now(),
+		sent:     time.Now(),
+	}
+
+	for _, entry := range plan.copy {
+		local := filepath.Join(options.LocalDir, filepath.FromSlash(entry.name))
+		if entry.replace {
+			if err := os.RemoveAll(local); err != nil {
+				return TransferResult{}, fmt.Errorf("replace %s: %w", entry.name, err)
+			}
+		}
+
+		switch {
+		case entry.dir:
+			err = os.MkdirAll(local, 0755)
+		case entry.link != "":
+			err = os.Symlink(entry.link, local)
+		default:
+			err = copySFTPFile(ctx, client, entry, local, meter)
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return TransferResult{}, ctx.Err()
+			}
+			return TransferResult{}, fmt.Errorf("copy %s: %w", entry.name, err)
+		}
+	}
+
+	// Copying files into a directory touches it, so permissions and times of
+	// directories are set once everything else is in place.
+	for i := len(plan.dirs) - 1; i >= 0; i-- {
+		entry := plan.dirs[i]
+		local := filepath.Join(options.LocalDir, filepath.FromSlash(entry.name))
+		if err := os.Chmod(local, entry.mode.Perm()); err != nil {
+			return TransferResult{}, fmt.Errorf("chmod %s: %w", entry.name, err)
+		}
+		if err := os.Chtimes(local, entry.mtime, entry.mtime); err != nil {
+			return TransferResult{}, fmt.Errorf("chtimes %s: %w", entry.name, err)
+		}
+	}
+
+	// Deletions are planned parents first, so they are done the other way
+	// round. A directory still holding excluded files is kept, as rsync does.
+	var result TransferResult
+	for _, entry := range slices.Backward(plan.delete) {
+		local := filepath.Join(options.LocalDir, filepath.FromSlash(entry.name))
+		if err := os.Remove(local); err != nil {
+			if entries, readErr := os.ReadDir(local); entry.dir && readErr == nil && len(entries) > 0 {
+				options.Output(fmt.Sprintf("cannot delete non-empty directory: %s", entry.name))
+				continue
+			}
+			return result, fmt.Errorf("delete %s: %w", entry.name, err)
+		}
+		name := entry.name
+		if entry.dir {
+			name += "/"
+		}
+		result.Deleted = append(result.Deleted, name)
+	}
+
+	meter.report(ctx)
+	return result, nil
+}
+
+// copySFTPFile downloads entry to a hidden .partial file next to local and
+// renames it into place once complete. A .partial file left by an interrupted
+// attempt is resumed when it still carries the remote modification time.
+func copySFTPFile(ctx context.Context, client *sftp.Client, entry sftpEntry, local string, meter *sftpMeter) error {
+	partial := filepath.Join(filepath.Dir(local), "."+filepath.Base(local)+".partial")
+
+	var offset int64
+	if info, err := os.Stat(partial); err == nil && info.Size() <= entry.size && info.ModTime().Unix() == entry.mtime.Unix() {
+		offset = info.Size()
+	}
+
+	remote, err := client.Open(entry.remote)
+	if err != nil {
+		return remoteError(err)
+	}
+	defer remote.Close()
+
+	if _, err := remote.Seek(offset, io.SeekStart); err != nil {
+		return remoteError(err)
+	}
+
+	flags := os.O_CREATE | os.O_WRONLY | os.O_APPEND
+	if offset == 0 {
+		flags |= os.O_TRUNC
+	}
+	file, err := os.OpenFile(partial, flags, 0600)
+	if err != nil {
+		return fmt.Errorf("open partial: %w", err)
+	}
+
+	copyErr := func() error {
+		buf := make([]byte, sftpChunkSize)
+		for {
+			n, err := remote.Read(buf)
+			if n > 0 {
+				if _, err := file.Write(buf[:n]); err != nil {
+					return fmt.Errorf("write partial: %w", err)
+				}
+				if err := meter.add(ctx, n); err != nil {
+					return err
+				}
+			}
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			if err != nil {
+				return remoteError(err)
+			}
+		}
+	}()
+
+	closeErr := file.Close()
+	// Stamp the partial file so the next attempt knows which remote version it
+	// belongs to.
+	if err := os.Chtimes(partial, entry.mtime, entry.mtime); err != nil && copyErr == nil {
+		copyErr = fmt.Errorf("chtimes partial: %w", err)
+	}
+	if copyErr != nil {
+		return copyErr
+	}
+	if closeErr != nil {
+		return fmt.Errorf("close partial: %w", closeErr)
+	}
+
+	if err := os.Chmod(partial, entry.mode.Perm()); err != nil {
+		return fmt.Errorf("chmod partial: %w", err)
+	}
+	if err := os.Rename(partial, local); err != nil {
+		return fmt.Errorf("rename partial: %w", err)
+	}
+	return nil
+}
+
+// add counts n copied bytes and sleeps for as long as it takes to stay under
+// the bandwidth limit.
+func (m *sftpMeter) add(ctx context.Context, n int) error {
+	m.done += int64(n)
+
+	if m.limit > 0 {
+		due := time.Duration(float64(m.done) / float64(m.limit*1024) * float64(time.Second))
+		if wait := due - time.Since(m.started); wait > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(wait):
+			}
+		}
+	}
+
+	if time.Since(m.sent) >= progressEventInterval {
+		m.report(ctx)
+	}
+	return ctx.Err()
+}
+
+func (m *sftpMeter) report(ctx context.Context) {
+	now := time.Now()
+	if elapsed := now.Sub(m.sent).Seconds(); elapsed > 0 {
+		m.speed = uint(float64(m.done-m.sentDone) / elapsed)
+	}
+	m.sent = now
+	m.sentDone = m.done
+
+	progress := TransferProgress{
+		Progress:   100,
+		Speed:      m.speed,
+		Downloaded: uint(m.done),
+		TimeLeft:   formatTimeLeft(0),
+	}
+	if m.total > 0 {
+		progress.Progress = uint(min(m.done*100/m.total, 100))
+	}
+	if m.speed > 0 && m.done < m.total {
+		progress.TimeLeft = formatTimeLeft(time.Duration(float64(m.total-m.done) / float64(m.speed) * float64(time.Second)))
+	}
+
+	select {
+	case m.progress <- progress:
+	case <-ctx.Done():
+	}
+}
+
+func (t *sftpTransfer) Progress() <-chan TransferProgress {
+	return t.progress
+}
+
+func (t *sftpTransfer) Cancel() {
+	t.cancel()
+}
+
+func (t *sftpTransfer) Wait() (TransferResult, error) {
+	<-t.done
+	return t.result, t.err
+}
+
+func (b *sftpBackend) Preview(logger *slog.Logger, ctx context.Context, options TransferOptions) (SyncPreview, error) {
+	rules, err := compileSFTPRules(options.Filter)
+	if err != nil {
+		return SyncPreview{}, err
+	}
+
+	client, closeClient, err := b.connect(ctx)
+	if err != nil {
+		return SyncPreview{}, err
+	}
+	defer closeClient()
+
+	plan, err := b.plan(client, options, rules)
+	if err != nil {
+		return SyncPreview{}, err
+	}
+
+	preview := newSyncPreview(options.RemotePath)
+	for _, entry := range append(plan.copy, plan.delete...) {
+		preview.add(PreviewItem{
+			Path:   entry.name,
+			Change: entry.change,
+			Dir:    entry.dir,
+			Size:   uint64(entry.size),
+		})
+	}
+	return preview, nil
+}
@@ -0,0 +1,120 @@
+package main
+
+import "testing"
+
+func TestCompileSFTPRules(t *testing.T) {
+	type check struct {
+		name string
+		dir  bool
+		want bool
+	}
+	tests := []struct {
+		name    string
+		rules   []string
+		checks  []check
+		wantErr bool
+	}{
+		{
+			name:  "no rules include everything",
+			rules: nil,
+			checks: []check{
+				{name: "a/b.txt", want: true},
+			},
+		},
+		{
+			name:  "star stops at slashes",
+			rules: []string{"- *.tmp"},
+			checks: []check{
+				{name: "x.tmp", want: false},
+				{name: "dir/x.tmp", want: false},
+				{name: "x.tmp/y", want: true},
+				{name: "x.txt", want: true},
+			},
+		},
+		{
+			name:  "double star crosses slashes",
+			rules: []string{"- /cache/**"},
+			checks: []check{
+				{name: "cache/a/b", want: false},
+				{name: "sub/cache/a", want: true},
+			},
+		},
+		{
+			name:  "leading slash anchors",
+			rules: []string{"- /build"},
+			checks: []check{
+				{name: "build", want: false},
+				{name: "src/build", want: true},
+			},
+		},
+		{
+			name:  "slash inside matches the tail",
+			rules: []string{"- logs/*.log"},
+			checks: []check{
+				{name: "app/logs/a.log", want: false},
+				{name: "a.log", want: true},
+			},
+		},
+		{
+			name:  "trailing slash only matches dirs",
+			rules: []string{"- tmp/"},
+			checks: []check{
+				{name: "a/tmp", dir: true, want: false},
+				{name: "a/tmp", want: true},
+			},
+		},
+		{
+			name:  "first match wins",
+			rules: []string{"+ keep.log", "- *.log"},
+			checks: []check{
+				{name: "keep.log", want: true},
+				{name: "other.log", want: false},
+			},
+		},
+		{
+			name:  "character classes and question marks",
+			rules: []string{"- [!a]?.txt"},
+			checks: []check{
+				{name: "b1.txt", want: false},
+				{name: "a1.txt", want: true},
+				{name: "b12.txt", want: true},
+			},
+		},
+		{
+			name:  "escaped star is literal",
+			rules: []string{`- a\*`},
+			checks: []check{
+				{name: "a*", want: false},
+				{name: "ab", want: true},
+			},
+		},
+		{
+			name:    "unknown kind",
+			rules:   []string{"! *.tmp"},
+			wantErr: true,
+		},
+		{
+			name:    "missing pattern",
+			rules:   []string{"-"},
+			wantErr: true,
+		},
+		{
+			name:    "invalid class",
+			rules:   []string{"- [z-a]"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rules, err := compileSFTPRules(tt.rules)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("compileSFTPRules(%q) error = %v, want error %v", tt.rules, err, tt.wantErr)
+			}
+			for _, c := range tt.checks {
+				if got := sftpIncluded(rules, c.name, c.dir); got != c.want {
+					t.Errorf("sftpIncluded(%q, dir %v) = %v, want %v", c.name, c.dir, got, c.want)
+				}
+			}
+		})
+	}
+}